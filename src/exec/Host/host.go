@@ -1,18 +1,46 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"tech/app/comms"
+	"tech/app/components"
+	"tech/app/gpio"
 	"tech/app/logger"
+	"tech/app/metrics"
+	"tech/app/nfc"
+	"tech/app/payments"
 	"tech/mixer"
+	"tech/mixer/actuator"
+	"tech/mixer/sensor"
 )
 
 const (
 	logfileName = "Host.log"
 	socketName  = "@/tmp/socketTest.sock"
+
+	nfcScript = "script"
+	nfcNative = "native"
+
+	// simulatedMlPerCycle - What the simulated pumps deliver, more than the default
+	// calibration so the sensor has something to correct
+	simulatedMlPerCycle = 12.0
 )
 
+// sensorConfig - Where the pour sensor is wired
+type sensorConfig struct {
+	kind          string
+	flowPins      string
+	mlPerPulse    float64
+	hx711Data     int
+	hx711Clock    int
+	countsPerGram float64
+}
+
 var gitHash string
 var compileDate string
 
@@ -20,12 +48,30 @@ func main() {
 
 	var logNormal bool
 	var logDebug bool
+	var backend string
+	var estopPin int
+	var estopActiveLow bool
+	var nfcBackend string
+	var sensorCfg sensorConfig
+	var paymentProvider string
 
 	flag.BoolVar(&logNormal, "l", false, "Logs additional application statements")
 	flag.BoolVar(&logDebug, "d", false, "Logs debug statements")
+	flag.StringVar(&backend, "actuator", actuator.BackendScript, "Pump backend: script, native or sim")
+	flag.IntVar(&estopPin, "estop-gpio", -1, "GPIO of the emergency stop button, -1 for none")
+	flag.BoolVar(&estopActiveLow, "estop-active-low", false, "Emergency stop button pulls the input low when pressed")
+	flag.StringVar(&nfcBackend, "nfc", nfcScript, "Card readers: script, or native for the MFRC522 on SPI and PN532 on I2C")
+	flag.StringVar(&sensorCfg.kind, "sensor", sensor.KindNone, "Pour sensor: none, flow, loadcell, or sim with -actuator sim")
+	flag.StringVar(&sensorCfg.flowPins, "flow-gpio", "", "GPIO of each channel's flow meter, comma separated, -1 for none")
+	flag.Float64Var(&sensorCfg.mlPerPulse, "flow-ml-per-pulse", sensor.DefaultMlPerPulse, "Volume of one flow meter pulse")
+	flag.IntVar(&sensorCfg.hx711Data, "hx711-data", -1, "GPIO of the HX711's DOUT")
+	flag.IntVar(&sensorCfg.hx711Clock, "hx711-clock", -1, "GPIO of the HX711's PD_SCK")
+	flag.Float64Var(&sensorCfg.countsPerGram, "hx711-counts-per-gram", 0, "HX711 counts per gram on the load cell")
+	flag.StringVar(&paymentProvider, "payments", payments.ProviderMock, "Provider saved cards are tokenized with: mock")
 	flag.Parse()
 
 	logger.Init("Host")
+	metrics.Init("host")
 	logger.LogToStdout = false
 	if logNormal {
 		logger.LogToStdout = true
@@ -34,8 +80,54 @@ func main() {
 		logger.Debug = true
 	}
 
+	// Count the boot before anything that can exit, so a slot whose hardware or settings
+	// fail to open is still rolled back after MaxBootAttempts
 	mixerDev := mixer.NewMixer()
-	err := mixerDev.Start()
+	mixerDev.Updater.Boot()
+
+	act, err := actuator.New(backend)
+	if err != nil {
+		logger.Log("Failed to create the '%s' actuator, error is %v, exiting", backend, err)
+		return
+	}
+	logger.Log("Driving pumps with the '%s' actuator", act.Name())
+	mixerDev.MixerControl.SetActuator(act)
+	provider, err := payments.New(paymentProvider)
+	if err == nil {
+		err = mixerDev.UserAuth.SetPaymentProvider(provider)
+	}
+	if err != nil {
+		logger.Log("Failed to set up the '%s' payment provider, error is %v, exiting", paymentProvider, err)
+		return
+	}
+	logger.Log("Saving cards with the '%s' payment provider", provider.Name())
+	if nfcBackend == nfcNative {
+		err = openNfcReaders(mixerDev)
+		if err != nil {
+			logger.Log("Failed to open the card readers, error is %v, exiting", err)
+			return
+		}
+	} else if nfcBackend != nfcScript {
+		logger.Log("Unknown card readers '%s', use %s or %s, exiting", nfcBackend, nfcScript, nfcNative)
+		return
+	}
+	if sensorCfg.kind != sensor.KindNone {
+		sens, err := openSensor(sensorCfg, act)
+		if err != nil {
+			logger.Log("Failed to open the '%s' pour sensor, error is %v, exiting", sensorCfg.kind, err)
+			return
+		}
+		logger.Log("Measuring pours with the '%s' sensor", sens.Name())
+		mixerDev.MixerControl.SetSensor(sens)
+	}
+	if estopPin >= 0 {
+		err = watchEmergencyStop(mixerDev, estopPin, estopActiveLow)
+		if err != nil {
+			logger.Log("Failed to watch emergency stop GPIO %d, error is %v, exiting", estopPin, err)
+			return
+		}
+	}
+	err = mixerDev.Start()
 	if err != nil {
 		logger.Log("Failed to initialize subsystems, error is %v, exiting", err)
 		return
@@ -47,9 +139,80 @@ func main() {
 		return
 	}
 
+	// A freshly installed update is healthy once the Server has connected to it
+	go mixerDev.Updater.ConfirmHealthy(func() bool { return host.Connected })
+
 	handleClientRequest(host.Out, host.In, mixerDev)
 }
 
+// watchEmergencyStop - Latches an emergency stop whenever the button on GPIO 'pin' is pressed
+func watchEmergencyStop(dev *mixer.Mixer, pin int, activeLow bool) error {
+	button, err := gpio.Open(pin)
+	if err != nil {
+		return err
+	}
+	logger.Log("Watching emergency stop button on GPIO %d", pin)
+	return dev.MixerControl.WatchEmergencyStopButton(button, activeLow)
+}
+
+// openNfcReaders - Reads identification cards on the MFRC522 and payment cards on the PN532
+func openNfcReaders(dev *mixer.Mixer) error {
+	identify, err := nfc.Open(nfc.ReaderMFRC522)
+	if err != nil {
+		return err
+	}
+	payment, err := nfc.Open(nfc.ReaderPN532)
+	if err != nil {
+		identify.Close()
+		return err
+	}
+	logger.Log("Reading cards with the %s and %s", identify.Name(), payment.Name())
+	dev.MixerControl.SetNfcReaders(identify, payment)
+	return nil
+}
+
+// openSensor - Opens the pour sensor described by 'cfg'. The simulated sensor follows the
+// simulated pumps of 'act'
+func openSensor(cfg sensorConfig, act actuator.Actuator) (sensor.Sensor, error) {
+	switch cfg.kind {
+	case sensor.KindFlow:
+		pins := []int{}
+		for _, field := range strings.Split(cfg.flowPins, ",") {
+			pin, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return nil, fmt.Errorf("-flow-gpio must list GPIO numbers")
+			}
+			pins = append(pins, pin)
+		}
+		return sensor.OpenFlowMeter(pins, cfg.mlPerPulse)
+
+	case sensor.KindLoadCell:
+		if cfg.hx711Data < 0 || cfg.hx711Clock < 0 {
+			return nil, fmt.Errorf("-hx711-data and -hx711-clock are required")
+		}
+		adc, err := sensor.OpenHX711(cfg.hx711Data, cfg.hx711Clock)
+		if err != nil {
+			return nil, err
+		}
+		cell, err := sensor.NewLoadCell(adc, cfg.countsPerGram)
+		if err != nil {
+			adc.Close()
+			return nil, err
+		}
+		return cell, nil
+
+	case sensor.KindSimulated:
+		sim, ok := act.(*actuator.Simulator)
+		if !ok {
+			return nil, fmt.Errorf("the simulated sensor needs -actuator %s", actuator.BackendSimulator)
+		}
+		fake := sensor.NewFake()
+		sim.OnCycle = func(channel int) { fake.Add(simulatedMlPerCycle) }
+		return fake, nil
+	}
+	return nil, fmt.Errorf("unknown sensor, use %s, %s, %s or %s", sensor.KindNone, sensor.KindFlow, sensor.KindLoadCell, sensor.KindSimulated)
+}
+
 func createSocketHost() (*comms.SocketHost, error) {
 	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketName, Net: "unix"})
 	if err != nil {
@@ -65,13 +228,58 @@ func handleClientRequest(in chan comms.Packet, out chan comms.Packet, dev *mixer
 	for {
 		packet := <-in
 		switch packet.Header.Target {
+		case comms.HostTarget:
+			out <- comms.BuildResponsePacket(packet.Header, handleHostRequest(packet.Header.Action, dev))
+
 		default:
-			response, err := dev.Action(packet.Header.Target, packet.Header.Action, packet.Data)
-			if err != nil {
-				logger.Log("Unrecognized target received, '%s', error is '%v'", packet.Header.Target, err)
+			// Pours answer once the drink is poured and card reads once a card is shown,
+			// handle them aside so an EmergencyStop sent meanwhile is not stuck behind them
+			if waitsForPour(packet.Header.Action) || packet.Header.Action == "ReadNfc" {
+				go handleRequest(out, packet, dev)
+			} else {
+				handleRequest(out, packet, dev)
 			}
-
-			out <- comms.BuildResponsePacket(packet.Header, response)
 		}
 	}
 }
+
+func handleRequest(out chan comms.Packet, packet comms.Packet, dev *mixer.Mixer) {
+	response, err := dev.Request(packet.Header, packet.Data)
+	responsePacket := comms.BuildResponsePacket(packet.Header, response)
+	if err != nil {
+		logger.Log("Unrecognized target received, '%s', error is '%v'", packet.Header.Target, err)
+		responsePacket.Header.Error = err.Error()
+		_, responsePacket.Header.Denied = err.(components.PermissionError)
+	}
+
+	out <- responsePacket
+}
+
+// waitsForPour - Actions that block until the pumps have finished
+func waitsForPour(action string) bool {
+	return action == "InitMixing" || action == "OrderRecipe" || action == "StartCalibration"
+}
+
+// handleHostRequest - Answers requests about the Host process itself
+func handleHostRequest(action string, dev *mixer.Mixer) []byte {
+	var response interface{}
+
+	switch action {
+	case comms.HandshakeAction:
+		response = map[string]string{"gitHash": gitHash, "compileDate": compileDate}
+
+	case comms.HealthAction:
+		response = dev.Health()
+
+	default:
+		logger.Log("Unrecognized host action received, '%s'", action)
+		return nil
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		logger.Log("Failed to marshal host response, %v", err)
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"tech/app/logger"
+
+	"github.com/go-chi/chi"
+)
+
+// Queries over the whole audit trail can take longer than a regular command
+const adminTimeout = 5000
+
+// auditQueryParams - URL query parameters forwarded to the audit component
+var auditQueryParams = []string{"actor", "target", "action", "result", "from", "to"}
+
+func configureAdminRoutes(r chi.Router) {
+	r.Get("/audit", auditQueryHandler)
+	r.Get("/audit/export", auditExportHandler)
+	r.Get("/lockouts", lockoutsHandler)
+	r.Post("/lockouts/unlock", unlockHandler)
+
+	r.Get("/users", userHandler("ListUsers"))
+	r.Post("/users", userHandler("CreateUser"))
+	r.Delete("/users/{username}", userHandler("DeleteUser"))
+	r.Put("/users/{username}/role", userHandler("SetRole"))
+	r.Put("/users/{username}/disabled", userHandler("DisableUser"))
+	r.Put("/users/{username}/password", userHandler("ResetPassword"))
+	r.Delete("/users/{username}/2fa", userHandler("DisableTOTP"))
+	r.Delete("/users/{username}/pin", userHandler("ClearPin"))
+	r.Get("/users/{username}/cards", userHandler("ListCards"))
+	r.Post("/users/{username}/cards", userHandler("EnrollCard"))
+	r.Delete("/users/{username}/cards/{id}", userHandler("RemoveCard"))
+
+	r.Get("/2fa/policy", userHandler("GetTwoFactorPolicy"))
+	r.Put("/2fa/policy", userHandler("SetTwoFactorPolicy"))
+}
+
+// auditFilter - Converts the URL query into the filter accepted by the audit component
+func auditFilter(r *http.Request) map[string]interface{} {
+	query := r.URL.Query()
+	filter := make(map[string]interface{})
+	for _, name := range auditQueryParams {
+		if value := query.Get(name); value != "" {
+			filter[name] = value
+		}
+	}
+	for _, name := range []string{"limit", "offset"} {
+		if value, err := strconv.Atoi(query.Get(name)); err == nil {
+			filter[name] = value
+		}
+	}
+	return filter
+}
+
+func auditQueryHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := hostRequest(w, r, "audit", "Query", auditFilter(r))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func auditExportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "json" {
+		format = "csv"
+	}
+
+	filter := auditFilter(r)
+	filter["format"] = format
+	data, ok := hostRequest(w, r, "audit", "Export", filter)
+	if !ok {
+		return
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename=\"audit."+format+"\"")
+	w.Write(data)
+}
+
+func lockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := hostRequest(w, r, "userAuth", "GetLockouts", nil)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// unlockHandler - Body is {"username": "..."} or {"source": "<address>"}
+func unlockHandler(w http.ResponseWriter, r *http.Request) {
+	var params map[string]string
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
+	data, ok := hostRequest(w, r, "userAuth", "Unlock", params)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// userHandler - Forwards the JSON body to a userAuth management action, with the username
+// and card id taken from the URL when present
+func userHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := make(map[string]interface{})
+		if r.ContentLength != 0 && r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			err := json.NewDecoder(r.Body).Decode(&params)
+			if err != nil {
+				http.Error(w, http.StatusText(400), 400)
+				return
+			}
+		}
+		if username := chi.URLParam(r, "username"); username != "" {
+			params["username"] = username
+		}
+		if id := chi.URLParam(r, "id"); id != "" {
+			value, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				http.Error(w, http.StatusText(400), 400)
+				return
+			}
+			params["id"] = value
+		}
+
+		data, ok := hostRequest(w, r, "userAuth", action, params)
+		if !ok {
+			return
+		}
+
+		if data == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if action == "CreateUser" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write(data)
+	}
+}
+
+// hostRequest - Sends 'params' to the Host on behalf of the caller and writes an error
+// response if it fails. Returns the response data and whether the request succeeded
+func hostRequest(w http.ResponseWriter, r *http.Request, target string, action string, params interface{}) ([]byte, bool) {
+
+	if env.client == nil {
+		logger.Log("Command client not available")
+		http.Error(w, http.StatusText(500), 500)
+		return nil, false
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return nil, false
+	}
+
+	resp, err := env.client.Send(requestPacket(r, target, action, data), adminTimeout)
+	if err != nil {
+		logger.Log("Failed to execute %s %s, %v", target, action, err)
+		http.Error(w, http.StatusText(500), 500)
+		return nil, false
+	}
+
+	switch {
+	case resp.Header.Denied && sessionToken(r) == "":
+		http.Error(w, http.StatusText(401), 401)
+		return nil, false
+	case resp.Header.Denied:
+		http.Error(w, http.StatusText(403), 403)
+		return nil, false
+	case resp.Header.Error != "":
+		http.Error(w, resp.Header.Error, 400)
+		return nil, false
+	}
+
+	return resp.Data, true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+)
+
+const (
+	defaultConfigPath = "/data/server.json"
+	defaultListenAddr = ":8080"
+
+	// Policy names. Requests from the device itself come from the kiosk browser
+	kioskPolicy  = "kiosk"
+	remotePolicy = "remote"
+)
+
+// serverConfig - Contents of the Server's JSON config file
+type serverConfig struct {
+	Listen        string                     `json:"listen"`
+	TLSCert       string                     `json:"tlsCert"`
+	TLSKey        string                     `json:"tlsKey"`
+	SessionCookie string                     `json:"sessionCookie"`
+	Policies      map[string]*securityPolicy `json:"-"`
+}
+
+// securityPolicy - Browser security settings applied to one class of client
+type securityPolicy struct {
+	AllowedOrigins        []string `json:"allowedOrigins"`
+	AllowedHeaders        []string `json:"allowedHeaders"`
+	AllowCredentials      bool     `json:"allowCredentials"`
+	CorsMaxAge            int      `json:"corsMaxAge"`
+	CSRF                  bool     `json:"csrf"`
+	ContentSecurityPolicy string   `json:"contentSecurityPolicy"`
+	FrameOptions          string   `json:"frameOptions"`
+	HSTSMaxAge            int      `json:"hstsMaxAge"`
+}
+
+func defaultPolicy() *securityPolicy {
+	return &securityPolicy{
+		AllowedHeaders:        []string{"Content-Type", "Target", "Action", csrfHeader, offsetHeader, "Content-Sha256"},
+		CorsMaxAge:            600,
+		CSRF:                  true,
+		ContentSecurityPolicy: "default-src 'self'; img-src 'self' data:; style-src 'self' 'unsafe-inline'; frame-ancestors 'none'",
+		FrameOptions:          "DENY",
+		HSTSMaxAge:            31536000,
+	}
+}
+
+func defaultConfig() *serverConfig {
+	return &serverConfig{
+		Listen:        defaultListenAddr,
+		SessionCookie: "session",
+		Policies: map[string]*securityPolicy{
+			kioskPolicy:  defaultPolicy(),
+			remotePolicy: defaultPolicy(),
+		},
+	}
+}
+
+// loadConfig - Reads the config file at 'path' over the defaults. A missing file is not an error
+func loadConfig(path string) (*serverConfig, error) {
+
+	cfg := defaultConfig()
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return cfg, err
+	}
+
+	// Fields missing from the file keep their defaults, including within each policy
+	err = json.Unmarshal(data, cfg)
+	if err != nil {
+		return cfg, err
+	}
+
+	var filePolicies struct {
+		Policies map[string]json.RawMessage `json:"policies"`
+	}
+	err = json.Unmarshal(data, &filePolicies)
+	if err != nil {
+		return cfg, err
+	}
+	for name, raw := range filePolicies.Policies {
+		policy := defaultPolicy()
+		err = json.Unmarshal(raw, policy)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Policies[name] = policy
+	}
+
+	return cfg, nil
+}
+
+// policyFor - Picks the kiosk policy for requests from the device itself, remote otherwise
+func (cfg *serverConfig) policyFor(r *http.Request) *securityPolicy {
+	if isKioskRequest(r) {
+		return cfg.Policies[kioskPolicy]
+	}
+	return cfg.Policies[remotePolicy]
+}
+
+func (cfg *serverConfig) tlsEnabled() bool {
+	return cfg.TLSCert != "" && cfg.TLSKey != ""
+}
+
+func isKioskRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
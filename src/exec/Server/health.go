@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"tech/app/comms"
+	"tech/app/logger"
+)
+
+const (
+	hostHealthTimeout = 1000
+)
+
+// readiness - Body of the /readyz response, the kiosk shows its out of service screen
+// whenever Ready is false
+type readiness struct {
+	Ready      bool              `json:"ready"`
+	Connected  bool              `json:"connected"`
+	Handshake  bool              `json:"handshake"`
+	Database   string            `json:"database"`
+	Components map[string]string `json:"components"`
+}
+
+// healthzHandler - Liveness, answers as long as the Server can serve requests
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok"))
+}
+
+// readyzHandler - Readiness, requires a handshaken Host with a readable database and
+// healthy components
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+
+	result := readiness{Database: "unknown", Components: make(map[string]string)}
+
+	if env.client != nil {
+		status := env.client.Status()
+		result.Connected = status.Connected
+		result.Handshake = status.Handshake
+	}
+
+	if result.Connected {
+		resp, err := env.client.Send(comms.BuildPacket(comms.HostTarget, comms.HealthAction, nil), hostHealthTimeout)
+		if err != nil {
+			logger.LogDebug("Failed to read host health, %v", err)
+		} else {
+			var report struct {
+				Healthy    bool              `json:"healthy"`
+				Database   string            `json:"database"`
+				Components map[string]string `json:"components"`
+			}
+			err = json.Unmarshal(resp.Data, &report)
+			if err != nil {
+				logger.Log("Malformed host health report, %v", err)
+			} else {
+				result.Ready = report.Healthy && result.Handshake
+				result.Database = report.Database
+				result.Components = report.Components
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !result.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"tech/app/comms"
+	"tech/app/logger"
+	"tech/app/metrics"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+const (
+	// Rendering the Host's metrics is cheap, but allow for a busy Host
+	hostMetricsTimeout = 1000
+)
+
+var (
+	httpDuration   = metrics.NewHistogram("http_request_duration_seconds", "Latency of HTTP requests per route.", metrics.DefaultBuckets, "route", "method", "status")
+	httpRequests   = metrics.NewCounter("http_requests_total", "HTTP requests per route and status.", "route", "method", "status")
+	hostScrapeUp   = metrics.NewGauge("host_scrape_up", "Whether the last scrape of the Host's metrics succeeded.")
+	hostScrapeTime = metrics.NewGauge("host_scrape_duration_seconds", "Time taken by the last scrape of the Host's metrics.")
+)
+
+// metricsMiddleware - Records latency and status per route pattern, so path parameters do not
+// create a new series for every request
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		statusText := strconv.Itoa(status)
+
+		httpDuration.Observe(time.Since(start).Seconds(), route, r.Method, statusText)
+		httpRequests.Inc(route, r.Method, statusText)
+	})
+}
+
+// metricsHandler - Serves the Server's metrics followed by those reported by the Host
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+
+	var hostMetrics []byte
+	start := time.Now()
+	if env.client != nil {
+		resp, err := env.client.Send(comms.BuildPacket("metrics", "Get", nil), hostMetricsTimeout)
+		if err != nil {
+			logger.LogDebug("Failed to collect host metrics, %v", err)
+		} else {
+			hostMetrics = resp.Data
+		}
+	}
+	hostScrapeUp.SetBool(hostMetrics != nil)
+	hostScrapeTime.Set(time.Since(start).Seconds())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Default.WriteText(w)
+	w.Write(hostMetrics)
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
-	"tech/app/comms"
+	"strings"
 	"tech/app/logger"
 
 	"github.com/go-chi/chi"
@@ -15,31 +17,51 @@ import (
 const (
 	// For testing purposes only. Will be changed to firmware update directory later
 	maxUploadSize     = (500 * 1048576) // 500 MB
-	webPagesServePath = "./"
 	uploadPath        = "/home/root/"
 	uploadFileName    = "updatefile"
+	bundleExtension   = ".tar.gz"
+	bundleContentType = "application/x-gzip"
+
+	// Carries the Host's error message on failed commands, which still answer 200
+	commandErrorHeader = "X-Command-Error"
+
+	commandTimeout = 100
+
+	// Password hashing makes logins and password changes far slower than other commands
+	authTimeout = 3000
+
+	// Pours run to completion before the Host answers
+	pourTimeout = 120000
+
+	// Card reads wait up to 30 seconds for a card to be shown
+	nfcTimeout = 35000
+
+	// Verifying and staging a bundle on the Host takes far longer than a regular command
+	installTimeout = 120000
 )
 
-func configureRoutes(router *chi.Mux, logHTTP bool) {
+func configureRoutes(router *chi.Mux, logHTTP bool, webRoot string, cfg *serverConfig) {
 
 	if logHTTP {
 		router.Use(middleware.Logger)
 	}
+	router.Use(metricsMiddleware)
+	router.Use(securityMiddleware(cfg))
+	router.Use(setupMiddleware)
+
+	router.Get("/metrics", metricsHandler)
+	router.Get("/healthz", healthzHandler)
+	router.Get("/readyz", readyzHandler)
+	router.Get("/csrf", csrfTokenHandler)
 	router.Route("/command", func(r chi.Router) {
 		r.Post("/", handleCommand)
 	})
-	router.Route("/upload", func(r chi.Router) {
-		r.Post("/", uploadFileHandler)
-	})
-	router.Get("/*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.FileServer(http.Dir(webPagesServePath)).ServeHTTP(w, r)
-	}))
-}
-
-func defaultHandler(w http.ResponseWriter, r *http.Request) {
-
-	http.ServeFile(w, r, "index.html")
-	logger.Log("Served unknown route")
+	router.Route("/upload", configureUploadRoutes)
+	router.Route("/admin", configureAdminRoutes)
+	router.Route("/setup", configureSetupRoutes)
+	static := newStaticHandler(webRoot)
+	router.Method(http.MethodGet, "/*", static)
+	router.Method(http.MethodHead, "/*", static)
 }
 
 func handleCommand(w http.ResponseWriter, r *http.Request) {
@@ -60,8 +82,21 @@ func handleCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := env.client.Send(comms.BuildPacket(target, action, data), 100)
+	timeout := commandTimeout
+	if target == "userAuth" {
+		timeout = authTimeout
+	} else if action == "InitMixing" || action == "OrderRecipe" || action == "StartCalibration" {
+		timeout = pourTimeout
+	} else if action == "ReadNfc" {
+		timeout = nfcTimeout
+	}
+
+	resp, err := env.client.Send(requestPacket(r, target, action, data), timeout)
 	if err == nil {
+		trackSession(w, r, target, action, resp)
+		if resp.Header.Error != "" {
+			w.Header().Set(commandErrorHeader, resp.Header.Error)
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write(resp.Data)
 	} else {
@@ -70,6 +105,8 @@ func handleCommand(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// uploadFileHandler - Streams a single multipart upload to disk while hashing it, the
+// whole file is never held in memory
 func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 
 	logger.LogDebug("File received, please wait...")
@@ -80,58 +117,102 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
-	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+	reader, err := r.MultipartReader()
+	if err != nil {
 		logger.Log("File upload failed, %v", err)
 		http.Error(w, http.StatusText(400), 400)
 		return
 	}
 
-	file, _, err := r.FormFile("fileKey")
+	var part *multipart.Part
+	for {
+		part, err = reader.NextPart()
+		if err != nil {
+			logger.Log("File upload failed, %v", err)
+			http.Error(w, http.StatusText(400), 400)
+			return
+		}
+		if part.FormName() == "fileKey" {
+			break
+		}
+		part.Close()
+	}
+	defer part.Close()
+
+	err = os.MkdirAll(filepath.Join(uploadPath, partialDir), 0700)
 	if err != nil {
-		logger.Log("File upload failed, %v", err)
-		http.Error(w, http.StatusText(400), 400)
+		logger.Log("Cannot create upload directory, %v", err)
+		http.Error(w, http.StatusText(500), 500)
 		return
 	}
-	defer file.Close()
 
-	fileBytes, err := ioutil.ReadAll(file)
+	tmpFile, err := ioutil.TempFile(filepath.Join(uploadPath, partialDir), uploadFileName)
+	if err != nil {
+		logger.Log("Cannot write file, %v", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	size, hash, fileType, err := streamToFile(tmpPath, part)
 	if err != nil {
 		logger.Log("File upload failed, %v", err)
 		http.Error(w, http.StatusText(400), 400)
 		return
 	}
 
-	logger.LogDebug("Decoding File Type")
-	fileType := http.DetectContentType(fileBytes)
-	var fileEndings string
-	switch fileType {
-	case "application/octet-stream":
-		fileEndings = ".jpeg"
-	default:
-		logger.Log("Invalid file type, %v", err)
+	logger.LogDebug("Received %d bytes, sha256 %s", size, hash)
+	if expected := r.Header.Get("Content-Sha256"); expected != "" && !strings.EqualFold(expected, hash) {
+		logger.Log("File upload checksum mismatch")
+		http.Error(w, "Checksum mismatch", 422)
+		return
+	}
+
+	if fileType != bundleContentType {
+		logger.Log("Invalid file type, %s", fileType)
 		http.Error(w, http.StatusText(400), 400)
 		return
 	}
 
 	logger.LogDebug("File Type Valid")
-	fileName := uploadFileName
-	filePath := filepath.Join(uploadPath, fileName+fileEndings)
+	installLock.Lock()
+	defer installLock.Unlock()
 
-	newFile, err := os.Create(filePath)
+	filePath := filepath.Join(uploadPath, uploadFileName+bundleExtension)
+	err = os.Rename(tmpPath, filePath)
 	if err != nil {
 		logger.Log("Cannot write file, %v", err)
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
-	defer newFile.Close()
+	logger.LogDebug("File uploaded as %s", filePath)
 
-	if _, err := newFile.Write(fileBytes); err != nil {
-		logger.Log("Cannot write file, %v", err)
+	installUpdate(w, r, filePath)
+}
+
+// installUpdate - Asks the Host to verify and install the update bundle at 'filePath'
+func installUpdate(w http.ResponseWriter, r *http.Request, filePath string) {
+
+	data, err := json.Marshal(map[string]string{"path": filePath})
+	if err != nil {
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
-	w.Write([]byte("SUCCESS"))
-	logger.LogDebug("File uploaded as %s", filePath)
 
-	return
+	resp, err := env.client.Send(requestPacket(r, "update", "Install", data), installTimeout)
+	if err != nil {
+		logger.Log("Failed to send install request, %v", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	if resp.Data == nil {
+		logger.Log("Update bundle '%s' was rejected by the host", filePath)
+		http.Error(w, "Update rejected", 422)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp.Data)
 }
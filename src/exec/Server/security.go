@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"tech/app/logger"
+)
+
+const (
+	csrfCookie = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+	csrfLength = 32
+)
+
+type contextKey string
+
+// csrfTokenKey - Request context key holding the caller's CSRF token, including one issued
+// on this request
+const csrfTokenKey = contextKey("csrfToken")
+
+// securityMiddleware - Applies the security headers, CORS and CSRF rules of the policy matching
+// the request
+func securityMiddleware(cfg *serverConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			policy := cfg.policyFor(r)
+			setSecurityHeaders(w, r, policy)
+
+			if !applyCors(w, r, policy) {
+				return
+			}
+
+			token := csrfToken(w, r, policy)
+			if policy.CSRF && !checkCsrf(r, cfg, token) {
+				logger.Log("Rejected %s %s from %s, missing or invalid CSRF token", r.Method, r.URL.Path, r.RemoteAddr)
+				http.Error(w, http.StatusText(403), 403)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfTokenKey, token)))
+		})
+	}
+}
+
+func setSecurityHeaders(w http.ResponseWriter, r *http.Request, policy *securityPolicy) {
+	header := w.Header()
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Referrer-Policy", "same-origin")
+	if policy.FrameOptions != "" {
+		header.Set("X-Frame-Options", policy.FrameOptions)
+	}
+	if policy.ContentSecurityPolicy != "" {
+		header.Set("Content-Security-Policy", policy.ContentSecurityPolicy)
+	}
+	if r.TLS != nil && policy.HSTSMaxAge > 0 {
+		header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(policy.HSTSMaxAge))
+	}
+}
+
+func originAllowed(origin string, policy *securityPolicy) bool {
+	for _, allowed := range policy.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyCors - Adds CORS headers for allowed origins and answers preflight requests. Returns
+// false if the request has been fully handled
+func applyCors(w http.ResponseWriter, r *http.Request, policy *securityPolicy) bool {
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Origin")
+
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if !originAllowed(origin, policy) {
+		if preflight {
+			http.Error(w, http.StatusText(403), 403)
+			return false
+		}
+		// Without the allow header the browser will not expose the response
+		return true
+	}
+
+	header.Set("Access-Control-Allow-Origin", origin)
+	if policy.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	header.Set("Access-Control-Expose-Headers", strings.Join([]string{offsetHeader, "ETag", commandErrorHeader}, ", "))
+
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE")
+		header.Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+		if policy.CorsMaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(policy.CorsMaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+
+	return true
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// checkCsrf - Double submit check. Every client is handed a token cookie, state changing
+// requests authenticated by the session cookie must echo it in the X-CSRF-Token header.
+// Requests without a session cookie carry no ambient authority and are let through
+func checkCsrf(r *http.Request, cfg *serverConfig, token string) bool {
+
+	if isSafeMethod(r.Method) {
+		return true
+	}
+	if _, err := r.Cookie(cfg.SessionCookie); err != nil {
+		return true
+	}
+
+	sent := r.Header.Get(csrfHeader)
+	return sent != "" && subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1
+}
+
+// csrfToken - Returns the caller's token, issuing a new cookie if it has none
+func csrfToken(w http.ResponseWriter, r *http.Request, policy *securityPolicy) string {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return issueCsrfToken(w, r, policy)
+}
+
+func issueCsrfToken(w http.ResponseWriter, r *http.Request, policy *securityPolicy) string {
+	tokenBytes := make([]byte, csrfLength)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		logger.Log("Failed to generate CSRF token, %v", err)
+		return ""
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	cookie := &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	// Credentialed cross origin clients only send cookies marked SameSite=None, which
+	// browsers accept over TLS alone
+	if policy.AllowCredentials && r.TLS != nil {
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, cookie)
+	return token
+}
+
+// csrfTokenHandler - Returns the caller's CSRF token, for clients on another origin which
+// cannot read the cookie themselves
+func csrfTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token, _ := r.Context().Value(csrfTokenKey).(string)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
@@ -6,17 +6,22 @@ import (
 	"runtime"
 	"tech/app/comms"
 	"tech/app/logger"
+	"tech/app/metrics"
 
 	"github.com/go-chi/chi"
 )
 
 const (
 	socketName = "@/tmp/socketTest.sock"
+
+	// defaultWebRoot - Web UI bundle, kept apart from the binaries and scripts in the slot
+	defaultWebRoot = "./www"
 )
 
 // Env is a container for objects that may be overwritten by tests
 type Env struct {
 	client comms.Client
+	config *serverConfig
 }
 
 var env *Env
@@ -26,17 +31,22 @@ func main() {
 	var httpLog bool
 	var logNormal bool
 	var logDebug bool
+	var webRoot string
+	var configPath string
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
 	flag.BoolVar(&httpLog, "h", false, "Log http requests")
 	flag.BoolVar(&logNormal, "l", false, "Logs additional application statements")
 	flag.BoolVar(&logDebug, "d", false, "Logs debug statements")
+	flag.StringVar(&webRoot, "w", defaultWebRoot, "Directory holding the web UI")
+	flag.StringVar(&configPath, "c", defaultConfigPath, "Server config file")
 	flag.Parse()
 
 	env = &Env{}
 
 	logger.Init("server")
+	metrics.Init("server")
 	logger.LogToStdout = false
 	if logNormal {
 		logger.LogToStdout = true
@@ -45,14 +55,26 @@ func main() {
 		logger.Debug = true
 	}
 
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		logger.Log("Failed to load config '%s', using defaults, %v", configPath, err)
+	}
+	env.config = cfg
+
 	env.client = createSocketClient()
 	defer env.client.Shutdown()
 
 	router := chi.NewRouter()
-	configureRoutes(router, httpLog)
+	configureRoutes(router, httpLog, webRoot, cfg)
 
-	logger.Log("Starting http server")
-	http.ListenAndServe(":8080", router)
+	if cfg.tlsEnabled() {
+		logger.Log("Starting https server on %s", cfg.Listen)
+		err = http.ListenAndServeTLS(cfg.Listen, cfg.TLSCert, cfg.TLSKey, router)
+	} else {
+		logger.Log("Starting http server on %s", cfg.Listen)
+		err = http.ListenAndServe(cfg.Listen, router)
+	}
+	logger.Log("Server exited, %v", err)
 }
 
 func createSocketClient() *comms.SocketClient {
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"tech/app/comms"
+	"time"
+)
+
+const (
+	sessionTokenField   = "sessionToken"
+	sessionExpiresField = "sessionExpires"
+	sessionMaxAge       = 12 * time.Hour
+)
+
+// requestPacket - Builds a packet for the Host carrying the caller's session and address so
+// the Host can authorise and audit the request
+func requestPacket(r *http.Request, target string, action string, data []byte) comms.Packet {
+	packet := comms.BuildPacket(target, action, data)
+	packet.Header.Session = sessionToken(r)
+	packet.Header.Source = r.RemoteAddr
+	return packet
+}
+
+// sessionToken - Session cookie set at login, or a bearer token for non browser clients
+func sessionToken(r *http.Request) string {
+	if cookie, err := r.Cookie(env.config.SessionCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return ""
+}
+
+// trackSession - Sets or clears the session cookie after a login or logout passed through
+// the command route
+func trackSession(w http.ResponseWriter, r *http.Request, target string, action string, resp comms.Packet) {
+
+	if target != "userAuth" || resp.Header.Error != "" {
+		return
+	}
+
+	switch action {
+	case "Login", "LoginByCard", "LoginByPin":
+		var user map[string]interface{}
+		if json.Unmarshal(resp.Data, &user) != nil {
+			return
+		}
+		token, ok := user[sessionTokenField].(string)
+		if !ok || token == "" {
+			return
+		}
+
+		// PIN sessions end sooner, the cookie should not outlive them
+		maxAge := sessionMaxAge
+		if value, ok := user[sessionExpiresField].(string); ok {
+			if expires, err := time.Parse(time.RFC3339, value); err == nil && time.Until(expires) < maxAge {
+				maxAge = time.Until(expires)
+			}
+		}
+		setSessionCookie(w, r, token, int(maxAge/time.Second))
+
+	case "Logout":
+		setSessionCookie(w, r, "", -1)
+	}
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, maxAge int) {
+	cookie := &http.Cookie{
+		Name:     env.config.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if env.config.policyFor(r).AllowCredentials && r.TLS != nil {
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, cookie)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"tech/app/comms"
+	"tech/app/logger"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	// How often the Host is asked again while the device is not provisioned
+	setupRecheckInterval = time.Second
+)
+
+// setupState - Whether the Host reports the device as provisioned. Once it has, the answer
+// is kept, a factory reset restarts both processes
+type setupState struct {
+	lock    sync.Mutex
+	done    bool
+	checked time.Time
+}
+
+var setup setupState
+
+func configureSetupRoutes(r chi.Router) {
+	r.Get("/", setupStepHandler("GetStatus"))
+	r.Post("/password", setupStepHandler("SetPassword"))
+	r.Post("/name", setupStepHandler("SetDeviceName"))
+	r.Post("/network", setupStepHandler("ConfirmNetwork"))
+	r.Post("/timezone", setupStepHandler("SetTimezone"))
+	r.Post("/finish", setupStepHandler("Finish"))
+}
+
+// setupStepHandler - Forwards the JSON body to a setup action and returns the setup status
+func setupStepHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := make(map[string]interface{})
+		if r.Method == http.MethodPost && r.ContentLength != 0 {
+			err := json.NewDecoder(r.Body).Decode(&params)
+			if err != nil {
+				http.Error(w, http.StatusText(400), 400)
+				return
+			}
+		}
+
+		data, ok := hostRequest(w, r, "setup", action, params)
+		if !ok {
+			return
+		}
+		if action == "Finish" {
+			setup.refresh()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// provisioned - Asks the Host unless it has recently answered no
+func (state *setupState) provisioned() bool {
+
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	if state.done || time.Since(state.checked) < setupRecheckInterval {
+		return state.done
+	}
+	state.checked = time.Now()
+
+	if env.client == nil {
+		return false
+	}
+	resp, err := env.client.Send(comms.BuildPacket("setup", "GetStatus", nil), commandTimeout)
+	if err != nil || resp.Data == nil {
+		return false
+	}
+
+	var status struct {
+		Provisioned bool `json:"provisioned"`
+	}
+	if json.Unmarshal(resp.Data, &status) == nil && status.Provisioned {
+		logger.Log("Device is provisioned")
+		state.done = true
+	}
+
+	return state.done
+}
+
+// refresh - Forces the next check to ask the Host
+func (state *setupState) refresh() {
+	state.lock.Lock()
+	state.checked = time.Time{}
+	state.lock.Unlock()
+}
+
+// setupAllowed - Routes usable before the device is provisioned: the web UI, health and
+// metrics, the setup routes, and logging in through the command route
+func setupAllowed(r *http.Request) bool {
+
+	path := r.URL.Path
+	switch {
+	case path == "/setup" || strings.HasPrefix(path, "/setup/"):
+		return true
+	case path == "/healthz" || path == "/readyz" || path == "/metrics" || path == "/csrf":
+		return true
+	case path == "/command" || path == "/command/":
+		target := r.Header.Get("Target")
+		action := r.Header.Get("Action")
+		return target == "setup" || (target == "userAuth" && (action == "Login" || action == "Logout"))
+	case path == "/upload" || strings.HasPrefix(path, "/upload/"), path == "/admin" || strings.HasPrefix(path, "/admin/"):
+		return false
+	}
+
+	// Everything else is the static web UI, which hosts the setup wizard
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// setupMiddleware - Blocks every other route until setup has been finished
+func setupMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !setupAllowed(r) && !setup.provisioned() {
+			http.Error(w, "Device setup required", 403)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
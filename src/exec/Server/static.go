@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"tech/app/logger"
+	"time"
+)
+
+const (
+	indexFile = "index.html"
+
+	cacheHTML        = "no-cache"
+	cacheAsset       = "public, max-age=86400"
+	cacheFingerprint = "public, max-age=31536000, immutable"
+)
+
+// fingerprintPattern matches build tool output such as app.3f9a1c2b.js, which never changes content
+var fingerprintPattern = regexp.MustCompile(`\.[0-9a-f]{8,}\.[a-z0-9]+$`)
+
+// encodings - Precompressed variants in order of preference
+var encodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+type etagEntry struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// staticHandler - Serves the web UI from a dedicated directory. Dotfiles and anything outside
+// the directory are never served, unknown routes without an extension fall back to index.html
+type staticHandler struct {
+	root string
+
+	lock  sync.Mutex
+	etags map[string]etagEntry
+}
+
+func newStaticHandler(root string) *staticHandler {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		absRoot = root
+	}
+	return &staticHandler{root: absRoot, etags: make(map[string]etagEntry)}
+}
+
+func (sh *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+
+	urlPath := path.Clean("/" + r.URL.Path)
+	for _, segment := range strings.Split(urlPath, "/") {
+		if strings.HasPrefix(segment, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	name := strings.TrimPrefix(urlPath, "/")
+	if name == "" {
+		name = indexFile
+	}
+
+	filePath, info := sh.resolve(name)
+	if info != nil && info.IsDir() {
+		name = path.Join(name, indexFile)
+		filePath, info = sh.resolve(name)
+	}
+	if info == nil {
+		// Client side routes have no extension, missing assets are a real 404
+		if path.Ext(name) != "" {
+			http.NotFound(w, r)
+			return
+		}
+		name = indexFile
+		filePath, info = sh.resolve(name)
+		if info == nil {
+			logger.Log("Web UI is missing %s in %s", indexFile, sh.root)
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	sh.serveFile(w, r, name, filePath)
+}
+
+// resolve - Maps a slash separated name to a regular file or directory inside the root,
+// following symlinks only if they stay inside the root
+func (sh *staticHandler) resolve(name string) (string, os.FileInfo) {
+
+	// The root itself may be a link, e.g. into the active update slot
+	realRoot, err := filepath.EvalSymlinks(sh.root)
+	if err != nil {
+		return "", nil
+	}
+
+	realPath, err := filepath.EvalSymlinks(filepath.Join(realRoot, filepath.FromSlash(name)))
+	if err != nil {
+		return "", nil
+	}
+	if realPath != realRoot && !strings.HasPrefix(realPath, realRoot+string(filepath.Separator)) {
+		return "", nil
+	}
+
+	info, err := os.Stat(realPath)
+	if err != nil || (!info.Mode().IsRegular() && !info.IsDir()) {
+		return "", nil
+	}
+
+	return realPath, info
+}
+
+func (sh *staticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, filePath string) {
+
+	header := w.Header()
+	header.Add("Vary", "Accept-Encoding")
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	switch {
+	case path.Ext(name) == ".html":
+		header.Set("Cache-Control", cacheHTML)
+	case fingerprintPattern.MatchString(name):
+		header.Set("Cache-Control", cacheFingerprint)
+	default:
+		header.Set("Cache-Control", cacheAsset)
+	}
+
+	servePath := filePath
+	accepted := r.Header.Get("Accept-Encoding")
+	for _, enc := range encodings {
+		if !acceptsEncoding(accepted, enc.name) {
+			continue
+		}
+		if encPath, info := sh.resolve(name + enc.ext); info != nil && info.Mode().IsRegular() {
+			servePath = encPath
+			header.Set("Content-Encoding", enc.name)
+			break
+		}
+	}
+
+	file, err := os.Open(servePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	etag, err := sh.etag(servePath, info, file)
+	if err == nil {
+		header.Set("ETag", etag)
+	}
+
+	// ServeContent answers If-None-Match and Range requests using the ETag set above
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+// etag - Content hash of the file, cached until its size or modification time changes
+func (sh *staticHandler) etag(filePath string, info os.FileInfo, file io.ReadSeeker) (string, error) {
+
+	sh.lock.Lock()
+	entry, ok := sh.etags[filePath]
+	sh.lock.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.etag, nil
+	}
+
+	hash := sha256.New()
+	_, err := io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	etag := "\"" + hex.EncodeToString(hash.Sum(nil))[:32] + "\""
+	sh.lock.Lock()
+	sh.etags[filePath] = etagEntry{modTime: info.ModTime(), size: info.Size(), etag: etag}
+	sh.lock.Unlock()
+
+	return etag, nil
+}
+
+func acceptsEncoding(header string, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(fields[0]) != encoding {
+			continue
+		}
+		for _, param := range fields[1:] {
+			if strings.Replace(strings.TrimSpace(param), " ", "", -1) == "q=0" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
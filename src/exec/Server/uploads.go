@@ -0,0 +1,454 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"tech/app/logger"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	partialDir     = "partial"
+	maxChunkSize   = (16 * 1048576) // 16 MB
+	sessionExpiry  = 24 * time.Hour
+	offsetHeader   = "Upload-Offset"
+	uploadIDLength = 16
+
+	// maxUploadSessions - Partial uploads open at once, each may grow to maxUploadSize
+	maxUploadSessions = 2
+)
+
+var uploadIDPattern = regexp.MustCompile("^[0-9a-f]{32}$")
+
+// uploadSession - Metadata stored beside each partial upload, the offset is the size of the partial file
+type uploadSession struct {
+	ID      string    `json:"id"`
+	Size    int64     `json:"size"`
+	Offset  int64     `json:"offset"`
+	Created time.Time `json:"created"`
+}
+
+// uploadLock - Serialises writers to one upload session, removed once nobody holds or
+// waits for it
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+// uploadLocks - The lock of each upload session in use
+var uploadLocks = struct {
+	sync.Mutex
+	ids map[string]*uploadLock
+}{ids: make(map[string]*uploadLock)}
+
+// installLock - Completed uploads are moved to one bundle path and installed one at a time
+var installLock sync.Mutex
+
+func configureUploadRoutes(r chi.Router) {
+	r.Use(adminOnly)
+	r.Post("/", uploadFileHandler)
+	r.Post("/sessions", createUploadHandler)
+	r.Get("/sessions/{id}", uploadStatusHandler)
+	r.Head("/sessions/{id}", uploadStatusHandler)
+	r.Patch("/sessions/{id}", uploadChunkHandler)
+	r.Post("/sessions/{id}/complete", completeUploadHandler)
+	r.Delete("/sessions/{id}", abortUploadHandler)
+}
+
+// streamToFile - Copies 'src' into a new file at 'path', returning the number of bytes
+// written, the hex sha256 of the content and its sniffed content type
+func streamToFile(path string, src io.Reader) (int64, string, string, error) {
+
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer file.Close()
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(src, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, "", "", err
+	}
+	sniff = sniff[:n]
+
+	hash := sha256.New()
+	out := io.MultiWriter(file, hash)
+	_, err = out.Write(sniff)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	written, err := io.Copy(out, src)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	return written + int64(n), hex.EncodeToString(hash.Sum(nil)), http.DetectContentType(sniff), nil
+}
+
+// hashFile - Returns the hex sha256 and content type of the file at 'path'
+func hashFile(path string) (string, string, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(file, sniff)
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", "", err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), http.DetectContentType(sniff[:n]), nil
+}
+
+func partialPath(id string, ext string) string {
+	return filepath.Join(uploadPath, partialDir, id+ext)
+}
+
+// adminOnly - Asks the Host whether the caller is an admin before an upload route runs
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := hostRequest(w, r, "update", "GetUploadPermission", struct{}{})
+		if ok {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// lockUpload - Locks the session 'id', which must be a valid upload id
+func lockUpload(id string) *uploadLock {
+	uploadLocks.Lock()
+	lock, ok := uploadLocks.ids[id]
+	if !ok {
+		lock = &uploadLock{}
+		uploadLocks.ids[id] = lock
+	}
+	lock.refs++
+	uploadLocks.Unlock()
+
+	lock.Lock()
+	return lock
+}
+
+func releaseUpload(id string, lock *uploadLock) {
+	lock.Unlock()
+
+	uploadLocks.Lock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(uploadLocks.ids, id)
+	}
+	uploadLocks.Unlock()
+}
+
+// loadUploadSession - Reads the session metadata and the current offset from disk, so
+// uploads survive a Server restart
+func loadUploadSession(id string) (*uploadSession, error) {
+
+	if !uploadIDPattern.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+
+	data, err := ioutil.ReadFile(partialPath(id, ".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var session uploadSession
+	err = json.Unmarshal(data, &session)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(partialPath(id, ".part"))
+	if err != nil {
+		return nil, err
+	}
+	session.Offset = info.Size()
+
+	return &session, nil
+}
+
+func removeUploadSession(id string) {
+	os.Remove(partialPath(id, ".part"))
+	os.Remove(partialPath(id, ".json"))
+}
+
+// expireUploadSessions - Removes partial uploads that have been abandoned
+func expireUploadSessions() {
+
+	entries, err := ioutil.ReadDir(filepath.Join(uploadPath, partialDir))
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		session, err := loadUploadSession(id)
+		if err != nil || time.Since(session.Created) > sessionExpiry {
+			logger.LogDebug("Expiring upload session %s", id)
+			removeUploadSession(id)
+		}
+	}
+}
+
+func countUploadSessions() int {
+	entries, err := ioutil.ReadDir(filepath.Join(uploadPath, partialDir))
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") {
+			count++
+		}
+	}
+	return count
+}
+
+func writeUploadSession(w http.ResponseWriter, session *uploadSession) {
+	w.Header().Set(offsetHeader, strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
+
+func createUploadHandler(w http.ResponseWriter, r *http.Request) {
+
+	var request struct {
+		Size int64 `json:"size"`
+	}
+	err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&request)
+	if err != nil || request.Size <= 0 || request.Size > maxUploadSize {
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
+	expireUploadSessions()
+	if countUploadSessions() >= maxUploadSessions {
+		http.Error(w, "Too many uploads in progress", http.StatusTooManyRequests)
+		return
+	}
+
+	err = os.MkdirAll(filepath.Join(uploadPath, partialDir), 0700)
+	if err != nil {
+		logger.Log("Cannot create upload directory, %v", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	idBytes := make([]byte, uploadIDLength)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	session := &uploadSession{ID: hex.EncodeToString(idBytes), Size: request.Size, Created: time.Now().UTC()}
+	meta, _ := json.Marshal(session)
+	err = ioutil.WriteFile(partialPath(session.ID, ".json"), meta, 0600)
+	if err == nil {
+		err = ioutil.WriteFile(partialPath(session.ID, ".part"), nil, 0600)
+	}
+	if err != nil {
+		logger.Log("Cannot create upload session, %v", err)
+		removeUploadSession(session.ID)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	logger.LogDebug("Created upload session %s for %d bytes", session.ID, session.Size)
+	w.WriteHeader(http.StatusCreated)
+	writeUploadSession(w, session)
+}
+
+func uploadStatusHandler(w http.ResponseWriter, r *http.Request) {
+
+	session, err := loadUploadSession(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	writeUploadSession(w, session)
+}
+
+// uploadChunkHandler - Appends the body at the offset given in the Upload-Offset header. The
+// offset must equal the bytes already received, clients query it to resume after a failure
+func uploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+
+	id := chi.URLParam(r, "id")
+	if !uploadIDPattern.MatchString(id) {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+	lock := lockUpload(id)
+	defer releaseUpload(id, lock)
+
+	session, err := loadUploadSession(id)
+	if err != nil {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(offsetHeader), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+	if offset != session.Offset {
+		w.Header().Set(offsetHeader, strconv.FormatInt(session.Offset, 10))
+		http.Error(w, http.StatusText(409), 409)
+		return
+	}
+
+	remaining := session.Size - session.Offset
+	limit := int64(maxChunkSize)
+	if remaining < limit {
+		limit = remaining
+	}
+
+	file, err := os.OpenFile(partialPath(id, ".part"), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		logger.Log("Cannot open upload session %s, %v", id, err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	defer file.Close()
+
+	// Keep whatever arrived before a dropped connection, the client resumes from there
+	written, copyErr := io.Copy(file, io.LimitReader(r.Body, limit))
+	file.Sync()
+	session.Offset += written
+
+	if copyErr != nil {
+		logger.Log("Upload session %s interrupted at %d bytes, %v", id, session.Offset, copyErr)
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
+	writeUploadSession(w, session)
+}
+
+func completeUploadHandler(w http.ResponseWriter, r *http.Request) {
+
+	if env.client == nil {
+		logger.Log("Command client not available")
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if !uploadIDPattern.MatchString(id) {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+	lock := lockUpload(id)
+	defer releaseUpload(id, lock)
+
+	var request struct {
+		Sha256 string `json:"sha256"`
+	}
+	err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&request)
+	if err != nil || request.Sha256 == "" {
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
+	session, err := loadUploadSession(id)
+	if err != nil {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+	if session.Offset != session.Size {
+		msg := fmt.Sprintf("Upload incomplete, received %d of %d bytes", session.Offset, session.Size)
+		w.Header().Set(offsetHeader, strconv.FormatInt(session.Offset, 10))
+		http.Error(w, msg, 409)
+		return
+	}
+
+	hash, fileType, err := hashFile(partialPath(id, ".part"))
+	if err != nil {
+		logger.Log("Cannot read upload session %s, %v", id, err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	if !strings.EqualFold(hash, request.Sha256) {
+		logger.Log("Upload session %s checksum mismatch", id)
+		removeUploadSession(id)
+		http.Error(w, "Checksum mismatch", 422)
+		return
+	}
+	if fileType != bundleContentType {
+		logger.Log("Invalid file type, %s", fileType)
+		removeUploadSession(id)
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
+	installLock.Lock()
+	defer installLock.Unlock()
+
+	filePath := filepath.Join(uploadPath, uploadFileName+bundleExtension)
+	err = os.Rename(partialPath(id, ".part"), filePath)
+	if err != nil {
+		logger.Log("Cannot move upload session %s, %v", id, err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	removeUploadSession(id)
+	logger.LogDebug("Upload session %s completed as %s", id, filePath)
+
+	installUpdate(w, r, filePath)
+}
+
+func abortUploadHandler(w http.ResponseWriter, r *http.Request) {
+
+	id := chi.URLParam(r, "id")
+	if !uploadIDPattern.MatchString(id) {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+	lock := lockUpload(id)
+	defer releaseUpload(id, lock)
+
+	if _, err := loadUploadSession(id); err != nil {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	removeUploadSession(id)
+	w.WriteHeader(http.StatusNoContent)
+}
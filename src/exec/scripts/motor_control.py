@@ -1,10 +1,12 @@
-# Simple demo of of the PCA9685 PWM servo/LED controller library.
-# This will move channel 0 from min to max position repeatedly.
-# Author: Tony DiCola
-# License: Public Domain
+# Drives the pump servos on the PCA9685 PWM board.
+# Usage: motor_control.py <channel 0-5> <cycles>
+#        motor_control.py mix 0
+#        motor_control.py off 0
+# On SIGTERM every output is turned off before exiting, the Host sends it for an emergency stop.
 from __future__ import division
-import time
+import signal
 import sys
+import time
 
 # Import the PCA9685 module.
 import Adafruit_PCA9685
@@ -16,82 +18,50 @@ pwm = Adafruit_PCA9685.PCA9685()
 servo_min = 150  # Min pulse length out of 4096
 servo_max = 600  # Max pulse length out of 4096
 
+# Output driving the mixing motor
+mix_channel = 6
+
 # Set frequency to 60hz, good for servos.
 pwm.set_pwm_freq(60)
 
-print('Moving servo on channel 0, press Ctrl-C to quit...')
-# while True:
- if sys.argv[1] == "0":
-    loopCount = sys.argv[2]
-    while loopCount is not 0:
-        # Move servo on channel 0 between extremes.
-        pwm.set_pwm(0, 0, servo_min)
-        time.sleep(1)
-        pwm.set_pwm(0, 0, servo_max)
-        time.sleep(2)
-        print("Servo 0 Completed! ")  
-        loopCount -= 1
 
-elif sys.argv[1] == "1":
-    loopCount = sys.argv[2]
-    while loopCount is not 0:
-        # Move servo on channel 1 between extremes.
-        pwm.set_pwm(1, 0, servo_min)
-        time.sleep(1)
-        pwm.set_pwm(0, 0, servo_max)
-        time.sleep(2)
-        print("Servo 1 Completed! ")
-        loopCount -= 1
+def all_off(signum=None, frame=None):
+    # 4096 sets the full-off bit on every output
+    pwm.set_all_pwm(0, 4096)
+    if signum is not None:
+        print("Stopped, all outputs off")
+        sys.exit(1)
 
-elif sys.argv[1] == "2":
-    loopCount = sys.argv[2]
-    while loopCount is not 0:    
-        # Move servo on channel 2 between extremes.
-        pwm.set_pwm(2, 0, servo_min)
-        time.sleep(1)
-        pwm.set_pwm(0, 0, servo_max)
-        time.sleep(1)
-        print("Servo 2 Completed! ")
-        loopCount -= 1
 
-elif sys.argv[1] == "3":
-    loopCount = sys.argv[2]
-    while loopCount is not 0:
-        # Move servo on channel 3 between extremes.
-        pwm.set_pwm(3, 0, servo_min)
-        time.sleep(1)
-        pwm.set_pwm(0, 0, servo_max)
-        time.sleep(2)
-        print("Servo 3 Completed! ")
-        loopCount -= 1
+def cycle(channel, press_time, release_time):
+    pwm.set_pwm(channel, 0, servo_min)
+    time.sleep(press_time)
+    pwm.set_pwm(channel, 0, servo_max)
+    time.sleep(release_time)
 
-elif sys.argv[1] == "4":
-    loopCount = sys.argv[2]
-    while loopCount is not 0:
-        # Move servo on channel 4 between extremes.
-        pwm.set_pwm(4, 0, servo_min)
-        time.sleep(1)
-        pwm.set_pwm(0, 0, servo_max)
-        time.sleep(2)
-        print("Servo 4 Completed! ")
-        loopCount -= 1
-    
-elif sys.argv[1] == "5":
-    loopCount = sys.argv[2]
-    while loopCount is not 0:
-        # Move servo on channel 5 between extremes.
-        pwm.set_pwm(5, 0, servo_min)
-        time.sleep(1)
-        pwm.set_pwm(0, 0, servo_max)
-        time.sleep(2)
-        loopCount -= 1
-        print("Servo 5 Completed! ")
 
-efif sys.argv[1] == "mix":
+signal.signal(signal.SIGTERM, all_off)
+
+if len(sys.argv) != 3:
+    print("usage: motor_control.py <channel|mix|off> <cycles>")
+    sys.exit(2)
+
+if sys.argv[1] == "off":
+    # The Host runs this after killing a script that did not stop, or when none was running
+    print("All outputs off")
+elif sys.argv[1] == "mix":
     # Turn mixing motor to mix drink
-    pwm.set_pwm(6, 0, servo_min)
-    time.sleep(1)
-    pwm.set_pwm(0, 0, servo_max)
-    time.sleep(2)
+    cycle(mix_channel, 1, 2)
     print("Mixing Completed! ")
-    
\ No newline at end of file
+else:
+    channel = int(sys.argv[1])
+    cycles = int(sys.argv[2])
+    if channel < 0 or channel > 5 or cycles < 0:
+        print("channel must be 0 to 5 and cycles at least 0")
+        sys.exit(2)
+    # Channel 2 has always released after one second rather than two
+    for _ in range(cycles):
+        cycle(channel, 1, 1 if channel == 2 else 2)
+    print("Servo %d Completed! " % channel)
+
+all_off()
@@ -1,10 +1,12 @@
 import RPi.GPIO as GPIO
 import MFRC522
 import sys
+import time
 
 from .smbus2.smbus2 import SMBus, i2c_msg
 
-nfcMode = sys.argv[1]
+# "false" reads the card UID for identification, "true" reads payment data
+nfcMode = 1 if sys.argv[1] == "true" else 0
 MIFAREReader = MFRC522.MFRC522()
 readData= -1
 bus = SMBus(1)
@@ -20,6 +22,10 @@ try:
             if status == MIFAREReader.MI_OK:
                 # Get the UID of the card
                 (status,uid) = MIFAREReader.MFRC522_Anticoll()
+                if status != MIFAREReader.MI_OK:
+                    continue
+                # The Host reads the UID from the last line printed
+                print("".join("%02X" % b for b in uid[:-1]))
                 # This is the default key for authentication
                 key = [0xFF,0xFF,0xFF,0xFF,0xFF,0xFF]
                 # Select the scanned tag
@@ -33,7 +39,7 @@ try:
 
     #Read RFID for payment
     elif nfcMode == 1:
-        while readData == -1
+        while readData == -1:
             # Scan for cards
             time.sleep(0.5)
 
@@ -46,7 +52,8 @@ try:
 
             readData = readBuffer
 
-    return readData
+        # The Host reads the last line printed
+        print("".join("%02X" % b for b in readData))
 
 except KeyboardInterrupt:
     print("Abbruch")
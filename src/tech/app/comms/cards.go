@@ -0,0 +1,313 @@
+package comms
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"tech/app/components"
+	"tech/app/logger"
+	"tech/mixer/config"
+	"time"
+)
+
+const (
+	cardTableName   = "cards"
+	cardKeyName     = "cardKey"
+	cardKeyLength   = 32
+	maxCardLabelLen = 64
+)
+
+// cardUIDPattern - 4, 7 or 10 byte ISO 14443 UIDs as hex, separators already removed
+var cardUIDPattern = regexp.MustCompile(`^([0-9A-F]{8}|[0-9A-F]{14}|[0-9A-F]{20})$`)
+
+// CardInfo - An enrolled badge. The UID itself is never stored or returned
+type CardInfo struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Label    string `json:"label"`
+	Created  string `json:"created"`
+}
+
+// normaliseCardUID - Accepts "04:a2:3b:1c", "04 A2 3B 1C" or "04a23b1c"
+func normaliseCardUID(uid string) (string, error) {
+	replacer := strings.NewReplacer(":", "", " ", "", "-", "")
+	normalised := strings.ToUpper(replacer.Replace(strings.TrimSpace(uid)))
+	if !cardUIDPattern.MatchString(normalised) {
+		return "", fmt.Errorf("invalid card UID")
+	}
+	return normalised, nil
+}
+
+// cardAction - Runs the badge enrolment actions. Users manage their own cards, admins
+// manage anyone's
+func (usr *UserAuth) cardAction(caller components.Caller, action string, data map[string]interface{}) ([]byte, error) {
+
+	if caller.Username == "" {
+		return nil, components.PermissionError{Action: action, Reason: "requires a session"}
+	}
+
+	username, _ := data["username"].(string)
+	if username == "" {
+		username = caller.Username
+	} else if username != caller.Username {
+		err := caller.RequireAdmin(action)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch action {
+	case "EnrollCard":
+		uid, _ := data["uid"].(string)
+		label, _ := data["label"].(string)
+		return usr.enrollCard(caller, username, uid, label)
+
+	case "RemoveCard":
+		return usr.removeCard(caller, username, data)
+
+	case "ListCards":
+		return usr.listCards(username)
+	}
+
+	return nil, fmt.Errorf("unknown card action '%s'", action)
+}
+
+func (usr *UserAuth) createCardTables(cfg *config.CfgService) (err error) {
+	cardSchema := []string{
+		"uidHash TEXT NOT NULL",
+		"username TEXT NOT NULL",
+		"label TEXT",
+		"created TEXT"}
+
+	err = cfg.CreateTable(cardTableName, cardSchema)
+	if err != nil {
+		return
+	}
+	_, err = cfg.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + cardTableName + "_uidHash ON " + cardTableName + " (uidHash)")
+	if err != nil {
+		return
+	}
+
+	// The key is generated once per device, so a copied table cannot be matched against
+	// UIDs read elsewhere without the key as well
+	err = cfg.CreateTable(cardKeyName, []string{"key TEXT"})
+	if err != nil {
+		return
+	}
+	if stored, _ := cfg.GetValue(cardKeyName, "key"); stored != nil {
+		return nil
+	}
+
+	key := make([]byte, cardKeyLength)
+	_, err = rand.Read(key)
+	if err != nil {
+		return
+	}
+	return cfg.InitTable(cardKeyName, map[string]string{"key": "'" + hex.EncodeToString(key) + "'"})
+}
+
+// hashCardUID - Keyed hash stored in place of the UID
+func (usr *UserAuth) hashCardUID(uid string) (string, error) {
+
+	value, err := usr.ConfigService.GetValue(cardKeyName, "key")
+	if err != nil {
+		return "", err
+	}
+	stored, _ := config.JSONstring(value)
+	key, err := hex.DecodeString(stored)
+	if err != nil || len(key) != cardKeyLength {
+		return "", fmt.Errorf("card key is missing")
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(uid))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// cardOwner - Returns the user a card is enrolled to, or "" if it is not enrolled
+func (usr *UserAuth) cardOwner(uidHash string) (string, error) {
+	rows, err := usr.ConfigService.Query("SELECT username FROM "+cardTableName+" WHERE uidHash = ?", uidHash)
+	if err != nil || len(rows) == 0 {
+		return "", err
+	}
+	username, _ := config.JSONstring(rows[0]["username"])
+	return username, nil
+}
+
+// enrollCard - Binds a badge to 'username'. A badge belongs to one user only
+func (usr *UserAuth) enrollCard(caller components.Caller, username string, uid string, label string) ([]byte, error) {
+
+	uid, err := normaliseCardUID(uid)
+	if err != nil {
+		return nil, err
+	}
+	label = strings.TrimSpace(label)
+	if len(label) > maxCardLabelLen {
+		return nil, fmt.Errorf("label must be at most %d characters", maxCardLabelLen)
+	}
+	if _, err = usr.findUser(username); err != nil {
+		return nil, err
+	}
+
+	uidHash, err := usr.hashCardUID(uid)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := usr.cardOwner(uidHash)
+	if err != nil {
+		return nil, err
+	}
+	if owner != "" {
+		return nil, fmt.Errorf("card is already enrolled")
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339)
+	id, err := usr.ConfigService.Exec("INSERT INTO "+cardTableName+" (uidHash, username, label, created) VALUES (?, ?, ?, ?)",
+		uidHash, username, label, created)
+	if err != nil {
+		// The unique index catches an enrolment racing this one
+		return nil, fmt.Errorf("card is already enrolled")
+	}
+	logger.Log("'%s' enrolled a card for '%s'", caller.Username, username)
+
+	return json.MarshalIndent(CardInfo{ID: id, Username: username, Label: label, Created: created}, "", "\t")
+}
+
+// removeCard - Removes one of the user's badges, given by 'id' or by 'uid'
+func (usr *UserAuth) removeCard(caller components.Caller, username string, data map[string]interface{}) ([]byte, error) {
+
+	var count int64
+	var err error
+	if uid, ok := data["uid"].(string); ok && uid != "" {
+		uid, err = normaliseCardUID(uid)
+		if err != nil {
+			return nil, err
+		}
+		var uidHash string
+		uidHash, err = usr.hashCardUID(uid)
+		if err != nil {
+			return nil, err
+		}
+		count, err = usr.deleteCards("uidHash = ? AND username = ?", uidHash, username)
+	} else if id, ok := data["id"].(float64); ok {
+		count, err = usr.deleteCards("configID = ? AND username = ?", int64(id), username)
+	} else {
+		return nil, fmt.Errorf("'id' or 'uid' is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("no such card for '%s'", username)
+	}
+	logger.Log("'%s' removed a card of '%s'", caller.Username, username)
+
+	return nil, nil
+}
+
+// deleteCards - Deletes the cards matching 'where' and returns how many there were
+func (usr *UserAuth) deleteCards(where string, args ...interface{}) (int64, error) {
+	rows, err := usr.ConfigService.Query("SELECT count(*) AS cards FROM "+cardTableName+" WHERE "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	count, _ := config.JSONint64(rows[0]["cards"])
+	if count == 0 {
+		return 0, nil
+	}
+	_, err = usr.ConfigService.Exec("DELETE FROM "+cardTableName+" WHERE "+where, args...)
+	return count, err
+}
+
+func (usr *UserAuth) listCards(username string) ([]byte, error) {
+
+	rows, err := usr.ConfigService.Query("SELECT configID, username, label, created FROM "+cardTableName+
+		" WHERE username = ? ORDER BY configID", username)
+	if err != nil {
+		return nil, err
+	}
+
+	cards := make([]CardInfo, 0, len(rows))
+	for _, row := range rows {
+		var card CardInfo
+		card.ID, _ = config.JSONint64(row["configID"])
+		card.Username, _ = config.JSONstring(row["username"])
+		card.Label, _ = config.JSONstring(row["label"])
+		card.Created, _ = config.JSONstring(row["created"])
+		cards = append(cards, card)
+	}
+
+	return json.MarshalIndent(cards, "", "\t")
+}
+
+// loginByCard - Logs in the owner of a badge tapped on the kiosk's reader. A UID is easily
+// copied, so only the kiosk may send one and the session is limited like a PIN session.
+// Unknown badges count as failures of the kiosk's source address, the owner's lockout and
+// two-factor settings apply as for a password login
+func (usr *UserAuth) loginByCard(caller components.Caller, uid string, code string) ([]byte, error) {
+
+	if !isKioskSource(caller.Source) {
+		return nil, components.PermissionError{Action: "LoginByCard", Reason: "is only available at the kiosk"}
+	}
+
+	sourceKeys := []string{sourceKey(caller.Source)}
+	err := usr.guard.check(sourceKeys...)
+	if err != nil {
+		loginBlocked.Inc()
+		logger.Log("Card login from '%s' refused, %v", caller.Source, err)
+		return nil, err
+	}
+
+	uid, err = normaliseCardUID(uid)
+	if err != nil {
+		return nil, err
+	}
+	uidHash, err := usr.hashCardUID(uid)
+	if err != nil {
+		return nil, err
+	}
+	username, err := usr.cardOwner(uidHash)
+	if err != nil {
+		return nil, err
+	}
+	if username == "" {
+		usr.loginFailed(sourceKeys)
+		return nil, fmt.Errorf("card not enrolled")
+	}
+
+	keys := []string{userKey(username), sourceKey(caller.Source)}
+	err = usr.guard.check(keys...)
+	if err != nil {
+		loginBlocked.Inc()
+		logger.Log("Card login for '%s' from '%s' refused, %v", username, caller.Source, err)
+		return nil, err
+	}
+
+	user, err := usr.ConfigService.GetUser(usr.Name, username)
+	if err != nil {
+		return nil, err
+	}
+	if user["username"] == nil {
+		// The user was deleted without their cards
+		return nil, fmt.Errorf("card not enrolled")
+	}
+	if disabled, _ := config.JSONbool(user["disabled"]); disabled {
+		return nil, fmt.Errorf("account disabled")
+	}
+
+	err = usr.checkSecondFactor(username, user, code)
+	if err == errCodeRequired {
+		return json.MarshalIndent(map[string]interface{}{"username": "", "twoFactorRequired": true}, "", "\t")
+	} else if err != nil {
+		usr.loginFailed(keys)
+		return nil, err
+	}
+
+	logger.Log("'%s' logged in by card", username)
+	return usr.startSession(caller, keys, user, ScopePin)
+}
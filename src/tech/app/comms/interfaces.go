@@ -4,9 +4,17 @@ import "io"
 
 type Client interface {
 	Send(Packet, int) (Packet, error)
+	Status() ClientStatus
 	Shutdown()
 }
 
+// ClientStatus - Describes the state of a Client's link to the host
+type ClientStatus struct {
+	Connected bool
+	Handshake bool
+	HostInfo  []byte
+}
+
 type Conn interface {
 	Close() error
 	io.Writer
@@ -0,0 +1,238 @@
+package comms
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Failures allowed before a username or source address is locked out. Many kiosk users
+	// share the loopback address, so sources get more room
+	maxUserFailures   = 5
+	maxSourceFailures = 20
+
+	// PINs have few combinations, so they lock sooner
+	maxPinFailures = 3
+
+	// Delay enforced after the first failure, doubled for every further failure
+	baseLoginDelay  = time.Second
+	maxLoginDelay   = 30 * time.Second
+	lockoutDuration = 15 * time.Minute
+
+	// Failures older than this are forgotten
+	failureWindow = time.Hour
+
+	userKeyPrefix   = "user:"
+	sourceKeyPrefix = "source:"
+	pinKeyPrefix    = "pin:"
+)
+
+// Lockout - A username or source address currently refused
+type Lockout struct {
+	Key      string    `json:"key"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+// loginBlockedError - Returned for attempts made before the caller's delay or lockout ends
+type loginBlockedError struct {
+	locked bool
+	wait   time.Duration
+}
+
+func (e loginBlockedError) Error() string {
+	wait := e.wait.Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	if e.locked {
+		return fmt.Sprintf("account locked, try again in %s", wait)
+	}
+	return fmt.Sprintf("too many failed attempts, try again in %s", wait)
+}
+
+type attemptRecord struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// loginGuard - Tracks failed logins per username and per source address. Attempts are
+// refused rather than delayed so a guessing client cannot stall the Host's request loop
+type loginGuard struct {
+	lock    sync.Mutex
+	records map[string]*attemptRecord
+	now     func() time.Time
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{records: make(map[string]*attemptRecord), now: time.Now}
+}
+
+func userKey(username string) string {
+	return userKeyPrefix + username
+}
+
+// pinKey - PIN failures are kept apart from password failures, so guessing a PIN cannot lock
+// the user out of their password login
+func pinKey(username string) string {
+	return pinKeyPrefix + username
+}
+
+// sourceKey - Keys on the address alone, the port changes with every connection. Callers
+// with no address, such as local IPC clients, are not tracked
+func sourceKey(source string) string {
+	if source == "" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(source)
+	if err != nil {
+		host = source
+	}
+	return sourceKeyPrefix + host
+}
+
+func maxFailures(key string) int {
+	switch {
+	case strings.HasPrefix(key, sourceKeyPrefix):
+		return maxSourceFailures
+	case strings.HasPrefix(key, pinKeyPrefix):
+		return maxPinFailures
+	}
+	return maxUserFailures
+}
+
+// freeFailures - Failures before a key is delayed. A source is not slowed until it has made
+// as many mistakes as one user may, so one user's typos do not hold up the whole kiosk
+func freeFailures(key string) int {
+	if strings.HasPrefix(key, sourceKeyPrefix) {
+		return maxUserFailures
+	}
+	return 0
+}
+
+// delay - Time a key with 'failures' failures must wait between attempts
+func delay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	// Compared as a float, a large count would overflow the Duration
+	wait := float64(baseLoginDelay) * math.Pow(2, float64(failures-1))
+	if wait > float64(maxLoginDelay) {
+		return maxLoginDelay
+	}
+	return time.Duration(wait)
+}
+
+// record - Returns the live record for 'key', dropping it if it has aged out. Lock must be held
+func (guard *loginGuard) record(key string, now time.Time) *attemptRecord {
+	rec, ok := guard.records[key]
+	if !ok {
+		return nil
+	}
+	if now.After(rec.lockedUntil) && now.Sub(rec.last) > failureWindow {
+		delete(guard.records, key)
+		return nil
+	}
+	return rec
+}
+
+// check - Returns an error if any of 'keys' is locked out or still waiting out its delay
+func (guard *loginGuard) check(keys ...string) error {
+
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+
+	now := guard.now()
+	for _, key := range keys {
+		rec := guard.record(key, now)
+		if rec == nil {
+			continue
+		}
+		if now.Before(rec.lockedUntil) {
+			return loginBlockedError{locked: true, wait: rec.lockedUntil.Sub(now)}
+		}
+		if next := rec.last.Add(delay(rec.failures - freeFailures(key))); now.Before(next) {
+			return loginBlockedError{wait: next.Sub(now)}
+		}
+	}
+
+	return nil
+}
+
+// fail - Counts a failure against 'keys', returning the lockouts it caused
+func (guard *loginGuard) fail(keys ...string) []Lockout {
+
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+
+	now := guard.now()
+	var locked []Lockout
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		rec := guard.record(key, now)
+		if rec == nil {
+			rec = &attemptRecord{}
+			guard.records[key] = rec
+		}
+		// A lockout that has run its course starts a fresh count
+		if !rec.lockedUntil.IsZero() && now.After(rec.lockedUntil) {
+			rec.failures = 0
+			rec.lockedUntil = time.Time{}
+		}
+
+		rec.failures++
+		rec.last = now
+		if rec.failures >= maxFailures(key) && rec.lockedUntil.IsZero() {
+			rec.lockedUntil = now.Add(lockoutDuration)
+			locked = append(locked, Lockout{Key: key, Failures: rec.failures, Until: rec.lockedUntil})
+		}
+	}
+
+	return locked
+}
+
+// succeed - Clears the failures of 'keys' after a good login
+func (guard *loginGuard) succeed(keys ...string) {
+	guard.lock.Lock()
+	for _, key := range keys {
+		delete(guard.records, key)
+	}
+	guard.lock.Unlock()
+}
+
+// unlock - Clears 'key', returns false if it had no failures
+func (guard *loginGuard) unlock(key string) bool {
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+
+	_, ok := guard.records[key]
+	delete(guard.records, key)
+	return ok
+}
+
+// lockouts - Returns every key currently locked out, soonest to expire first
+func (guard *loginGuard) lockouts() []Lockout {
+
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+
+	now := guard.now()
+	locked := make([]Lockout, 0)
+	for key := range guard.records {
+		rec := guard.record(key, now)
+		if rec != nil && now.Before(rec.lockedUntil) {
+			locked = append(locked, Lockout{Key: key, Failures: rec.failures, Until: rec.lockedUntil})
+		}
+	}
+	sort.Slice(locked, func(i, j int) bool { return locked[i].Until.Before(locked[j].Until) })
+
+	return locked
+}
@@ -0,0 +1,210 @@
+package comms
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// guardStep - Moves the guard's clock on by 'advance' then runs 'op', "fail", "succeed" or
+// "check". Checks expect 'want', "ok", "wait" or "locked"
+type guardStep struct {
+	advance time.Duration
+	op      string
+	want    string
+}
+
+// fails - 'count' failures made as fast as the guard allows
+func fails(count int, key string) []guardStep {
+	var steps []guardStep
+	for i := 0; i < count; i++ {
+		steps = append(steps, guardStep{advance: delay(i - freeFailures(key)), op: "fail"})
+	}
+	return steps
+}
+
+func steps(groups ...[]guardStep) []guardStep {
+	var all []guardStep
+	for _, group := range groups {
+		all = append(all, group...)
+	}
+	return all
+}
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: -1, want: 0},
+		{failures: 0, want: 0},
+		{failures: 1, want: time.Second},
+		{failures: 2, want: 2 * time.Second},
+		{failures: 3, want: 4 * time.Second},
+		{failures: 5, want: 16 * time.Second},
+		{failures: 6, want: maxLoginDelay},
+		{failures: 40, want: maxLoginDelay},
+	}
+	for _, test := range tests {
+		if got := delay(test.failures); got != test.want {
+			t.Errorf("delay(%d) = %v, want %v", test.failures, got, test.want)
+		}
+	}
+}
+
+func TestLoginGuard(t *testing.T) {
+
+	user := userKey("alice")
+	source := sourceKey("192.0.2.10:51234")
+
+	tests := []struct {
+		name  string
+		key   string
+		steps []guardStep
+	}{
+		{
+			name: "backoff grows",
+			key:  user,
+			steps: []guardStep{
+				{op: "check", want: "ok"},
+				{op: "fail"},
+				{op: "check", want: "wait"},
+				{advance: 999 * time.Millisecond, op: "check", want: "wait"},
+				{advance: time.Millisecond, op: "check", want: "ok"},
+				{op: "fail"},
+				{advance: time.Second, op: "check", want: "wait"},
+				{advance: time.Second, op: "check", want: "ok"},
+				{op: "fail"},
+				{advance: 3 * time.Second, op: "check", want: "wait"},
+				{advance: time.Second, op: "check", want: "ok"},
+			},
+		},
+		{
+			name: "lockout expires",
+			key:  user,
+			steps: steps(fails(maxUserFailures, user), []guardStep{
+				{op: "check", want: "locked"},
+				{advance: maxLoginDelay, op: "check", want: "locked"},
+				{advance: lockoutDuration - maxLoginDelay - time.Second, op: "check", want: "locked"},
+				{advance: 2 * time.Second, op: "check", want: "ok"},
+				// The next failure starts a fresh count rather than locking again
+				{op: "fail"},
+				{op: "check", want: "wait"},
+				{advance: time.Second, op: "check", want: "ok"},
+			}),
+		},
+		{
+			name: "success resets",
+			key:  user,
+			steps: steps(fails(maxUserFailures-1, user), []guardStep{
+				{op: "check", want: "wait"},
+				{op: "succeed"},
+				{op: "check", want: "ok"},
+				// Had the count survived this would lock the user out
+				{op: "fail"},
+				{op: "check", want: "wait"},
+			}),
+		},
+		{
+			name: "failures age out",
+			key:  user,
+			steps: steps(fails(maxUserFailures-1, user), []guardStep{
+				{advance: failureWindow + time.Second, op: "check", want: "ok"},
+				{op: "fail"},
+				{op: "check", want: "wait"},
+				{advance: time.Second, op: "check", want: "ok"},
+			}),
+		},
+		{
+			name: "sources get free failures",
+			key:  source,
+			steps: steps(fails(maxUserFailures, source), []guardStep{
+				{op: "check", want: "ok"},
+				{op: "fail"},
+				{op: "check", want: "wait"},
+			}),
+		},
+		{
+			name: "pins lock sooner",
+			key:  pinKey("alice"),
+			steps: steps(fails(maxPinFailures, pinKey("alice")), []guardStep{
+				{op: "check", want: "locked"},
+			}),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			guard := newLoginGuard()
+			guard.now = func() time.Time { return clock }
+
+			for i, step := range test.steps {
+				clock = clock.Add(step.advance)
+				switch step.op {
+				case "fail":
+					guard.fail(test.key)
+				case "succeed":
+					guard.succeed(test.key)
+				case "check":
+					if got := checkResult(guard.check(test.key)); got != step.want {
+						t.Fatalf("step %d: check is %q, want %q", i, got, step.want)
+					}
+				default:
+					t.Fatalf("step %d: unknown op %q", i, step.op)
+				}
+			}
+		})
+	}
+}
+
+func checkResult(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	blocked, ok := err.(loginBlockedError)
+	if !ok {
+		return fmt.Sprintf("unexpected error %v", err)
+	}
+	if blocked.locked {
+		return "locked"
+	}
+	return "wait"
+}
+
+func TestLoginGuardLockouts(t *testing.T) {
+
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	guard := newLoginGuard()
+	guard.now = func() time.Time { return clock }
+
+	user := userKey("alice")
+	source := sourceKey("192.0.2.10:51234")
+	var locked []Lockout
+	for i := 0; i < maxUserFailures; i++ {
+		locked = append(locked, guard.fail(user, source)...)
+	}
+	if len(locked) != 1 || locked[0].Key != user || locked[0].Failures != maxUserFailures {
+		t.Fatalf("fail reported the lockouts %v, want only %q", locked, user)
+	}
+	if !locked[0].Until.Equal(clock.Add(lockoutDuration)) {
+		t.Errorf("lockout ends at %v, want %v", locked[0].Until, clock.Add(lockoutDuration))
+	}
+
+	if current := guard.lockouts(); len(current) != 1 || current[0].Key != user {
+		t.Errorf("lockouts = %v, want only %q", current, user)
+	}
+	if !guard.unlock(user) {
+		t.Error("unlock found no record for a locked user")
+	}
+	if current := guard.lockouts(); len(current) != 0 {
+		t.Errorf("lockouts after unlock = %v, want none", current)
+	}
+	if guard.unlock(user) {
+		t.Error("unlock found a record after it was cleared")
+	}
+
+	// Sources without an address are never tracked
+	if locked := guard.fail(sourceKey("")); len(locked) != 0 || len(guard.records) != 1 {
+		t.Errorf("failing the empty source key tracked it, records %v", guard.records)
+	}
+}
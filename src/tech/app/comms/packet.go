@@ -12,8 +12,27 @@ type Header struct {
 	Target string
 	Action string
 	Ack    bool
+
+	// Session is the caller's session token and Source where the request originated,
+	// e.g. the HTTP remote address. Peer is filled in by the Host from the IPC connection
+	Session string
+	Source  string
+	Peer    string
+
+	// Error is set on responses whose action failed, Denied if the caller lacked permission
+	Error  string
+	Denied bool
 }
 
+const (
+	// HostTarget - Target handled by the Host process itself rather than a mixer component
+	HostTarget = "host"
+	// HandshakeAction - Sent by a client after connecting, the Host replies with its build info
+	HandshakeAction = "Handshake"
+	// HealthAction - Requests the Host's health report
+	HealthAction = "Health"
+)
+
 // GpioData - Describes a
 type GpioData struct {
 	Addr uint32
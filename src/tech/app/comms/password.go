@@ -0,0 +1,69 @@
+package comms
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// Cost 10 takes roughly 100ms on the device's ARM core
+	passwordCost = bcrypt.DefaultCost
+
+	ccLast4Field = "ccLast4"
+)
+
+// dummyHash - Compared against for unknown usernames, a valid hash of a discarded random password
+const dummyHash = "$2a$10$KxyZdDraqIUyeODd3dj.jOFIRHAm0wcgdOsNUWrXU0Ei2C/gQv9tq"
+
+// privateUserFields - Columns of the userAuth table never returned to a client
+var privateUserFields = []string{"password", "ccNumber", "cvv", "paymentToken", "totpSecret", "totpLastStep", "recoveryCodes", "pinHash"}
+
+// hashPassword - Returns the bcrypt hash stored in place of 'password'
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", fmt.Errorf("password cannot be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// isHashed - Rows written before hashing was introduced hold the plaintext password
+func isHashed(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// checkPassword - Compares 'password' with the stored hash, or with a legacy plaintext value.
+// 'upgrade' is set when a matching row still needs to be hashed
+func checkPassword(stored string, password string) (match bool, upgrade bool) {
+	if isHashed(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+	match = stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	return match, match
+}
+
+// publicUser - Copy of a userAuth row safe to send to a client. Passwords and payment tokens
+// are removed, only the brand and last four digits of the card are kept
+func publicUser(user map[string]interface{}) map[string]interface{} {
+	public := make(map[string]interface{}, len(user))
+	for field, value := range user {
+		public[field] = value
+	}
+
+	if public[ccLast4Field] == nil {
+		public[ccLast4Field] = ""
+	}
+	token, _ := user["paymentToken"].(string)
+	public["hasPaymentMethod"] = token != ""
+	for _, field := range privateUserFields {
+		delete(public, field)
+	}
+
+	return public
+}
@@ -0,0 +1,182 @@
+package comms
+
+import (
+	"encoding/json"
+	"fmt"
+	"tech/app/components"
+	"tech/app/logger"
+	"tech/app/payments"
+	"tech/mixer/config"
+)
+
+// paymentAction - Runs the saved card actions. Users manage their own card, admins can see
+// and remove anyone's
+func (usr *UserAuth) paymentAction(caller components.Caller, action string, data map[string]interface{}) ([]byte, error) {
+
+	if caller.Username == "" {
+		return nil, components.PermissionError{Action: action, Reason: "requires a session"}
+	}
+
+	username, _ := data["username"].(string)
+	if username == "" {
+		username = caller.Username
+	} else if username != caller.Username {
+		if action == "SetPaymentInfo" {
+			return nil, components.PermissionError{Action: action, Reason: "is only allowed for your own account"}
+		}
+		err := caller.RequireAdmin(action)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch action {
+	case "GetPaymentInfo":
+		return usr.GetPaymentInfo(username)
+
+	case "SetPaymentInfo":
+		return usr.SetPaymentInfo(username, data)
+
+	case "ClearPaymentInfo":
+		return usr.clearPaymentInfo(caller, username)
+	}
+
+	return nil, fmt.Errorf("unknown payment action '%s'", action)
+}
+
+// SetPaymentProvider - Selects the provider cards are tokenized with. Card numbers older
+// releases stored in the clear are tokenized with it, so the Host sets it before serving
+func (usr *UserAuth) SetPaymentProvider(provider payments.PaymentProvider) error {
+	usr.payments = provider
+	return usr.migrateCards(usr.ConfigService)
+}
+
+// GetPaymentInfo - Returns the card holder, brand, expiry and last four digits of the card
+func (usr *UserAuth) GetPaymentInfo(username string) ([]byte, error) {
+	response, err := usr.ConfigService.GetUser(usr.Name, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(publicUser(response), "", "\t")
+}
+
+// SetPaymentInfo - Hands the card to the payment provider and keeps only the token it
+// returns, with the brand, expiry and last four digits. The CVV is never stored
+func (usr *UserAuth) SetPaymentInfo(username string, data map[string]interface{}) ([]byte, error) {
+
+	card := payments.Card{
+		Number: jsonText(data["ccNumber"]),
+		CVV:    jsonText(data["cvv"]),
+		Name:   jsonText(data["cardName"])}
+	month, _ := config.JSONint64(data["ccExpiryMonth"])
+	year, _ := config.JSONint64(data["ccExpiryYear"])
+	card.ExpiryMonth = int(month)
+	card.ExpiryYear = int(year)
+	if card.CVV == "" {
+		return nil, fmt.Errorf("CVV is required")
+	}
+	if usr.payments == nil {
+		return nil, fmt.Errorf("no payment provider configured")
+	}
+
+	token, err := usr.payments.Tokenize(card)
+	if err != nil {
+		return nil, err
+	}
+
+	err = usr.storeCardToken(username, token, card.Name)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log("'%s' saved a %s card ending %s with %s", username, token.Brand, token.Last4, usr.payments.Name())
+
+	return usr.GetPaymentInfo(username)
+}
+
+func (usr *UserAuth) storeCardToken(username string, token payments.CardToken, name string) error {
+	for column, value := range map[string]interface{}{
+		"paymentToken":  token.Token,
+		ccLast4Field:    token.Last4,
+		"cardBrand":     token.Brand,
+		"ccExpiryMonth": int64(token.ExpiryMonth),
+		"ccExpiryYear":  int64(token.ExpiryYear),
+		"cardName":      name} {
+
+		err := usr.ConfigService.SetUserValue(usr.Name, username, column, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (usr *UserAuth) clearPaymentInfo(caller components.Caller, username string) ([]byte, error) {
+
+	err := usr.storeCardToken(username, payments.CardToken{}, "")
+	if err != nil {
+		return nil, err
+	}
+	logger.Log("Saved card of '%s' removed by '%s'", username, caller.Username)
+
+	return nil, nil
+}
+
+// migrateCards - Releases before tokenization kept card numbers and CVVs in the clear.
+// Each stored number is tokenized with the configured provider where it accepts it, then
+// the raw values are wiped. Cards that cannot be tokenized, e.g. expired ones, have to be
+// entered again
+func (usr *UserAuth) migrateCards(cfg *config.CfgService) error {
+
+	hasNumbers, err := cfg.HasColumn(usr.Name, "ccNumber")
+	if err != nil || !hasNumbers {
+		return err
+	}
+
+	rows, err := cfg.Query("SELECT username, ccNumber, ccExpiryMonth, ccExpiryYear, cardName FROM " + usr.Name +
+		" WHERE ccNumber IS NOT NULL AND ccNumber != '' AND ccNumber != 0")
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		username, _ := config.JSONstring(row["username"])
+		card := payments.Card{Number: jsonText(row["ccNumber"]), Name: jsonText(row["cardName"])}
+		month, _ := config.JSONint64(row["ccExpiryMonth"])
+		year, _ := config.JSONint64(row["ccExpiryYear"])
+		card.ExpiryMonth = int(month)
+		card.ExpiryYear = int(year)
+
+		token, err := usr.payments.Tokenize(card)
+		if err != nil {
+			logger.Log("Removed stored card of '%s', it could not be tokenized, %v", username, err)
+			token = payments.CardToken{}
+			card.Name = ""
+		}
+		err = usr.storeCardToken(username, token, card.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = cfg.Exec("UPDATE " + usr.Name + " SET ccNumber = NULL, cvv = NULL")
+	if err == nil && len(rows) > 0 {
+		logger.Log("Removed %d stored card numbers and CVVs", len(rows))
+	}
+	return err
+}
+
+// jsonText - Card fields arrive as strings or, from older clients, as numbers
+func jsonText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	case int64:
+		return fmt.Sprintf("%d", v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,32 @@
+package comms
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerCredentials - Describes the process on the other end of a unix socket
+func peerCredentials(conn net.Conn) string {
+
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn.RemoteAddr().String()
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return "unix:unknown"
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return "unix:unknown"
+	}
+
+	return fmt.Sprintf("unix:pid=%d,uid=%d", cred.Pid, cred.Uid)
+}
@@ -0,0 +1,143 @@
+package comms
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"tech/app/components"
+	"tech/app/logger"
+	"tech/mixer/config"
+)
+
+var pinPattern = regexp.MustCompile(`^[0-9]{4,8}$`)
+
+func validatePin(pin string) error {
+	if !pinPattern.MatchString(pin) {
+		return fmt.Errorf("PIN must be 4 to 8 digits")
+	}
+	return nil
+}
+
+// isKioskSource - The kiosk's browser runs on the device itself, so its requests reach the
+// Server from a loopback address. Local IPC clients carry no address and are not kiosks
+func isKioskSource(source string) bool {
+	host, _, err := net.SplitHostPort(source)
+	if err != nil {
+		host = source
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// pinAction - Runs the PIN management actions. Setting a PIN needs the current password,
+// admins can clear anyone's PIN
+func (usr *UserAuth) pinAction(caller components.Caller, action string, data map[string]interface{}) ([]byte, error) {
+
+	if caller.Username == "" {
+		return nil, components.PermissionError{Action: action, Reason: "requires a session"}
+	}
+
+	switch action {
+	case "SetPin":
+		pin, _ := data["pin"].(string)
+		password, _ := data["currentPassword"].(string)
+		return usr.setPin(caller, pin, password)
+
+	case "ClearPin":
+		username, _ := data["username"].(string)
+		if username == "" {
+			username = caller.Username
+		} else if username != caller.Username {
+			err := caller.RequireAdmin(action)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = usr.findUser(username); err != nil {
+				return nil, err
+			}
+		}
+		return usr.clearPin(caller, username)
+	}
+
+	return nil, fmt.Errorf("unknown PIN action '%s'", action)
+}
+
+func (usr *UserAuth) setPin(caller components.Caller, pin string, password string) ([]byte, error) {
+
+	err := validatePin(pin)
+	if err != nil {
+		return nil, err
+	}
+	if !usr.HasPassword(caller.Username, password) {
+		return nil, fmt.Errorf("invalid password")
+	}
+
+	hash, err := hashPassword(pin)
+	if err != nil {
+		return nil, err
+	}
+	err = usr.ConfigService.SetUserValue(usr.Name, caller.Username, "pinHash", hash)
+	if err != nil {
+		return nil, err
+	}
+	usr.guard.unlock(pinKey(caller.Username))
+	logger.Log("'%s' set a kiosk PIN", caller.Username)
+
+	return json.MarshalIndent(map[string]bool{"pinSet": true}, "", "\t")
+}
+
+// clearPin - Removes the PIN and ends any PIN sessions of 'username'
+func (usr *UserAuth) clearPin(caller components.Caller, username string) ([]byte, error) {
+
+	err := usr.ConfigService.SetUserValue(usr.Name, username, "pinHash", "")
+	if err != nil {
+		return nil, err
+	}
+	usr.sessions.removeScope(username, ScopePin)
+	usr.guard.unlock(pinKey(username))
+	logger.Log("Kiosk PIN of '%s' cleared by '%s'", username, caller.Username)
+
+	return json.MarshalIndent(map[string]bool{"pinSet": false}, "", "\t")
+}
+
+// loginByPin - Logs a user in with their PIN at the kiosk. The session is short lived and
+// limited to the mixer, PINs are too short to guard anything else
+func (usr *UserAuth) loginByPin(caller components.Caller, username string, pin string) ([]byte, error) {
+
+	if !isKioskSource(caller.Source) {
+		return nil, components.PermissionError{Action: "LoginByPin", Reason: "is only available at the kiosk"}
+	}
+
+	// A password lockout stops PIN logins too, PIN failures only lock the PIN
+	keys := []string{pinKey(username), sourceKey(caller.Source)}
+	err := usr.guard.check(append(keys, userKey(username))...)
+	if err != nil {
+		loginBlocked.Inc()
+		logger.Log("PIN login for '%s' from '%s' refused, %v", username, caller.Source, err)
+		return nil, err
+	}
+
+	user, err := usr.ConfigService.GetUser(usr.Name, username)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, _ := config.JSONstring(user["pinHash"])
+	if user["username"] == nil || stored == "" {
+		// Compared anyway so users without a PIN cannot be told apart by response time
+		checkPassword(dummyHash, pin)
+		usr.loginFailed(keys)
+		return nil, fmt.Errorf("invalid PIN")
+	}
+	if match, _ := checkPassword(stored, pin); !match || !isHashed(stored) {
+		usr.loginFailed(keys)
+		return nil, fmt.Errorf("invalid PIN")
+	}
+	if disabled, _ := config.JSONbool(user["disabled"]); disabled {
+		return nil, fmt.Errorf("account disabled")
+	}
+
+	logger.Log("'%s' logged in by PIN", username)
+	return usr.startSession(caller, keys, user, ScopePin)
+}
@@ -0,0 +1,179 @@
+package comms
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"tech/app/components"
+	"time"
+)
+
+const (
+	sessionLifetime    = 12 * time.Hour
+	pinSessionLifetime = 15 * time.Minute
+	sessionTokenLength = 32
+)
+
+// Session scopes. A full session may do whatever the user's role allows
+const (
+	ScopeFull = ""
+
+	// ScopeEnrol - The user's role requires two-factor authentication which they have not set
+	// up yet, the session may only enrol
+	ScopeEnrol = "enrol"
+
+	// ScopePin - Logged in with a PIN or badge at the kiosk, the session may only use the mixer and
+	// its recipes
+	ScopePin = "pin"
+)
+
+// pinTargets - Components a PIN session may use
+var pinTargets = map[string]bool{
+	"mixerControl": true,
+	"recipes":      true,
+	"orders":       true}
+
+// Session - A logged in user, identified by a random token
+type Session struct {
+	Token    string
+	Username string
+	Role     string
+	Scope    string
+	IsAdmin  bool
+	Source   string
+	Created  time.Time
+	Expires  time.Time
+}
+
+// ScopeAllows - Reports whether a session with 'scope' may perform 'action' on 'target'
+func ScopeAllows(scope string, target string, action string) bool {
+	switch scope {
+	case ScopeFull:
+		return true
+	case ScopeEnrol:
+		return target == userAuthName && (action == "EnrollTOTP" || action == "ConfirmTOTP" || action == "Logout")
+	case ScopePin:
+		return pinTargets[target] || (target == userAuthName && action == "Logout")
+	}
+	return false
+}
+
+// sessionStore - In memory sessions, a Host restart logs everyone out
+type sessionStore struct {
+	lock     sync.Mutex
+	sessions map[string]*Session
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[string]*Session)}
+}
+
+// create - Starts a session for 'username' and returns it
+func (store *sessionStore) create(username string, role string, scope string, source string) (*Session, error) {
+
+	tokenBytes := make([]byte, sessionTokenLength)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime := sessionLifetime
+	if scope == ScopePin {
+		lifetime = pinSessionLifetime
+	}
+
+	now := time.Now()
+	session := &Session{
+		Token:    hex.EncodeToString(tokenBytes),
+		Username: username,
+		Role:     role,
+		Scope:    scope,
+		IsAdmin:  role == components.RoleAdmin && scope == ScopeFull,
+		Source:   source,
+		Created:  now,
+		Expires:  now.Add(lifetime)}
+
+	store.lock.Lock()
+	store.sessions[session.Token] = session
+	store.expire(now)
+	store.lock.Unlock()
+
+	return session, nil
+}
+
+// get - Returns a copy of the session for 'token' if it exists and has not expired
+func (store *sessionStore) get(token string) (Session, bool) {
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	session, ok := store.sessions[token]
+	if !ok {
+		return Session{}, false
+	}
+	if time.Now().After(session.Expires) {
+		delete(store.sessions, token)
+		return Session{}, false
+	}
+
+	return *session, true
+}
+
+// setScope - Changes the scope of the session for 'token'
+func (store *sessionStore) setScope(token string, scope string) {
+	store.lock.Lock()
+	if session, ok := store.sessions[token]; ok {
+		session.Scope = scope
+		session.IsAdmin = session.Role == components.RoleAdmin && scope == ScopeFull
+	}
+	store.lock.Unlock()
+}
+
+// remove - Ends the session for 'token'
+func (store *sessionStore) remove(token string) {
+	store.lock.Lock()
+	delete(store.sessions, token)
+	store.lock.Unlock()
+}
+
+// removeUser - Ends every session belonging to 'username'
+func (store *sessionStore) removeUser(username string) {
+	store.lock.Lock()
+	for token, session := range store.sessions {
+		if session.Username == username {
+			delete(store.sessions, token)
+		}
+	}
+	store.lock.Unlock()
+}
+
+// removeOthers - Ends every session belonging to 'username' except 'keep'
+func (store *sessionStore) removeOthers(username string, keep string) {
+	store.lock.Lock()
+	for token, session := range store.sessions {
+		if session.Username == username && token != keep {
+			delete(store.sessions, token)
+		}
+	}
+	store.lock.Unlock()
+}
+
+// removeScope - Ends the sessions of 'username' that have 'scope'
+func (store *sessionStore) removeScope(username string, scope string) {
+	store.lock.Lock()
+	for token, session := range store.sessions {
+		if session.Username == username && session.Scope == scope {
+			delete(store.sessions, token)
+		}
+	}
+	store.lock.Unlock()
+}
+
+// expire - Drops expired sessions, lock must be held
+func (store *sessionStore) expire(now time.Time) {
+	for token, session := range store.sessions {
+		if now.After(session.Expires) {
+			delete(store.sessions, token)
+		}
+	}
+}
@@ -6,22 +6,46 @@ import (
 	"fmt"
 	"sync"
 	"tech/app/logger"
+	"tech/app/metrics"
 	"time"
 )
 
+var (
+	ipcRoundTrip  = metrics.NewHistogram("ipc_round_trip_seconds", "Time from sending a packet to the host until its response.", metrics.DefaultBuckets, "target")
+	ipcTimeouts   = metrics.NewCounter("ipc_timeouts_total", "Packets that timed out waiting for a host response.", "target")
+	ipcErrors     = metrics.NewCounter("ipc_send_errors_total", "Packets that could not be sent to the host.", "target")
+	ipcReconnects = metrics.NewCounter("ipc_reconnects_total", "Connections re-established to the host after a disconnect.")
+	ipcConnected  = metrics.NewGauge("ipc_connected", "Whether the client is connected to the host.")
+)
+
+const (
+	handshakeTimeout = 1000
+)
+
 type request struct {
 	msgID        uint32
 	responseChan chan Packet
 }
 
+// SocketClient - Sends packets to the host and matches the responses to them. Send may be
+// called from many goroutines at once
 type SocketClient struct {
-	dialer       Dialer
-	Connected    bool
+	dialer Dialer
+	wg     sync.WaitGroup
+
+	// lock - Guards the connection state, the pending requests and the message counter
+	lock         sync.Mutex
+	connected    bool
+	handshake    bool
+	hostInfo     []byte
 	conn         Conn
 	enc          *json.Encoder
 	messageQueue *list.List
-	wg           sync.WaitGroup
 	msgCounter   uint32
+
+	// sendLock - Keeps packets from interleaving on the connection. It is separate from
+	// lock so the receive loop is never held up by a slow write
+	sendLock sync.Mutex
 }
 
 func NewClient(dialer Dialer) *SocketClient {
@@ -35,8 +59,11 @@ func NewClient(dialer Dialer) *SocketClient {
 
 // Shutdown the connection
 func (client *SocketClient) Shutdown() {
-	if client.conn != nil {
-		client.conn.Close()
+	client.lock.Lock()
+	conn := client.conn
+	client.lock.Unlock()
+	if conn != nil {
+		conn.Close()
 		logger.Log("Closed connection")
 	}
 }
@@ -64,16 +91,29 @@ func (client *SocketClient) doDial() {
 		return
 	}
 	connectDelay := int64(0)
+	dialled := false
 	for {
 		//ok := false
 		conn := client.dialer.Dial()
 		if conn != nil {
 			// Do socket client
+			client.lock.Lock()
 			client.conn = conn
 			client.enc = json.NewEncoder(conn)
-			client.Connected = true
+			client.connected = true
+			client.lock.Unlock()
+			ipcConnected.SetBool(true)
+			if dialled {
+				ipcReconnects.Inc()
+			}
+			dialled = true
+			go client.doHandshake()
 			client.doClientReceive()
-			client.Connected = false
+			client.lock.Lock()
+			client.connected = false
+			client.handshake = false
+			client.lock.Unlock()
+			ipcConnected.SetBool(false)
 			connectDelay = 0
 		} else {
 			//if !ok {
@@ -84,6 +124,28 @@ func (client *SocketClient) doDial() {
 	}
 }
 
+// doHandshake - Confirms the host is answering on a new connection
+func (client *SocketClient) doHandshake() {
+	resp, err := client.Send(BuildPacket(HostTarget, HandshakeAction, nil), handshakeTimeout)
+	if err != nil {
+		logger.Log("Host handshake failed, %v", err)
+		return
+	}
+	client.lock.Lock()
+	client.hostInfo = resp.Data
+	client.handshake = true
+	client.lock.Unlock()
+	logger.Log("Host handshake complete, %s", string(resp.Data))
+}
+
+// Status - Reports the connection and handshake state
+func (client *SocketClient) Status() ClientStatus {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return ClientStatus{Connected: client.connected, Handshake: client.handshake, HostInfo: client.hostInfo}
+}
+
+// incrementMsgCounter - Lock must be held
 func (client *SocketClient) incrementMsgCounter() {
 	client.msgCounter++
 	// 0 is an invalid value
@@ -98,18 +160,29 @@ func (client *SocketClient) Send(packet Packet, timeout int) (Packet, error) {
 	// if packet.Header.MsgId == 0 {
 	// 	return result, fmt.Errorf("Invalid send packet, msg id can't be 0")
 	// }
-	if !client.Connected {
+	// Buffered so a late response never blocks the receive loop after a timeout
+	responseChan := make(chan Packet, 1)
+
+	client.lock.Lock()
+	if !client.connected {
+		client.lock.Unlock()
+		ipcErrors.Inc(packet.Header.Target)
 		return result, fmt.Errorf("Client is not connected to host, unable to send")
 	}
+	start := time.Now()
 	packet.Header.MsgId = client.msgCounter
 	client.incrementMsgCounter()
-	responseChan := make(chan Packet)
-
 	client.messageQueue.PushBack(request{msgID: packet.Header.MsgId, responseChan: responseChan})
-	err := client.enc.Encode(packet)
+	enc := client.enc
+	client.lock.Unlock()
+
+	client.sendLock.Lock()
+	err := enc.Encode(packet)
+	client.sendLock.Unlock()
 	if err != nil {
 		logger.Log("Encode err %v - Exiting", err.Error())
 		client.findAndRemoveMessage(packet.Header.MsgId)
+		ipcErrors.Inc(packet.Header.Target)
 		return result, err
 	}
 	//client.logger.Printf("Added message id %d", packet.Header.MsgId)
@@ -119,9 +192,12 @@ func (client *SocketClient) Send(packet Packet, timeout int) (Packet, error) {
 		select {
 		case result = <-responseChan:
 		case <-time.After(time.Duration(timeout) * time.Millisecond):
+			ipcTimeouts.Inc(packet.Header.Target)
+			client.findAndRemoveMessage(packet.Header.MsgId)
 			return result, fmt.Errorf("Timed out waiting for response")
 		}
 	}
+	ipcRoundTrip.Observe(time.Since(start).Seconds(), packet.Header.Target)
 
 	if !result.Header.Ack {
 		logger.Log("Server replied but did not ack packet")
@@ -132,6 +208,9 @@ func (client *SocketClient) Send(packet Packet, timeout int) (Packet, error) {
 }
 
 func (client *SocketClient) findAndRemoveMessage(msgID uint32) (request, error) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
 	var result request
 	for e := client.messageQueue.Front(); e != nil; e = e.Next() {
 		//var nilObj request
@@ -157,7 +236,9 @@ func (client *SocketClient) findAndRemoveMessage(msgID uint32) (request, error)
 func (client *SocketClient) doClientReceive() {
 	client.wg.Add(1)
 	defer client.wg.Done()
+	client.lock.Lock()
 	dec := json.NewDecoder(client.conn)
+	client.lock.Unlock()
 	for {
 		var packet Packet
 		err := dec.Decode(&packet)
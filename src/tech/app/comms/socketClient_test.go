@@ -0,0 +1,108 @@
+package comms
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowTarget - Requests to this target are never answered, so they time out
+const slowTarget = "slow"
+
+// pipeDialer - Hands out one end of a pipe on the first Dial and blocks on any later one
+type pipeDialer struct {
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (dialer *pipeDialer) Dial() Conn {
+	var conn Conn
+	dialer.once.Do(func() { conn = dialer.conn })
+	if conn == nil {
+		<-dialer.done
+	}
+	return conn
+}
+
+// fakeHost - Echoes every request back with an ack, apart from those for slowTarget
+func fakeHost(conn net.Conn) {
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var packet Packet
+		if err := dec.Decode(&packet); err != nil {
+			return
+		}
+		if packet.Header.Target == slowTarget {
+			continue
+		}
+		if err := enc.Encode(BuildResponsePacket(packet.Header, packet.Data)); err != nil {
+			return
+		}
+	}
+}
+
+func TestClientConcurrentSend(t *testing.T) {
+
+	clientEnd, hostEnd := net.Pipe()
+	go fakeHost(hostEnd)
+	dialer := &pipeDialer{conn: clientEnd, done: make(chan struct{})}
+	defer close(dialer.done)
+	client := NewClient(dialer)
+	defer client.Shutdown()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !client.Status().Handshake {
+		if time.Now().After(deadline) {
+			t.Fatal("client did not complete the handshake")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	const senders = 20
+	const sends = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, senders*sends)
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(sender int) {
+			defer wg.Done()
+			for j := 0; j < sends; j++ {
+				target := "echo"
+				if (sender+j)%4 == 0 {
+					target = slowTarget
+				}
+				data := []byte(fmt.Sprintf("%q", fmt.Sprintf("%d-%d", sender, j)))
+				resp, err := client.Send(BuildPacket(target, "Test", data), 50)
+				if target == slowTarget {
+					if err == nil {
+						errs <- fmt.Errorf("send %d-%d to the slow target did not time out", sender, j)
+					}
+					continue
+				}
+				if err != nil {
+					errs <- fmt.Errorf("send %d-%d: %v", sender, j, err)
+				} else if string(resp.Data) != string(data) {
+					errs <- fmt.Errorf("send %d-%d got the response %s", sender, j, resp.Data)
+				}
+				client.Status()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	client.lock.Lock()
+	pending := client.messageQueue.Len()
+	client.lock.Unlock()
+	if pending != 0 {
+		t.Errorf("%d requests are still queued, want none", pending)
+	}
+}
@@ -42,20 +42,22 @@ func (host *SocketHost) Listen(listener net.Listener) {
 		logger.Log("Host Listener connection accepted")
 
 		exitDetect := make(chan bool)
-		logger.Log("Host Listener new connection")
+		peer := peerCredentials(socketConn)
+		logger.Log("Host Listener new connection from %s", peer)
 		host.Connected = true
 		go host.doHostResponse(socketConn, exitDetect)
-		host.doHostReceive(socketConn, exitDetect)
+		host.doHostReceive(socketConn, peer, exitDetect)
 	}
 }
 
-func (host *SocketHost) doHostReceive(conn Conn, exit chan bool) {
+func (host *SocketHost) doHostReceive(conn Conn, peer string, exit chan bool) {
 	logger.Log("Host receive starting")
 	dec := json.NewDecoder(conn)
 	for {
 		var packet Packet
 		err := dec.Decode(&packet)
 		if err == nil && packet.Header.MsgId != 0 {
+			packet.Header.Peer = peer
 			host.Out <- packet
 		} else if err == io.EOF {
 			logger.Log("Host receive connection closed, exiting")
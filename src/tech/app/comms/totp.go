@@ -0,0 +1,122 @@
+package comms
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// RFC 6238 defaults, the only parameters most authenticator apps accept
+	totpDigits = 6
+	totpPeriod = 30
+
+	// Codes one step either side of now are accepted to allow for clock drift
+	totpSkew = 1
+
+	totpSecretLength = 20
+	totpIssuer       = "Mixer"
+
+	recoveryCodeCount = 10
+
+	// 6 random bytes encode to 10 base32 characters
+	recoveryCodeBytes = 6
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret - Random shared secret, base32 encoded as authenticator apps expect
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretLength)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// hotp - RFC 4226 one time password for 'counter'
+func hotp(key []byte, counter uint64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// verifyTOTP - Checks 'code' against the steps around 'now'. Steps at or before 'lastStep'
+// were already used and are refused so a code cannot be replayed. Returns the matching step
+func verifyTOTP(secret string, code string, now time.Time, lastStep int64) (int64, bool) {
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(step))), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI - Provisioning URI understood by authenticator apps, also the QR code payload
+func totpURI(secret string, username string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", totpDigits))
+	params.Set("period", fmt.Sprintf("%d", totpPeriod))
+
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+username) + "?" + params.Encode()
+}
+
+// newRecoveryCodes - One time codes for when the authenticator is lost, e.g. "k3jd2-7xa4q".
+// Only their hashes are stored
+func newRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, recoveryCodeBytes)
+		_, err = rand.Read(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(totpEncoding.EncodeToString(raw))
+		code := encoded[:5] + "-" + encoded[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode - Recovery codes carry 48 random bits and are single use, a plain hash is
+// enough to store them
+func hashRecoveryCode(code string) string {
+	normalised := strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(normalised))
+	return hex.EncodeToString(sum[:])
+}
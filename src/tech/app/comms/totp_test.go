@@ -0,0 +1,133 @@
+package comms
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret - The SHA1 seed of RFC 6238 appendix B, "12345678901234567890"
+var rfcSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPVectors(t *testing.T) {
+
+	// RFC 6238 appendix B gives 8 digit codes, the 6 digit code is the last 6 of them
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "94287082"},
+		{unix: 1111111109, want: "07081804"},
+		{unix: 1111111111, want: "14050471"},
+		{unix: 1234567890, want: "89005924"},
+		{unix: 2000000000, want: "69279037"},
+		{unix: 20000000000, want: "65353130"},
+	}
+	for _, test := range tests {
+		now := time.Unix(test.unix, 0)
+		want := test.want[len(test.want)-totpDigits:]
+		if got := hotp([]byte("12345678901234567890"), uint64(totpStep(now))); got != want {
+			t.Errorf("hotp at %d = %s, want %s", test.unix, got, want)
+		}
+		step, ok := verifyTOTP(rfcSecret, want, now, 0)
+		if !ok || step != totpStep(now) {
+			t.Errorf("verifyTOTP at %d = %d, %v, want step %d", test.unix, step, ok, totpStep(now))
+		}
+	}
+}
+
+func TestVerifyTOTPWindow(t *testing.T) {
+
+	key, _ := totpEncoding.DecodeString(rfcSecret)
+	now := time.Unix(1234567890, 0)
+	current := totpStep(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{name: "two steps behind", offset: -2},
+		{name: "one step behind", offset: -1, ok: true},
+		{name: "current", offset: 0, ok: true},
+		{name: "one step ahead", offset: 1, ok: true},
+		{name: "two steps ahead", offset: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code := hotp(key, uint64(current+test.offset))
+			step, ok := verifyTOTP(rfcSecret, code, now, 0)
+			if ok != test.ok {
+				t.Fatalf("verifyTOTP accepted %v, want %v", ok, test.ok)
+			}
+			if ok && step != current+test.offset {
+				t.Errorf("verifyTOTP matched step %d, want %d", step, current+test.offset)
+			}
+		})
+	}
+
+	// Secrets are accepted whatever their case, malformed input never is
+	code := hotp(key, uint64(current))
+	if _, ok := verifyTOTP(strings.ToLower(rfcSecret), code, now, 0); !ok {
+		t.Error("verifyTOTP refused a lower case secret")
+	}
+	for _, bad := range []string{"", code[:totpDigits-1], code + "0"} {
+		if _, ok := verifyTOTP(rfcSecret, bad, now, 0); ok {
+			t.Errorf("verifyTOTP accepted the code %q", bad)
+		}
+	}
+	if _, ok := verifyTOTP("not base32!", code, now, 0); ok {
+		t.Error("verifyTOTP accepted a code for a malformed secret")
+	}
+}
+
+func TestVerifyTOTPReplay(t *testing.T) {
+
+	key, _ := totpEncoding.DecodeString(rfcSecret)
+	now := time.Unix(1234567890, 0)
+	current := totpStep(now)
+
+	step, ok := verifyTOTP(rfcSecret, hotp(key, uint64(current)), now, 0)
+	if !ok {
+		t.Fatal("verifyTOTP refused the current code")
+	}
+	if _, ok = verifyTOTP(rfcSecret, hotp(key, uint64(current)), now, step); ok {
+		t.Error("verifyTOTP accepted the same code twice")
+	}
+	// An older code still inside the window was issued before the one used
+	if _, ok = verifyTOTP(rfcSecret, hotp(key, uint64(current-1)), now, step); ok {
+		t.Error("verifyTOTP accepted a code older than the last one used")
+	}
+	if _, ok = verifyTOTP(rfcSecret, hotp(key, uint64(current+1)), now, step); !ok {
+		t.Error("verifyTOTP refused the next code after a use")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatalf("newRecoveryCodes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("code %q is not in the form xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q was issued twice", code)
+		}
+		seen[code] = true
+		if hashes[i] != hashRecoveryCode(code) {
+			t.Errorf("hash %d does not match its code", i)
+		}
+		// Typed without the dash, in capitals or with stray spaces it is the same code
+		typed := " " + strings.ToUpper(strings.Replace(code, "-", "", 1)) + " "
+		if hashRecoveryCode(typed) != hashes[i] {
+			t.Errorf("%q does not hash like %q", typed, code)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package comms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"tech/app/components"
+	"tech/app/logger"
+	"tech/mixer/config"
+	"time"
+)
+
+const (
+	twoFactorPolicyName = "twoFactor"
+)
+
+// errCodeRequired - The password was right but the user must also send a two-factor code
+var errCodeRequired = errors.New("two-factor code required")
+
+// twoFactorAction - Runs the TOTP enrolment and policy actions
+func (usr *UserAuth) twoFactorAction(caller components.Caller, action string, data map[string]interface{}) ([]byte, error) {
+
+	if caller.Username == "" {
+		return nil, components.PermissionError{Action: action, Reason: "requires a session"}
+	}
+	code, _ := data["code"].(string)
+
+	switch action {
+	case "EnrollTOTP":
+		return usr.enrollTOTP(caller)
+
+	case "ConfirmTOTP":
+		return usr.confirmTOTP(caller, code)
+
+	case "DisableTOTP":
+		username, _ := data["username"].(string)
+		return usr.disableTOTP(caller, action, username, code)
+
+	case "RegenerateRecoveryCodes":
+		return usr.regenerateRecoveryCodes(caller, code)
+
+	case "GetTwoFactorPolicy":
+		return json.MarshalIndent(map[string]interface{}{"roles": usr.requiredRoles()}, "", "\t")
+
+	case "SetTwoFactorPolicy":
+		err := caller.RequireAdmin(action)
+		if err != nil {
+			return nil, err
+		}
+		return usr.setTwoFactorPolicy(caller, data["roles"])
+	}
+
+	return nil, fmt.Errorf("unknown two-factor action '%s'", action)
+}
+
+func (usr *UserAuth) createPolicyTable(cfg *config.CfgService) (err error) {
+	policySchema := []string{
+		"requiredRoles TEXT"}
+
+	policyDefaults := map[string]string{
+		"requiredRoles": "''"}
+
+	err = cfg.CreateTable(twoFactorPolicyName, policySchema)
+	if err != nil {
+		return
+	}
+
+	return cfg.InitTable(twoFactorPolicyName, policyDefaults)
+}
+
+// requiredRoles - Roles whose users must use two-factor authentication
+func (usr *UserAuth) requiredRoles() []string {
+	value, err := usr.ConfigService.GetValue(twoFactorPolicyName, "requiredRoles")
+	if err != nil {
+		return []string{}
+	}
+	stored, _ := config.JSONstring(value)
+
+	roles := []string{}
+	for _, role := range strings.Split(stored, ",") {
+		if role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func (usr *UserAuth) twoFactorRequired(role string) bool {
+	for _, required := range usr.requiredRoles() {
+		if required == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (usr *UserAuth) setTwoFactorPolicy(caller components.Caller, value interface{}) ([]byte, error) {
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("'roles' must be a list")
+	}
+
+	roles := []string{}
+	for _, item := range list {
+		role, _ := item.(string)
+		err := validateRole(role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	err := usr.ConfigService.SetValue(twoFactorPolicyName, "requiredRoles", strings.Join(roles, ","))
+	if err != nil {
+		return nil, err
+	}
+	logger.Log("'%s' set two-factor authentication as required for roles %v", caller.Username, roles)
+
+	return json.MarshalIndent(map[string]interface{}{"roles": roles}, "", "\t")
+}
+
+// enrollTOTP - Starts enrolment with a new secret, which only takes effect once a code from
+// it has been confirmed
+func (usr *UserAuth) enrollTOTP(caller components.Caller) ([]byte, error) {
+
+	user, err := usr.ConfigService.GetUser(usr.Name, caller.Username)
+	if err != nil {
+		return nil, err
+	}
+	if enabled, _ := config.JSONbool(user["totpEnabled"]); enabled {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	err = usr.ConfigService.SetUserValue(usr.Name, caller.Username, "totpSecret", secret)
+	if err != nil {
+		return nil, err
+	}
+
+	uri := totpURI(secret, caller.Username)
+	return json.MarshalIndent(map[string]string{
+		"secret":    secret,
+		"uri":       uri,
+		"qrPayload": uri}, "", "\t")
+}
+
+// confirmTOTP - Enables two-factor authentication once the user proves their authenticator
+// works, and returns recovery codes. They are only ever shown here
+func (usr *UserAuth) confirmTOTP(caller components.Caller, code string) ([]byte, error) {
+
+	user, err := usr.ConfigService.GetUser(usr.Name, caller.Username)
+	if err != nil {
+		return nil, err
+	}
+	if enabled, _ := config.JSONbool(user["totpEnabled"]); enabled {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+	secret, _ := config.JSONstring(user["totpSecret"])
+	if secret == "" {
+		return nil, fmt.Errorf("call EnrollTOTP first")
+	}
+
+	step, ok := verifyTOTP(secret, code, time.Now(), 0)
+	if !ok {
+		return nil, fmt.Errorf("invalid code")
+	}
+
+	codes, err := usr.storeRecoveryCodes(caller.Username)
+	if err != nil {
+		return nil, err
+	}
+	err = usr.ConfigService.SetUserValue(usr.Name, caller.Username, "totpLastStep", step)
+	if err == nil {
+		err = usr.ConfigService.SetUserValue(usr.Name, caller.Username, "totpEnabled", true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A session limited to enrolment becomes a full one
+	usr.sessions.setScope(caller.Session, ScopeFull)
+	logger.Log("Two-factor authentication enabled for '%s'", caller.Username)
+
+	return json.MarshalIndent(map[string]interface{}{"recoveryCodes": codes}, "", "\t")
+}
+
+// disableTOTP - Users switch it off with a current code, unless their role requires it.
+// Admins can switch it off for someone else who has lost their authenticator
+func (usr *UserAuth) disableTOTP(caller components.Caller, action string, username string, code string) ([]byte, error) {
+
+	if username == "" || username == caller.Username {
+		if usr.twoFactorRequired(caller.Role) {
+			return nil, fmt.Errorf("two-factor authentication is required for %s accounts", caller.Role)
+		}
+		user, err := usr.ConfigService.GetUser(usr.Name, caller.Username)
+		if err != nil {
+			return nil, err
+		}
+		err = usr.proveSecondFactor(caller, user, code)
+		if err != nil {
+			return nil, err
+		}
+		username = caller.Username
+	} else {
+		err := caller.RequireAdmin(action)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = usr.findUser(username); err != nil {
+			return nil, err
+		}
+		usr.sessions.removeUser(username)
+	}
+
+	for column, value := range map[string]interface{}{"totpEnabled": false, "totpSecret": "", "recoveryCodes": "", "totpLastStep": int64(0)} {
+		err := usr.ConfigService.SetUserValue(usr.Name, username, column, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	logger.Log("Two-factor authentication disabled for '%s' by '%s'", username, caller.Username)
+
+	return nil, nil
+}
+
+// regenerateRecoveryCodes - Replaces every recovery code, proven with a current code
+func (usr *UserAuth) regenerateRecoveryCodes(caller components.Caller, code string) ([]byte, error) {
+
+	user, err := usr.ConfigService.GetUser(usr.Name, caller.Username)
+	if err != nil {
+		return nil, err
+	}
+	if enabled, _ := config.JSONbool(user["totpEnabled"]); !enabled {
+		return nil, fmt.Errorf("two-factor authentication is not enabled")
+	}
+	err = usr.proveSecondFactor(caller, user, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := usr.storeRecoveryCodes(caller.Username)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(map[string]interface{}{"recoveryCodes": codes}, "", "\t")
+}
+
+func (usr *UserAuth) storeRecoveryCodes(username string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(hashes)
+	if err != nil {
+		return nil, err
+	}
+	err = usr.ConfigService.SetUserValue(usr.Name, username, "recoveryCodes", string(encoded))
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// proveSecondFactor - Checks the code a logged in user gives to change their two-factor
+// settings. Wrong codes count against the same guard keys as failed logins
+func (usr *UserAuth) proveSecondFactor(caller components.Caller, user map[string]interface{}, code string) error {
+
+	keys := []string{userKey(caller.Username), sourceKey(caller.Source)}
+	err := usr.guard.check(keys...)
+	if err != nil {
+		loginBlocked.Inc()
+		logger.Log("Two-factor code for '%s' from '%s' refused, %v", caller.Username, caller.Source, err)
+		return err
+	}
+
+	err = usr.checkSecondFactor(caller.Username, user, code)
+	if err == errCodeRequired {
+		return err
+	} else if err != nil {
+		usr.loginFailed(keys)
+		return err
+	}
+	usr.guard.succeed(keys...)
+	return nil
+}
+
+// checkSecondFactor - Accepts a TOTP code or an unused recovery code for a user with
+// two-factor authentication enabled, users without it always pass
+func (usr *UserAuth) checkSecondFactor(username string, user map[string]interface{}, code string) error {
+
+	if enabled, _ := config.JSONbool(user["totpEnabled"]); !enabled {
+		return nil
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return errCodeRequired
+	}
+
+	secret, _ := config.JSONstring(user["totpSecret"])
+	lastStep, _ := config.JSONint64(user["totpLastStep"])
+	if step, ok := verifyTOTP(secret, code, time.Now(), lastStep); ok {
+		return usr.ConfigService.SetUserValue(usr.Name, username, "totpLastStep", step)
+	}
+
+	// Recovery codes are longer than TOTP codes and each works once
+	stored, _ := config.JSONstring(user["recoveryCodes"])
+	var hashes []string
+	json.Unmarshal([]byte(stored), &hashes)
+	hash := hashRecoveryCode(code)
+	for i, candidate := range hashes {
+		if candidate != hash {
+			continue
+		}
+		remaining := append(hashes[:i:i], hashes[i+1:]...)
+		encoded, err := json.Marshal(remaining)
+		if err != nil {
+			return err
+		}
+		logger.Log("'%s' used a recovery code, %d left", username, len(remaining))
+		return usr.ConfigService.SetUserValue(usr.Name, username, "recoveryCodes", string(encoded))
+	}
+
+	return fmt.Errorf("invalid two-factor code")
+}
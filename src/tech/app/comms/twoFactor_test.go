@@ -0,0 +1,94 @@
+package comms
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"tech/mixer/config"
+	"testing"
+	"time"
+)
+
+// newTestUserAuth - A UserAuth on a fresh database in 'dir'. NewCfgService registers the
+// sqlite driver, so it can only be called once per test binary
+func newTestUserAuth(t *testing.T, dir string) *UserAuth {
+	t.Helper()
+
+	cfg, err := config.NewCfgService(filepath.Join(dir, "config.db"))
+	if err != nil {
+		t.Fatalf("NewCfgService: %v", err)
+	}
+	usr := NewUserAuth(cfg)
+	cfg.Initialize()
+	return usr
+}
+
+func TestCheckSecondFactor(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "twoFactor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	usr := newTestUserAuth(t, dir)
+
+	const username = "user"
+	user := func() map[string]interface{} {
+		t.Helper()
+		user, err := usr.ConfigService.GetUser(usr.Name, username)
+		if err != nil || user["username"] == nil {
+			t.Fatalf("GetUser(%q) = %v, %v", username, user, err)
+		}
+		return user
+	}
+
+	if err = usr.checkSecondFactor(username, user(), ""); err != nil {
+		t.Fatalf("a user without two-factor authentication was refused, %v", err)
+	}
+
+	usr.ConfigService.SetUserValue(usr.Name, username, "totpSecret", rfcSecret)
+	usr.ConfigService.SetUserValue(usr.Name, username, "totpEnabled", true)
+	codes, err := usr.storeRecoveryCodes(username)
+	if err != nil {
+		t.Fatalf("storeRecoveryCodes: %v", err)
+	}
+
+	if err = usr.checkSecondFactor(username, user(), " "); err != errCodeRequired {
+		t.Errorf("a blank code returned %v, want errCodeRequired", err)
+	}
+	if err = usr.checkSecondFactor(username, user(), "000000"); err == nil || err == errCodeRequired {
+		t.Errorf("a wrong code returned %v, want it refused", err)
+	}
+
+	key, _ := totpEncoding.DecodeString(rfcSecret)
+	code := hotp(key, uint64(totpStep(time.Now())))
+	if err = usr.checkSecondFactor(username, user(), code); err != nil {
+		t.Fatalf("the current code was refused, %v", err)
+	}
+	if err = usr.checkSecondFactor(username, user(), code); err == nil {
+		t.Error("the current code was accepted twice")
+	}
+
+	// Recovery codes work once each, however they are typed
+	typed := strings.ToUpper(strings.Replace(codes[0], "-", "", 1))
+	if err = usr.checkSecondFactor(username, user(), typed); err != nil {
+		t.Fatalf("recovery code %q was refused, %v", typed, err)
+	}
+	for _, reuse := range []string{codes[0], typed} {
+		if err = usr.checkSecondFactor(username, user(), reuse); err == nil {
+			t.Errorf("used recovery code %q was accepted again", reuse)
+		}
+	}
+	if err = usr.checkSecondFactor(username, user(), codes[1]); err != nil {
+		t.Errorf("recovery code %q was refused after another was used, %v", codes[1], err)
+	}
+
+	stored, _ := config.JSONstring(user()["recoveryCodes"])
+	var hashes []string
+	json.Unmarshal([]byte(stored), &hashes)
+	if len(hashes) != recoveryCodeCount-2 {
+		t.Errorf("%d recovery codes are left, want %d", len(hashes), recoveryCodeCount-2)
+	}
+}
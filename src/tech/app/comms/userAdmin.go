@@ -0,0 +1,345 @@
+package comms
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"tech/app/components"
+	"tech/app/logger"
+	"tech/mixer/config"
+)
+
+const (
+	minPasswordLength = 8
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,32}$`)
+
+// UserInfo - A user as listed to admins
+type UserInfo struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+	LoggedIn bool   `json:"loggedIn"`
+}
+
+func validateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("username must be 3 to 32 letters, digits, '.', '_' or '-'")
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	return nil
+}
+
+func validateRole(role string) error {
+	for _, valid := range components.Roles {
+		if role == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown role '%s'", role)
+}
+
+// userAction - Runs one of the admin only user management actions
+func (usr *UserAuth) userAction(caller components.Caller, action string, data map[string]interface{}) ([]byte, error) {
+
+	err := caller.RequireAdmin(action)
+	if err != nil {
+		return nil, err
+	}
+
+	username, _ := data["username"].(string)
+
+	switch action {
+	case "CreateUser":
+		password, _ := data["password"].(string)
+		role, _ := data["role"].(string)
+		return usr.createUser(username, password, role)
+
+	case "DeleteUser":
+		return usr.deleteUser(caller, username)
+
+	case "ListUsers":
+		return usr.listUsers()
+
+	case "SetRole":
+		role, _ := data["role"].(string)
+		return usr.setRole(caller, username, role)
+
+	case "DisableUser":
+		disabled, ok := data["disabled"].(bool)
+		if !ok {
+			disabled = true
+		}
+		return usr.disableUser(caller, username, disabled)
+
+	case "ResetPassword":
+		password, _ := data["password"].(string)
+		return usr.resetPassword(caller, username, password)
+	}
+
+	return nil, fmt.Errorf("unknown user action '%s'", action)
+}
+
+// findUser - Returns the user named 'username', or an error if there is none
+func (usr *UserAuth) findUser(username string) (UserInfo, error) {
+
+	rows, err := usr.ConfigService.Query("SELECT configID, username, role, disabled, loggedIn FROM "+usr.Name+
+		" WHERE username = ?", username)
+	if err != nil {
+		return UserInfo{}, err
+	}
+	if len(rows) == 0 {
+		return UserInfo{}, fmt.Errorf("no user named '%s'", username)
+	}
+
+	return userInfo(rows[0]), nil
+}
+
+func userInfo(row map[string]interface{}) UserInfo {
+	var info UserInfo
+	info.ID, _ = config.JSONint64(row["configID"])
+	info.Username, _ = config.JSONstring(row["username"])
+	info.Role, _ = config.JSONstring(row["role"])
+	info.Disabled, _ = config.JSONbool(row["disabled"])
+	info.LoggedIn, _ = config.JSONbool(row["loggedIn"])
+	return info
+}
+
+// checkLastAdmin - Refuses to remove the admin rights of the only enabled admin, which
+// would leave nobody able to manage the device
+func (usr *UserAuth) checkLastAdmin(user UserInfo) error {
+
+	if user.Role != components.RoleAdmin || user.Disabled {
+		return nil
+	}
+
+	rows, err := usr.ConfigService.Query("SELECT count(*) AS admins FROM "+usr.Name+
+		" WHERE role = ? AND disabled = 0", components.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if admins, _ := config.JSONint64(rows[0]["admins"]); admins <= 1 {
+		return fmt.Errorf("'%s' is the last admin", user.Username)
+	}
+	return nil
+}
+
+func (usr *UserAuth) createUser(username string, password string, role string) ([]byte, error) {
+
+	if role == "" {
+		role = components.RoleUser
+	}
+	for _, err := range []error{validateUsername(username), validatePassword(password), validateRole(role)} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := usr.findUser(username); err == nil {
+		return nil, fmt.Errorf("user '%s' already exists", username)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	isAdmin := 0
+	if role == components.RoleAdmin {
+		isAdmin = 1
+	}
+
+	// configID is left to SQLite, which picks the next free row id
+	_, err = usr.ConfigService.Exec("INSERT INTO "+usr.Name+
+		" (username, password, isAdmin, loggedIn, ccExpiryMonth, ccExpiryYear, cardName, role, disabled)"+
+		" VALUES (?, ?, ?, 0, 0, 0, '', ?, 0)", username, hash, isAdmin, role)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log("Created %s '%s'", role, username)
+
+	user, err := usr.findUser(username)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(user, "", "\t")
+}
+
+func (usr *UserAuth) deleteUser(caller components.Caller, username string) ([]byte, error) {
+
+	user, err := usr.findUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if username == caller.Username {
+		return nil, fmt.Errorf("you cannot delete your own account")
+	}
+	err = usr.checkLastAdmin(user)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = usr.ConfigService.Exec("DELETE FROM "+usr.Name+" WHERE username = ?", username)
+	if err != nil {
+		return nil, err
+	}
+	_, err = usr.ConfigService.Exec("DELETE FROM "+cardTableName+" WHERE username = ?", username)
+	if err != nil {
+		logger.Log("Failed to remove the cards of '%s', %v", username, err)
+	}
+	usr.sessions.removeUser(username)
+	usr.guard.unlock(userKey(username))
+	logger.Log("Deleted user '%s'", username)
+
+	return nil, nil
+}
+
+func (usr *UserAuth) listUsers() ([]byte, error) {
+
+	rows, err := usr.ConfigService.Query("SELECT configID, username, role, disabled, loggedIn FROM " + usr.Name +
+		" ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]UserInfo, 0, len(rows))
+	for _, row := range rows {
+		users = append(users, userInfo(row))
+	}
+
+	return json.MarshalIndent(users, "", "\t")
+}
+
+// setRole - The user's sessions end so the new role applies from their next login
+func (usr *UserAuth) setRole(caller components.Caller, username string, role string) ([]byte, error) {
+
+	err := validateRole(role)
+	if err != nil {
+		return nil, err
+	}
+	user, err := usr.findUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if username == caller.Username {
+		return nil, fmt.Errorf("you cannot change your own role")
+	}
+	if role != components.RoleAdmin {
+		err = usr.checkLastAdmin(user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	isAdmin := int64(0)
+	if role == components.RoleAdmin {
+		isAdmin = 1
+	}
+	_, err = usr.ConfigService.Exec("UPDATE "+usr.Name+" SET role = ?, isAdmin = ? WHERE username = ?", role, isAdmin, username)
+	if err != nil {
+		return nil, err
+	}
+	usr.sessions.removeUser(username)
+	logger.Log("'%s' changed role of '%s' from %s to %s", caller.Username, username, user.Role, role)
+
+	user.Role = role
+	return json.MarshalIndent(user, "", "\t")
+}
+
+// disableUser - Disabled users cannot log in and lose their sessions straight away
+func (usr *UserAuth) disableUser(caller components.Caller, username string, disabled bool) ([]byte, error) {
+
+	user, err := usr.findUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if disabled {
+		if username == caller.Username {
+			return nil, fmt.Errorf("you cannot disable your own account")
+		}
+		err = usr.checkLastAdmin(user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if disabled {
+		err = usr.Disable(username)
+	} else {
+		err = usr.ConfigService.SetUserValue(usr.Name, username, "disabled", false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user.Disabled = disabled
+	return json.MarshalIndent(user, "", "\t")
+}
+
+// resetPassword - Sets a new password chosen by an admin and ends the user's sessions
+func (usr *UserAuth) resetPassword(caller components.Caller, username string, password string) ([]byte, error) {
+
+	user, err := usr.findUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	err = usr.SetPassword(username, password)
+	if err != nil {
+		return nil, err
+	}
+	usr.sessions.removeUser(username)
+	logger.Log("'%s' reset the password of '%s'", caller.Username, username)
+
+	return json.MarshalIndent(user, "", "\t")
+}
+
+// SetPassword - Validates, hashes and stores a new password for 'username'. Existing
+// sessions are left alone
+func (usr *UserAuth) SetPassword(username string, password string) error {
+
+	err := validatePassword(password)
+	if err != nil {
+		return err
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	err = usr.ConfigService.SetUserValue(usr.Name, username, "password", hash)
+	if err != nil {
+		return err
+	}
+	usr.guard.unlock(userKey(username))
+
+	return nil
+}
+
+// HasPassword - Reports whether 'password' is the current password of 'username'
+func (usr *UserAuth) HasPassword(username string, password string) bool {
+	user, err := usr.ConfigService.GetUser(usr.Name, username)
+	if err != nil || user["username"] == nil {
+		return false
+	}
+	stored, _ := config.JSONstring(user["password"])
+	match, _ := checkPassword(stored, password)
+	return match
+}
+
+// Disable - Stops 'username' from logging in and ends their sessions
+func (usr *UserAuth) Disable(username string) error {
+	err := usr.ConfigService.SetUserValue(usr.Name, username, "disabled", true)
+	if err != nil {
+		return err
+	}
+	usr.sessions.removeUser(username)
+	return nil
+}
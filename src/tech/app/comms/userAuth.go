@@ -4,12 +4,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"tech/app/components"
+	"tech/app/events"
 	"tech/app/logger"
+	"tech/app/metrics"
+	"tech/app/payments"
 	"tech/mixer/config"
+	"time"
 )
 
 const (
 	userAuthName = "userAuth"
+
+	// LockoutEvent - Published when a username or source address is locked out
+	LockoutEvent = "lockout"
+)
+
+var (
+	loginFailures = metrics.NewCounter("login_failures_total", "Failed login attempts.")
+	loginBlocked  = metrics.NewCounter("login_blocked_total", "Login attempts refused while delayed or locked out.")
+	loginLockouts = metrics.NewCounter("login_lockouts_total", "Lockouts caused by repeated login failures.")
 )
 
 // UserAuth -
@@ -20,6 +33,11 @@ type UserAuth struct {
 	password string
 	isAdmin  bool
 	loggedIn bool
+
+	sessions *sessionStore
+	guard    *loginGuard
+	// Set by the Host with SetPaymentProvider, cards cannot be saved until then
+	payments payments.PaymentProvider
 }
 
 // NewUserAuth -
@@ -28,14 +46,23 @@ func NewUserAuth(cfg *config.CfgService) *UserAuth {
 	user := &UserAuth{}
 	user.Name = userAuthName
 	user.ConfigService = cfg
+	user.sessions = newSessionStore()
+	user.guard = newLoginGuard()
 
 	cfg.Register(user.Name, user.createUserTable)
+	cfg.Register(twoFactorPolicyName, user.createPolicyTable)
+	cfg.Register(cardTableName, user.createCardTables)
 
 	return user
 }
 
 // Action -
 func (usr *UserAuth) Action(action string, data []byte) (response []byte, err error) {
+	return usr.CallerAction(components.Caller{}, action, data)
+}
+
+// CallerAction -
+func (usr *UserAuth) CallerAction(caller components.Caller, action string, data []byte) (response []byte, err error) {
 
 	var mapData map[string]interface{}
 	mapData, err = config.JsonToMap(data)
@@ -46,19 +73,64 @@ func (usr *UserAuth) Action(action string, data []byte) (response []byte, err er
 
 	switch action {
 	case "Login":
-		response, err = usr.login(mapData["username"].(string), mapData["password"].(string))
+		username, _ := mapData["username"].(string)
+		password, ok := mapData["password"].(string)
+		if username == "" || !ok {
+			return nil, fmt.Errorf("'username' and 'password' are required")
+		}
+		code, _ := mapData["code"].(string)
+		response, err = usr.login(caller, username, password, code)
 
 	case "UpdatePassword":
-		response, err = usr.passwordChange(mapData["username"].(string), mapData["currentPassword"].(string), mapData["newPassword"].(string))
+		username, _ := mapData["username"].(string)
+		currentPassword, ok := mapData["currentPassword"].(string)
+		newPassword, _ := mapData["newPassword"].(string)
+		if username == "" || !ok || newPassword == "" {
+			return nil, fmt.Errorf("'username', 'currentPassword' and 'newPassword' are required")
+		}
+		code, _ := mapData["code"].(string)
+		response, err = usr.passwordChange(caller, username, currentPassword, newPassword, code)
 
 	case "Logout":
-		response, err = usr.logout(mapData["username"].(string))
+		username, _ := mapData["username"].(string)
+		if username == "" {
+			return nil, fmt.Errorf("'username' is required")
+		}
+		response, err = usr.logout(caller, username)
+
+	case "GetPaymentInfo", "SetPaymentInfo", "ClearPaymentInfo":
+		response, err = usr.paymentAction(caller, action, mapData)
+
+	case "CreateUser", "DeleteUser", "ListUsers", "SetRole", "DisableUser", "ResetPassword":
+		response, err = usr.userAction(caller, action, mapData)
+
+	case "EnrollTOTP", "ConfirmTOTP", "DisableTOTP", "RegenerateRecoveryCodes", "GetTwoFactorPolicy", "SetTwoFactorPolicy":
+		response, err = usr.twoFactorAction(caller, action, mapData)
 
-	case "GetPaymentInfo":
-		response, err = usr.GetPaymentInfo(mapData["username"].(string))
+	case "LoginByCard":
+		uid, _ := mapData["uid"].(string)
+		code, _ := mapData["code"].(string)
+		response, err = usr.loginByCard(caller, uid, code)
 
-	case "SetPaymentInfo":
-		response, err = usr.SetPaymentInfo(mapData)
+	case "EnrollCard", "RemoveCard", "ListCards":
+		response, err = usr.cardAction(caller, action, mapData)
+
+	case "LoginByPin":
+		username, _ := mapData["username"].(string)
+		pin, _ := mapData["pin"].(string)
+		response, err = usr.loginByPin(caller, username, pin)
+
+	case "SetPin", "ClearPin":
+		response, err = usr.pinAction(caller, action, mapData)
+
+	case "Unlock":
+		response, err = usr.unlock(caller, action, mapData)
+
+	case "GetLockouts":
+		err = caller.RequireAdmin(action)
+		if err == nil {
+			response, err = json.MarshalIndent(usr.guard.lockouts(), "", "\t")
+		}
 
 	default:
 		logger.Log("Unrecognized action received in '%s': '%s'", usr.Name, action)
@@ -77,60 +149,108 @@ func (usr *UserAuth) Stop() error {
 	return nil
 }
 
-// createUserTable - Generates the tech (rowId=0) and user (rowId=1) rows in userInfo table
+// createUserTable - Creates the userInfo table, migrates tables from older releases and
+// seeds the default tech (rowId=0) and user (rowId=1) rows into an empty table
 func (usr *UserAuth) createUserTable(cfg *config.CfgService) (err error) {
 	userSchema := []string{
 		"username TEXT",
 		"password TEXT",
 		"isAdmin INTEGER",
 		"loggedIn INTEGER",
-		"ccNumber INTEGER",
 		"ccExpiryMonth INTEGER",
 		"ccExpiryYear INTEGER",
-		"cvv INTEGER",
 		"cardName TEXT"}
 
+	// Columns added since the first release. Older tables also still have the ccNumber and
+	// cvv columns, which are kept empty
+	addedColumns := []string{
+		"role TEXT",
+		"disabled INTEGER DEFAULT 0",
+		"totpSecret TEXT",
+		"totpEnabled INTEGER DEFAULT 0",
+		"totpLastStep INTEGER DEFAULT 0",
+		"recoveryCodes TEXT",
+		"pinHash TEXT",
+		"paymentToken TEXT",
+		"ccLast4 TEXT",
+		"cardBrand TEXT"}
+
 	userDefaultAdmin := map[string]string{
 		"username":      "'admin'",
 		"password":      "'admin'",
 		"isAdmin":       "1",
 		"loggedIn":      "0",
-		"ccNumber":      "",
 		"ccExpiryMonth": "0",
 		"ccExpiryYear":  "0",
-		"cvv":           "0",
-		"cardName":      ""}
+		"cardName":      "''",
+		"role":          "'" + components.RoleAdmin + "'",
+		"disabled":      "0"}
 
 	userDefault := map[string]string{
 		"username":      "'user'",
 		"password":      "'user'",
 		"isAdmin":       "0",
 		"loggedIn":      "0",
-		"ccNumber":      "",
 		"ccExpiryMonth": "0",
 		"ccExpiryYear":  "0",
-		"cvv":           "0",
-		"cardName":      ""}
+		"cardName":      "''",
+		"role":          "'" + components.RoleUser + "'",
+		"disabled":      "0"}
 
-	err = cfg.CreateTable(usr.Name, userSchema)
+	err = cfg.CreateTable(usr.Name, append(userSchema, addedColumns...))
 	if err != nil {
 		return err
 	}
 
-	err = cfg.InitUser(usr.Name, userDefaultAdmin, 0)
+	for _, column := range addedColumns {
+		err = cfg.AddColumn(usr.Name, column)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = cfg.Exec("UPDATE "+usr.Name+" SET role = CASE WHEN isAdmin = 1 THEN ? ELSE ? END WHERE role IS NULL",
+		components.RoleAdmin, components.RoleUser)
 	if err != nil {
 		return err
 	}
+	_, err = cfg.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + usr.Name + "_username ON " + usr.Name + " (username)")
+	if err != nil {
+		logger.Log("Cannot enforce unique usernames in '%s', %v", usr.Name, err)
+	}
 
-	err = cfg.InitUser(usr.Name, userDefault, 1)
+	count, err := cfg.Query("SELECT count(*) AS users FROM " + usr.Name)
 	if err != nil {
+		return err
+	}
+	if users, _ := config.JSONint64(count[0]["users"]); users > 0 {
 		return nil
 	}
 
-	return nil
+	err = cfg.InitUser(usr.Name, userDefaultAdmin, 0)
+	if err != nil {
+		return err
+	}
+
+	return cfg.InitUser(usr.Name, userDefault, 1)
+}
+
+// Session - Returns the live session identified by 'token'
+func (usr *UserAuth) Session(token string) (Session, bool) {
+	if token == "" {
+		return Session{}, false
+	}
+	return usr.sessions.get(token)
 }
 
-func (usr *UserAuth) login(username string, password string) ([]byte, error) {
+func (usr *UserAuth) login(caller components.Caller, username string, password string, code string) ([]byte, error) {
+
+	keys := []string{userKey(username), sourceKey(caller.Source)}
+	err := usr.guard.check(keys...)
+	if err != nil {
+		loginBlocked.Inc()
+		logger.Log("Login for '%s' from '%s' refused, %v", username, caller.Source, err)
+		return nil, err
+	}
 
 	user, err := usr.ConfigService.GetUser(usr.Name, username)
 	if err != nil {
@@ -138,24 +258,131 @@ func (usr *UserAuth) login(username string, password string) ([]byte, error) {
 	}
 
 	// Check for invalid username and password
+	stored, _ := config.JSONstring(user["password"])
 	if user["username"] != nil && user["password"] != nil {
-		if user["password"].(string) != password {
+		match, upgrade := checkPassword(stored, password)
+		if !match {
+			usr.loginFailed(keys)
 			return nil, fmt.Errorf("invalid password")
 		}
+		if upgrade {
+			usr.upgradePassword(username, password)
+		}
+		if disabled, _ := config.JSONbool(user["disabled"]); disabled {
+			return nil, fmt.Errorf("account disabled")
+		}
+
+		err = usr.checkSecondFactor(username, user, code)
+		if err == errCodeRequired {
+			// Not a failure, the client asks for the code and logs in again
+			return json.MarshalIndent(map[string]interface{}{"username": "", "twoFactorRequired": true}, "", "\t")
+		} else if err != nil {
+			usr.loginFailed(keys)
+			return nil, err
+		}
 	} else {
-		user["username"] = ""
-		user["password"] = ""
+		// Unknown usernames count too, or they could be probed freely. Hashing anyway and
+		// answering as for a wrong password keeps them from being told apart
+		checkPassword(dummyHash, password)
+		usr.loginFailed(keys)
+		return nil, fmt.Errorf("invalid password")
 	}
 
-	if user["loggedIn"] != 1 && user["username"] != "" {
+	return usr.startSession(caller, keys, user, ScopeFull)
+}
+
+// startSession - Marks a user who has proven who they are as logged in and returns their
+// row with a new session token. Full sessions are limited to enrolment while the user still
+// has to set up two-factor authentication
+func (usr *UserAuth) startSession(caller components.Caller, keys []string, user map[string]interface{}, scope string) ([]byte, error) {
+
+	username, _ := config.JSONstring(user["username"])
+	if user["loggedIn"] != 1 {
 		user["loggedIn"] = 1
 		usr.ConfigService.SetUserValue(usr.Name, username, "loggedIn", int64(1))
 	}
 
+	user = publicUser(user)
+	usr.guard.succeed(keys...)
+
+	role, _ := config.JSONstring(user["role"])
+	if enabled, _ := config.JSONbool(user["totpEnabled"]); scope == ScopeFull && !enabled && usr.twoFactorRequired(role) {
+		scope = ScopeEnrol
+		user["twoFactorEnrolRequired"] = true
+	}
+
+	session, err := usr.sessions.create(username, role, scope, caller.Source)
+	if err != nil {
+		return nil, err
+	}
+	user["sessionToken"] = session.Token
+	user["sessionScope"] = session.Scope
+	user["sessionExpires"] = session.Expires.UTC().Format(time.RFC3339)
+
 	return json.MarshalIndent(user, "", "\t")
 }
 
-func (usr *UserAuth) logout(username string) ([]byte, error) {
+// upgradePassword - Replaces a legacy plaintext password with its hash
+func (usr *UserAuth) upgradePassword(username string, password string) {
+	hash, err := hashPassword(password)
+	if err == nil {
+		err = usr.ConfigService.SetUserValue(usr.Name, username, "password", hash)
+	}
+	if err != nil {
+		logger.Log("Failed to hash stored password for '%s', %v", username, err)
+		return
+	}
+	logger.Log("Upgraded stored password for '%s' to a hash", username)
+}
+
+// loginFailed - Counts a failure and announces any lockout it caused
+func (usr *UserAuth) loginFailed(keys []string) {
+	loginFailures.Inc()
+	for _, lockout := range usr.guard.fail(keys...) {
+		loginLockouts.Inc()
+		logger.Log("Locked out '%s' after %d failed logins, until %s", lockout.Key, lockout.Failures, lockout.Until.Format(time.RFC3339))
+		events.Publish(LockoutEvent, usr.Name, map[string]interface{}{
+			"key":      lockout.Key,
+			"failures": lockout.Failures,
+			"until":    lockout.Until.UTC().Format(time.RFC3339)})
+	}
+}
+
+// unlock - Lets an admin clear the failures of a username or source address
+func (usr *UserAuth) unlock(caller components.Caller, action string, data map[string]interface{}) ([]byte, error) {
+
+	err := caller.RequireAdmin(action)
+	if err != nil {
+		return nil, err
+	}
+
+	// A username's password and PIN failures are cleared together
+	var keys []string
+	if username, ok := data["username"].(string); ok && username != "" {
+		keys = []string{userKey(username), pinKey(username)}
+	} else if source, ok := data["source"].(string); ok && source != "" {
+		keys = []string{sourceKey(source)}
+	} else {
+		return nil, fmt.Errorf("'username' or 'source' is required")
+	}
+
+	unlocked := []string{}
+	for _, key := range keys {
+		if usr.guard.unlock(key) {
+			unlocked = append(unlocked, key)
+			logger.Log("'%s' unlocked by '%s'", key, caller.Username)
+		}
+	}
+	if len(unlocked) == 0 {
+		return nil, fmt.Errorf("'%s' is not locked", keys[0])
+	}
+
+	return json.Marshal(map[string][]string{"unlocked": unlocked})
+}
+
+func (usr *UserAuth) logout(caller components.Caller, username string) ([]byte, error) {
+	usr.sessions.remove(caller.Session)
+
 	err := usr.ConfigService.SetUserValue(usr.Name, username, "loggedIn", int64(0))
 	if err != nil {
 		return nil, err
@@ -163,39 +390,69 @@ func (usr *UserAuth) logout(username string) ([]byte, error) {
 	return nil, nil
 }
 
-func (usr *UserAuth) passwordChange(username string, oldPassword string, newPassword string) ([]byte, error) {
-	user, err := usr.ConfigService.GetUser(usr.Name, username)
-	if err != nil || oldPassword != user["password"].(string) {
+// passwordChange - Replaces a user's password once the current one, and the second factor
+// when enabled, are proven. Attempts go through the same guard as logins, and the user's
+// other sessions end on success
+func (usr *UserAuth) passwordChange(caller components.Caller, username string, oldPassword string, newPassword string, code string) ([]byte, error) {
+
+	keys := []string{userKey(username), sourceKey(caller.Source)}
+	err := usr.guard.check(keys...)
+	if err != nil {
+		loginBlocked.Inc()
+		logger.Log("Password change for '%s' from '%s' refused, %v", username, caller.Source, err)
 		return nil, err
 	}
 
-	err = usr.ConfigService.SetUserValue(usr.Name, username, "password", newPassword)
+	user, err := usr.ConfigService.GetUser(usr.Name, username)
 	if err != nil {
 		return nil, err
 	}
 
-	user["password"] = newPassword
+	if user["username"] == nil || user["password"] == nil {
+		checkPassword(dummyHash, oldPassword)
+		usr.loginFailed(keys)
+		return nil, fmt.Errorf("invalid password")
+	}
 
-	return json.MarshalIndent(user, "", "\t")
-}
+	stored, _ := config.JSONstring(user["password"])
+	if match, _ := checkPassword(stored, oldPassword); !match {
+		usr.loginFailed(keys)
+		return nil, fmt.Errorf("invalid password")
+	}
 
-func (usr *UserAuth) GetPaymentInfo(username string) ([]byte, error) {
-	response, err := usr.ConfigService.GetUser(usr.Name, username)
+	// A stolen password alone is not enough to lock the owner out of their account
+	err = usr.checkSecondFactor(username, user, code)
+	if err == errCodeRequired {
+		return nil, err
+	} else if err != nil {
+		usr.loginFailed(keys)
+		return nil, err
+	}
+	usr.guard.succeed(keys...)
+
+	err = validatePassword(newPassword)
 	if err != nil {
 		return nil, err
 	}
 
-	return json.MarshalIndent(response, "", "\t")
-}
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return nil, err
+	}
 
-func (usr *UserAuth) SetPaymentInfo(data map[string]interface{}) ([]byte, error) {
-	err := usr.ConfigService.SetUserValue(usr.Name, data["username"].(string), "ccNumber", data["ccNumber"])
-	err = usr.ConfigService.SetUserValue(usr.Name, data["username"].(string), "ccExpiryMonth", data["ccExpiryMonth"])
-	err = usr.ConfigService.SetUserValue(usr.Name, data["username"].(string), "ccExpiryYear", data["ccExpiryYear"])
-	err = usr.ConfigService.SetUserValue(usr.Name, data["username"].(string), "cvv", data["cvv"])
-	err = usr.ConfigService.SetUserValue(usr.Name, data["username"].(string), "cardName", data["cardName"])
+	err = usr.ConfigService.SetUserValue(usr.Name, username, "password", hash)
 	if err != nil {
 		return nil, err
 	}
-	return nil, nil
+
+	// Anyone holding a session from the old password is logged out, the caller's own
+	// session is kept when it is the same user's
+	keep := ""
+	if caller.Username == username {
+		keep = caller.Session
+	}
+	usr.sessions.removeOthers(username, keep)
+	logger.Log("Password changed for '%s', other sessions ended", username)
+
+	return json.MarshalIndent(publicUser(user), "", "\t")
 }
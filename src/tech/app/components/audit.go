@@ -0,0 +1,317 @@
+package components
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"tech/app/events"
+	"tech/app/logger"
+	"tech/mixer/config"
+	"time"
+)
+
+const (
+	auditName = "audit"
+
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+	redacted          = "[redacted]"
+	systemActor       = "system"
+)
+
+// sensitiveKeys - Parameters containing any of these (case insensitive) are never stored
+var sensitiveKeys = []string{"password", "pin", "cvv", "ccnumber", "secret", "token", "code", "uid"}
+
+// AuditEntry - One recorded state changing action
+type AuditEntry struct {
+	ID        int64  `json:"id"`
+	Timestamp string `json:"timestamp"`
+	Actor     string `json:"actor"`
+	Source    string `json:"source"`
+	Peer      string `json:"peer"`
+	Target    string `json:"target"`
+	Action    string `json:"action"`
+	Params    string `json:"params"`
+	Result    string `json:"result"`
+}
+
+// auditFilter - Query parameters accepted by the Query and Export actions
+type auditFilter struct {
+	Actor  string `json:"actor"`
+	Target string `json:"target"`
+	Action string `json:"action"`
+	Result string `json:"result"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+	Format string `json:"format"`
+}
+
+// Audit - Append-only record of who changed what on the device
+type Audit struct {
+	MixerComponent
+}
+
+// NewAudit -
+func NewAudit(cfg *config.CfgService) *Audit {
+
+	aud := &Audit{}
+	aud.Name = auditName
+	aud.ConfigService = cfg
+
+	cfg.Register(aud.Name, aud.createTable)
+
+	return aud
+}
+
+// Action -
+func (aud *Audit) Action(action string, data []byte) (response []byte, err error) {
+	return aud.CallerAction(Caller{}, action, data)
+}
+
+// CallerAction - Only admins may read the audit trail
+func (aud *Audit) CallerAction(caller Caller, action string, data []byte) (response []byte, err error) {
+
+	err = caller.RequireAdmin(action)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter auditFilter
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &filter)
+		if err != nil {
+			logger.Log("Failed to unmarshall data on '%s'", aud.Name)
+			return
+		}
+	}
+
+	switch action {
+	case "Query":
+		response, err = aud.query(filter)
+
+	case "Export":
+		response, err = aud.export(filter)
+
+	default:
+		logger.Log("Unrecognized action received in '%s': '%s'", aud.Name, action)
+	}
+
+	return
+}
+
+// Start -
+func (aud *Audit) Start() error {
+	return nil
+}
+
+// Stop -
+func (aud *Audit) Stop() error {
+	return nil
+}
+
+// createTable - Triggers reject any UPDATE or DELETE so rows can only be appended
+func (aud *Audit) createTable(cfg *config.CfgService) (err error) {
+	auditSchema := []string{
+		"timestamp TEXT",
+		"actor TEXT",
+		"source TEXT",
+		"peer TEXT",
+		"target TEXT",
+		"action TEXT",
+		"params TEXT",
+		"result TEXT"}
+
+	err = cfg.CreateTable(aud.Name, auditSchema)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range []string{"UPDATE", "DELETE"} {
+		_, err = cfg.Exec("CREATE TRIGGER IF NOT EXISTS " + aud.Name + "_no_" + strings.ToLower(op) +
+			" BEFORE " + op + " ON " + aud.Name + " BEGIN SELECT RAISE(ABORT, 'audit trail is append-only'); END")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = cfg.Exec("CREATE INDEX IF NOT EXISTS " + aud.Name + "_timestamp ON " + aud.Name + " (timestamp)")
+	return err
+}
+
+// Record - Appends an entry, 'params' is the raw JSON request and is sanitised before storing.
+// Callers without a session are recorded as anonymous, a username they claim, e.g. at login,
+// stays in the params
+func (aud *Audit) Record(caller Caller, target string, action string, params []byte, result error) {
+
+	actor := caller.Username
+	paramMap, _ := config.JsonToMap(params)
+	if actor == "" {
+		actor = "anonymous"
+	}
+
+	resultText := "ok"
+	if result != nil {
+		resultText = "error: " + result.Error()
+	}
+
+	sanitised := ""
+	if paramMap != nil {
+		encoded, err := json.Marshal(SanitizeParams(paramMap))
+		if err == nil {
+			sanitised = string(encoded)
+		}
+	}
+
+	_, err := aud.ConfigService.Exec("INSERT INTO "+aud.Name+
+		" (timestamp, actor, source, peer, target, action, params, result) VALUES (?,?,?,?,?,?,?,?)",
+		time.Now().UTC().Format(time.RFC3339), actor, caller.Source, caller.Peer, target, action, sanitised, resultText)
+	if err != nil {
+		logger.Log("Failed to record audit entry for '%s' '%s', %v", target, action, err)
+	}
+}
+
+// RecordEvent - Appends a device event, such as a lockout, with the device itself as the actor
+func (aud *Audit) RecordEvent(event events.Event) {
+	params, _ := json.Marshal(event.Data)
+	aud.Record(Caller{Username: systemActor}, event.Source, event.Type, params, nil)
+}
+
+// SanitizeParams - Returns a copy of 'params' with credentials and card data redacted
+func SanitizeParams(params map[string]interface{}) map[string]interface{} {
+	clean := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		if isSensitive(key) {
+			clean[key] = redacted
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			clean[key] = SanitizeParams(nested)
+		} else {
+			clean[key] = value
+		}
+	}
+	return clean
+}
+
+func isSensitive(key string) bool {
+	lower := strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(lower, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// where - Builds the WHERE clause and arguments for 'filter'
+func (filter auditFilter) where() (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+
+	add := func(clause string, value string) {
+		if value != "" {
+			clauses = append(clauses, clause)
+			args = append(args, value)
+		}
+	}
+	add("actor = ?", filter.Actor)
+	add("target = ?", filter.Target)
+	add("action = ?", filter.Action)
+	add("timestamp >= ?", filter.From)
+	add("timestamp <= ?", filter.To)
+	switch filter.Result {
+	case "ok":
+		clauses = append(clauses, "result = 'ok'")
+	case "error":
+		clauses = append(clauses, "result <> 'ok'")
+	}
+
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args
+}
+
+func (aud *Audit) entries(filter auditFilter, limit int) ([]AuditEntry, error) {
+
+	where, args := filter.where()
+	args = append(args, limit, filter.Offset)
+	rows, err := aud.ConfigService.Query("SELECT * FROM "+aud.Name+where+" ORDER BY configID DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]AuditEntry, 0, len(rows))
+	for _, row := range rows {
+		var entry AuditEntry
+		entry.ID, _ = config.JSONint64(row["configID"])
+		entry.Timestamp, _ = config.JSONstring(row["timestamp"])
+		entry.Actor, _ = config.JSONstring(row["actor"])
+		entry.Source, _ = config.JSONstring(row["source"])
+		entry.Peer, _ = config.JSONstring(row["peer"])
+		entry.Target, _ = config.JSONstring(row["target"])
+		entry.Action, _ = config.JSONstring(row["action"])
+		entry.Params, _ = config.JSONstring(row["params"])
+		entry.Result, _ = config.JSONstring(row["result"])
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (aud *Audit) query(filter auditFilter) ([]byte, error) {
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	} else if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+
+	entries, err := aud.entries(filter, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := filter.where()
+	count, err := aud.ConfigService.Query("SELECT count(*) AS total FROM "+aud.Name+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	total, _ := config.JSONint64(count[0]["total"])
+
+	return json.MarshalIndent(map[string]interface{}{
+		"total":   total,
+		"limit":   limit,
+		"offset":  filter.Offset,
+		"entries": entries}, "", "\t")
+}
+
+// export - Returns every matching entry as CSV, or JSON if requested
+func (aud *Audit) export(filter auditFilter) ([]byte, error) {
+
+	// -1 removes the LIMIT in SQLite
+	entries, err := aud.entries(filter, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if filter.Format == "json" {
+		return json.MarshalIndent(entries, "", "\t")
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"id", "timestamp", "actor", "source", "peer", "target", "action", "params", "result"})
+	for _, entry := range entries {
+		writer.Write([]string{fmt.Sprintf("%d", entry.ID), entry.Timestamp, entry.Actor, entry.Source, entry.Peer,
+			entry.Target, entry.Action, entry.Params, entry.Result})
+	}
+	writer.Flush()
+
+	return buf.Bytes(), writer.Error()
+}
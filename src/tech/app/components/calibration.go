@@ -0,0 +1,229 @@
+package components
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"tech/app/logger"
+	"tech/mixer/config"
+	"time"
+)
+
+const (
+	calibrationName = "calibration"
+
+	// defaultMlPerCycle - Assumed for channels that have not been calibrated
+	defaultMlPerCycle = 10.0
+
+	defaultTestCycles = 5
+	maxTestCycles     = 20
+
+	// Calibrations outside this range point at a mistyped measurement
+	minMlPerCycle = 0.1
+	maxMlPerCycle = 100.0
+
+	// MaxPourMl - Largest amount a single channel pours for one drink
+	MaxPourMl = 500.0
+)
+
+// ChannelCalibration - Volume one motor cycle pours on a channel. CalibratedAt is empty
+// while the default is in use, TestCycles is set while a test pour awaits its measurement
+type ChannelCalibration struct {
+	Channel      int     `json:"channel"`
+	MlPerCycle   float64 `json:"mlPerCycle"`
+	CalibratedAt string  `json:"calibratedAt"`
+	TestCycles   int     `json:"testCycles"`
+}
+
+func (mxr *MixerControl) createCalibrationTable(cfg *config.CfgService) (err error) {
+	calibrationSchema := []string{}
+	calibrationDefaults := map[string]string{}
+	for channel := 0; channel < ChannelCount; channel++ {
+		suffix := strconv.Itoa(channel)
+		calibrationSchema = append(calibrationSchema, "mlPerCycle"+suffix+" REAL", "calibratedAt"+suffix+" TEXT")
+		calibrationDefaults["mlPerCycle"+suffix] = strconv.FormatFloat(defaultMlPerCycle, 'f', -1, 64)
+		calibrationDefaults["calibratedAt"+suffix] = "''"
+	}
+
+	err = cfg.CreateTable(calibrationName, calibrationSchema)
+	if err != nil {
+		return
+	}
+
+	return cfg.InitTable(calibrationName, calibrationDefaults)
+}
+
+// calibrationAction - Runs the calibration workflow, operators and admins only
+func (mxr *MixerControl) calibrationAction(caller Caller, action string, data map[string]interface{}) ([]byte, error) {
+
+	err := caller.RequireOperator(action)
+	if err != nil {
+		return nil, err
+	}
+
+	if action == "GetCalibration" {
+		return mxr.calibrationJSON()
+	}
+
+	channel, err := channelParam(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch action {
+	case "StartCalibration":
+		cycles := defaultTestCycles
+		if value, ok := data["cycles"]; ok {
+			cycles, _ = config.JSONint(value)
+		}
+		err = mxr.startCalibration(caller, channel, cycles)
+
+	case "SetCalibration":
+		measured, ok := data["measuredMl"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("'measuredMl' is required")
+		}
+		err = mxr.setCalibration(caller, channel, measured)
+
+	default:
+		return nil, fmt.Errorf("unknown calibration action '%s'", action)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return mxr.calibrationJSON()
+}
+
+func channelParam(data map[string]interface{}) (int, error) {
+	value, ok := data["channel"].(float64)
+	if !ok || value != math.Trunc(value) || value < 0 || value >= ChannelCount {
+		return 0, fmt.Errorf("'channel' must be 0 to %d", ChannelCount-1)
+	}
+	return int(value), nil
+}
+
+// Calibration - Current calibration of every channel
+func (mxr *MixerControl) Calibration() ([]ChannelCalibration, error) {
+
+	value, err := mxr.ConfigService.Get(calibrationName)
+	if err != nil {
+		return nil, err
+	}
+	row, err := config.JsonToMap(value)
+	if err != nil {
+		return nil, err
+	}
+
+	mxr.calibrationLock.Lock()
+	defer mxr.calibrationLock.Unlock()
+
+	channels := make([]ChannelCalibration, ChannelCount)
+	for channel := range channels {
+		suffix := strconv.Itoa(channel)
+		channels[channel].Channel = channel
+		channels[channel].MlPerCycle, _ = config.JSONfloat64(row["mlPerCycle"+suffix])
+		channels[channel].CalibratedAt, _ = config.JSONstring(row["calibratedAt"+suffix])
+		channels[channel].TestCycles = mxr.testCycles[channel]
+		if channels[channel].MlPerCycle <= 0 {
+			channels[channel].MlPerCycle = defaultMlPerCycle
+		}
+	}
+	return channels, nil
+}
+
+func (mxr *MixerControl) calibrationJSON() ([]byte, error) {
+	channels, err := mxr.Calibration()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(channels, "", "\t")
+}
+
+// startCalibration - Runs 'cycles' motor cycles on 'channel' into a measuring jug. The
+// operator then reports how much came out with SetCalibration
+func (mxr *MixerControl) startCalibration(caller Caller, channel int, cycles int) error {
+
+	if cycles < 1 || cycles > maxTestCycles {
+		return fmt.Errorf("'cycles' must be 1 to %d", maxTestCycles)
+	}
+
+	// Test pours come out of the bottle like any other, at the volume the current calibration
+	// expects of them
+	calibration, err := mxr.Calibration()
+	if err != nil {
+		return err
+	}
+	amounts := make([]float64, ChannelCount)
+	amounts[channel] = float64(cycles) * calibration[channel].MlPerCycle
+	err = mxr.checkStock(amounts)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("'%s' started a %d cycle test pour on channel %d", caller.Username, cycles, channel)
+	mxr.hardwareLock.Lock()
+	err = mxr.begin(MixerCalibrating, fmt.Sprintf("test pour on channel %d", channel))
+	if err == nil {
+		err = mxr.motorCall(channel, cycles)
+		if err == nil || mxr.EmergencyStopped() {
+			mxr.consume(channel, amounts[channel])
+		}
+		if err != nil {
+			err = fmt.Errorf("test pour failed")
+		}
+		err = mxr.settle(err)
+	}
+	mxr.hardwareLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	mxr.calibrationLock.Lock()
+	mxr.testCycles[channel] = cycles
+	mxr.calibrationLock.Unlock()
+
+	return nil
+}
+
+// setCalibration - Stores the volume per cycle measured from the last test pour
+func (mxr *MixerControl) setCalibration(caller Caller, channel int, measuredMl float64) error {
+
+	mxr.calibrationLock.Lock()
+	cycles := mxr.testCycles[channel]
+	mxr.calibrationLock.Unlock()
+	if cycles == 0 {
+		return fmt.Errorf("run StartCalibration on channel %d first", channel)
+	}
+
+	mlPerCycle := measuredMl / float64(cycles)
+	if mlPerCycle < minMlPerCycle || mlPerCycle > maxMlPerCycle {
+		return fmt.Errorf("%.1f ml over %d cycles is not plausible", measuredMl, cycles)
+	}
+
+	suffix := strconv.Itoa(channel)
+	err := mxr.ConfigService.SetValue(calibrationName, "mlPerCycle"+suffix, mlPerCycle)
+	if err == nil {
+		err = mxr.ConfigService.SetValue(calibrationName, "calibratedAt"+suffix, time.Now().UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		return err
+	}
+
+	mxr.calibrationLock.Lock()
+	mxr.testCycles[channel] = 0
+	mxr.calibrationLock.Unlock()
+	logger.Log("'%s' calibrated channel %d at %.2f ml per cycle", caller.Username, channel, mlPerCycle)
+
+	return nil
+}
+
+// cyclesFor - Motor cycles that come closest to 'ml' on a channel, at least one
+func cyclesFor(ml float64, calibration ChannelCalibration) int {
+	cycles := int(math.Round(ml / calibration.MlPerCycle))
+	if cycles < 1 {
+		cycles = 1
+	}
+	return cycles
+}
@@ -0,0 +1,160 @@
+package components
+
+import (
+	"fmt"
+	"tech/app/events"
+	"tech/app/fsm"
+	"tech/app/gpio"
+	"tech/app/logger"
+	"time"
+)
+
+const (
+	// EmergencyStopEvent - Published when the pumps are stopped by EmergencyStop or the button
+	EmergencyStopEvent = "emergencyStop"
+
+	// EmergencyResetEvent - Published when an operator clears a latched emergency stop
+	EmergencyResetEvent = "emergencyStopReset"
+
+	// buttonPoll - The button is read at least this often in case an edge is missed
+	buttonPoll = time.Second
+)
+
+// EmergencyStopState - Who latched the stop and why. ButtonPressed is only reported when a
+// button is wired
+type EmergencyStopState struct {
+	Latched       bool   `json:"latched"`
+	By            string `json:"by"`
+	Reason        string `json:"reason"`
+	At            string `json:"at"`
+	ButtonPressed bool   `json:"buttonPressed"`
+}
+
+// errEmergencyStop - Returned by pours refused or cut short by a latched stop
+var errEmergencyStop = fmt.Errorf("emergency stop is latched, an operator must reset it")
+
+// EmergencyStop - Moves the mixer to stopped, which halts the actuator with every output off
+// and fails the orders waiting in the queue. The stop stays latched until reset
+func (mxr *MixerControl) EmergencyStop(by string, reason string) {
+
+	mxr.estopLock.Lock()
+	if !mxr.EmergencyStopped() {
+		mxr.estop.By = by
+		mxr.estop.Reason = reason
+		mxr.estop.At = time.Now().UTC().Format(time.RFC3339)
+	}
+	mxr.estopLock.Unlock()
+
+	err := mxr.state.Transition(MixerStopped, reason)
+	if err != nil {
+		// Already stopped, make sure nothing has started since
+		err = mxr.actuator.Stop()
+		if err != nil {
+			logger.Log("Failed to stop the %s actuator, %v", mxr.actuator.Name(), err)
+		}
+	}
+}
+
+// enterStopped - Entry action of the stopped state
+func (mxr *MixerControl) enterStopped(record fsm.Record) {
+
+	err := mxr.actuator.Stop()
+	if err != nil {
+		logger.Log("Failed to stop the %s actuator, %v", mxr.actuator.Name(), err)
+	}
+	if mxr.orders != nil {
+		mxr.orders.failQueued("emergency stop")
+	}
+
+	stop := mxr.emergencyStopState()
+	logger.Log("EMERGENCY STOP by '%s' while %s: %s", stop.By, record.From, record.Reason)
+	data := map[string]interface{}{
+		"by":       stop.By,
+		"reason":   record.Reason,
+		"state":    record.From,
+		"actuator": mxr.actuator.Name()}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	events.Publish(EmergencyStopEvent, mxr.Name, data)
+}
+
+// guardReset - Keeps the stop latched while the button is held in
+func (mxr *MixerControl) guardReset(record fsm.Record) error {
+	if mxr.emergencyStopState().ButtonPressed {
+		return fmt.Errorf("release the emergency stop button first")
+	}
+	return nil
+}
+
+// EmergencyStopped - Whether a stop is latched
+func (mxr *MixerControl) EmergencyStopped() bool {
+	return mxr.state.Is(MixerStopped)
+}
+
+func (mxr *MixerControl) emergencyStopState() EmergencyStopState {
+	mxr.estopLock.Lock()
+	defer mxr.estopLock.Unlock()
+
+	stop := mxr.estop
+	stop.Latched = mxr.EmergencyStopped()
+	return stop
+}
+
+// resetEmergencyStop - Clears a latched stop, operators and admins only
+func (mxr *MixerControl) resetEmergencyStop(caller Caller) error {
+
+	err := caller.RequireOperator("ResetEmergencyStop")
+	if err != nil {
+		return err
+	}
+	if !mxr.EmergencyStopped() {
+		return fmt.Errorf("no emergency stop is latched")
+	}
+
+	err = mxr.state.Transition(MixerIdle, fmt.Sprintf("reset by '%s'", caller.Username))
+	if err != nil {
+		return err
+	}
+
+	mxr.estopLock.Lock()
+	mxr.estop = EmergencyStopState{ButtonPressed: mxr.estop.ButtonPressed}
+	mxr.estopLock.Unlock()
+
+	// The actuator latched the stop too and refuses to move until it is cleared here
+	return mxr.actuator.Reset()
+}
+
+// WatchEmergencyStopButton - Latches an emergency stop whenever 'button' is pressed. A button
+// that pulls the input low when pressed is 'activeLow'
+func (mxr *MixerControl) WatchEmergencyStopButton(button gpio.Input, activeLow bool) error {
+
+	err := button.SetEdge(gpio.EdgeBoth)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			level, err := button.Read()
+			if err != nil {
+				logger.Log("Failed to read the emergency stop button, %v", err)
+			} else {
+				pressed := level != activeLow
+				mxr.estopLock.Lock()
+				mxr.estop.ButtonPressed = pressed
+				mxr.estopLock.Unlock()
+				if pressed {
+					mxr.EmergencyStop("button", "emergency stop button pressed")
+				}
+			}
+
+			_, err = button.WaitEdge(buttonPoll)
+			if err != nil {
+				time.Sleep(buttonPoll)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package components
+
+import (
+	"encoding/json"
+	"tech/app/events"
+	"tech/app/logger"
+	"tech/mixer/config"
+)
+
+const (
+	eventsName = "events"
+)
+
+// Events - Exposes recently published device events over IPC
+type Events struct {
+	MixerComponent
+}
+
+// NewEvents -
+func NewEvents(cfg *config.CfgService) *Events {
+
+	evt := &Events{}
+	evt.Name = eventsName
+	evt.ConfigService = cfg
+
+	return evt
+}
+
+// Action -
+func (evt *Events) Action(action string, data []byte) (response []byte, err error) {
+	return evt.CallerAction(Caller{}, action, data)
+}
+
+// CallerAction - Events name users and addresses, only admins may read them
+func (evt *Events) CallerAction(caller Caller, action string, data []byte) (response []byte, err error) {
+
+	err = caller.RequireAdmin(action)
+	if err != nil {
+		return nil, err
+	}
+
+	switch action {
+	case "GetRecent":
+		response, err = json.MarshalIndent(events.Recent(), "", "\t")
+
+	default:
+		logger.Log("Unrecognized action received in '%s': '%s'", evt.Name, action)
+	}
+
+	return
+}
+
+// Start -
+func (evt *Events) Start() error {
+	return nil
+}
+
+// Stop -
+func (evt *Events) Stop() error {
+	return nil
+}
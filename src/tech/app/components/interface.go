@@ -11,8 +11,68 @@ type MixerComponentIf interface {
 	Stop() error
 }
 
+// HealthCheckerIf - Optional interface for components that can report whether they are able
+// to do their job. A nil error means healthy
+type HealthCheckerIf interface {
+	Health() error
+}
+
+// CallerActionIf - Optional interface for components that need to know who issued an action
+type CallerActionIf interface {
+	CallerAction(caller Caller, action string, data []byte) (response []byte, err error)
+}
+
+// User roles, admins manage the device and its users, operators run and refill it
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleUser     = "user"
+)
+
+// Roles - Every valid role
+var Roles = []string{RoleAdmin, RoleOperator, RoleUser}
+
+// Caller - Identifies who issued an action and where it came from. Username is empty for
+// requests without a valid session
+type Caller struct {
+	Username string
+	Role     string
+	Scope    string
+	IsAdmin  bool
+	Session  string
+	Source   string
+	Peer     string
+}
+
+// RequireAdmin - Returns a PermissionError unless the caller is an admin
+func (caller Caller) RequireAdmin(action string) error {
+	if !caller.IsAdmin {
+		return PermissionError{Action: action, Reason: "requires an admin session"}
+	}
+	return nil
+}
+
+// RequireOperator - Returns a PermissionError unless the caller is an operator or admin
+// with a full session
+func (caller Caller) RequireOperator(action string) error {
+	if caller.Scope != "" || (caller.Role != RoleOperator && caller.Role != RoleAdmin) {
+		return PermissionError{Action: action, Reason: "requires an operator session"}
+	}
+	return nil
+}
+
 // MixerComponent - A Component of the Mixer device
 type MixerComponent struct {
 	Name          string
 	ConfigService *config.CfgService
 }
+
+// PermissionError - Returned when the caller is not allowed to perform an action
+type PermissionError struct {
+	Action string
+	Reason string
+}
+
+func (e PermissionError) Error() string {
+	return "'" + e.Action + "' " + e.Reason
+}
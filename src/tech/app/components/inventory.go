@@ -0,0 +1,218 @@
+package components
+
+import (
+	"fmt"
+	"strconv"
+	"tech/app/events"
+	"tech/app/logger"
+	"tech/mixer/config"
+)
+
+const (
+	inventoryName = "inventory"
+
+	// LowStockEvent - Published when a channel drops below lowStockFraction of its bottle
+	LowStockEvent = "lowStock"
+
+	// EmptyEvent - Published when a channel has too little left to pour
+	EmptyEvent = "channelEmpty"
+
+	lowStockFraction = 0.15
+	emptyMl          = 5.0
+
+	// MaxBottleMl - Largest bottle a channel takes, a 1.75 l handle
+	MaxBottleMl = 1750.0
+)
+
+// ChannelLevel - Stock of one channel. Channels with no bottle size recorded are not tracked
+// and never refuse a pour
+type ChannelLevel struct {
+	Channel     int     `json:"channel"`
+	Ingredient  string  `json:"ingredient"`
+	Tracked     bool    `json:"tracked"`
+	BottleMl    float64 `json:"bottleMl"`
+	RemainingMl float64 `json:"remainingMl"`
+	Low         bool    `json:"low"`
+	Empty       bool    `json:"empty"`
+}
+
+// canPour - Reports whether 'ml' can be poured without running the channel dry
+func (level ChannelLevel) canPour(ml float64) bool {
+	return !level.Tracked || ml <= level.RemainingMl
+}
+
+func (mxr *MixerControl) createInventoryTable(cfg *config.CfgService) (err error) {
+	inventorySchema := []string{}
+	inventoryDefaults := map[string]string{}
+	for channel := 0; channel < ChannelCount; channel++ {
+		suffix := strconv.Itoa(channel)
+		inventorySchema = append(inventorySchema, "bottleMl"+suffix+" REAL", "remainingMl"+suffix+" REAL")
+		inventoryDefaults["bottleMl"+suffix] = "0"
+		inventoryDefaults["remainingMl"+suffix] = "0"
+	}
+
+	err = cfg.CreateTable(inventoryName, inventorySchema)
+	if err != nil {
+		return
+	}
+
+	return cfg.InitTable(inventoryName, inventoryDefaults)
+}
+
+// Levels - Stock of every channel
+func (mxr *MixerControl) Levels() ([]ChannelLevel, error) {
+
+	channels, err := mxr.Channels()
+	if err != nil {
+		return nil, err
+	}
+	value, err := mxr.ConfigService.Get(inventoryName)
+	if err != nil {
+		return nil, err
+	}
+	row, err := config.JsonToMap(value)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := make([]ChannelLevel, ChannelCount)
+	for channel := range levels {
+		suffix := strconv.Itoa(channel)
+		level := &levels[channel]
+		level.Channel = channel
+		level.Ingredient = channels[channel]
+		level.BottleMl, _ = config.JSONfloat64(row["bottleMl"+suffix])
+		level.RemainingMl, _ = config.JSONfloat64(row["remainingMl"+suffix])
+		level.Tracked = level.BottleMl > 0
+		if level.Tracked {
+			level.Empty = level.RemainingMl < emptyMl
+			level.Low = level.RemainingMl < level.BottleMl*lowStockFraction
+		}
+	}
+	return levels, nil
+}
+
+// loadBottles - Applies the bottle sizes in SetDrinkOptions data. A channel given a bottle is
+// full, unless 'remainingMlN' says otherwise. A tracked channel's ingredient only changes
+// with a bottle size, 0 to stop tracking it, so a rename cannot lift the stock check
+func (mxr *MixerControl) loadBottles(data map[string]interface{}) error {
+
+	mxr.inventoryLock.Lock()
+	defer mxr.inventoryLock.Unlock()
+
+	channels, err := mxr.Channels()
+	if err != nil {
+		return err
+	}
+
+	for channel := 0; channel < ChannelCount; channel++ {
+		suffix := strconv.Itoa(channel)
+		bottle, hasBottle := data["bottleMl"+suffix]
+		remaining, hasRemaining := data["remainingMl"+suffix]
+		drink, hasDrink := data["drink"+suffix].(string)
+		changed := hasDrink && drink != channels[channel]
+
+		if !hasBottle && !hasRemaining && !changed {
+			continue
+		}
+
+		current, _ := mxr.ConfigService.GetValue(inventoryName, "bottleMl"+suffix)
+		currentMl, _ := config.JSONfloat64(current)
+		if changed && !hasBottle && currentMl > 0 {
+			return fmt.Errorf("'bottleMl%d' is required to change the ingredient of a tracked channel", channel)
+		}
+
+		bottleMl, remainingMl := 0.0, 0.0
+		if hasBottle {
+			bottleMl, err = config.JSONfloat64(bottle)
+			if err != nil || bottleMl < 0 || bottleMl > MaxBottleMl {
+				return fmt.Errorf("'bottleMl%d' must be 0 to %.0f", channel, MaxBottleMl)
+			}
+			remainingMl = bottleMl
+		} else {
+			bottleMl = currentMl
+		}
+		if hasRemaining {
+			remainingMl, err = config.JSONfloat64(remaining)
+			if err != nil || remainingMl < 0 || remainingMl > bottleMl {
+				return fmt.Errorf("'remainingMl%d' must be 0 to the bottle size", channel)
+			}
+		}
+
+		err = mxr.ConfigService.SetValue(inventoryName, "bottleMl"+suffix, bottleMl)
+		if err == nil {
+			err = mxr.ConfigService.SetValue(inventoryName, "remainingMl"+suffix, remainingMl)
+		}
+		if err != nil {
+			return err
+		}
+		if bottleMl > 0 {
+			logger.Log("Channel %d loaded with %.0f ml of %.0f ml", channel, remainingMl, bottleMl)
+		}
+	}
+
+	return nil
+}
+
+// checkStock - Refuses a pour that would run a tracked channel dry
+func (mxr *MixerControl) checkStock(amounts []float64) error {
+
+	levels, err := mxr.Levels()
+	if err != nil {
+		return err
+	}
+	for channel, ml := range amounts {
+		if ml > 0 && !levels[channel].canPour(ml) {
+			return fmt.Errorf("not enough %s left, %.0f ml of %.0f ml", ingredientLabel(levels[channel]), levels[channel].RemainingMl, ml)
+		}
+	}
+	return nil
+}
+
+func ingredientLabel(level ChannelLevel) string {
+	if level.Ingredient == "" {
+		return fmt.Sprintf("on channel %d", level.Channel)
+	}
+	return level.Ingredient
+}
+
+// consume - Takes 'ml' off a tracked channel, publishing an event when it becomes low or empty
+func (mxr *MixerControl) consume(channel int, ml float64) {
+
+	mxr.inventoryLock.Lock()
+	defer mxr.inventoryLock.Unlock()
+
+	levels, err := mxr.Levels()
+	if err != nil || !levels[channel].Tracked {
+		return
+	}
+	before := levels[channel]
+
+	remaining := before.RemainingMl - ml
+	if remaining < 0 {
+		remaining = 0
+	}
+	err = mxr.ConfigService.SetValue(inventoryName, "remainingMl"+strconv.Itoa(channel), remaining)
+	if err != nil {
+		logger.Log("Failed to update the stock of channel %d, %v", channel, err)
+		return
+	}
+
+	after := before
+	after.RemainingMl = remaining
+	after.Empty = remaining < emptyMl
+	after.Low = remaining < after.BottleMl*lowStockFraction
+
+	data := map[string]interface{}{
+		"channel":     channel,
+		"ingredient":  after.Ingredient,
+		"remainingMl": remaining,
+		"bottleMl":    after.BottleMl}
+	if after.Empty && !before.Empty {
+		logger.Log("Channel %d (%s) is empty", channel, after.Ingredient)
+		events.Publish(EmptyEvent, mxr.Name, data)
+	} else if after.Low && !before.Low {
+		logger.Log("Channel %d (%s) is low, %.0f ml left", channel, after.Ingredient, remaining)
+		events.Publish(LowStockEvent, mxr.Name, data)
+	}
+}
@@ -0,0 +1,50 @@
+package components
+
+import (
+	"tech/app/logger"
+	"tech/app/metrics"
+	"tech/mixer/config"
+)
+
+const (
+	metricsName = "metrics"
+)
+
+// Metrics - Exposes the Host's metrics over IPC so the Server can aggregate them
+type Metrics struct {
+	MixerComponent
+}
+
+// NewMetrics -
+func NewMetrics(cfg *config.CfgService) *Metrics {
+
+	met := &Metrics{}
+	met.Name = metricsName
+	met.ConfigService = cfg
+
+	return met
+}
+
+// Action -
+func (met *Metrics) Action(action string, data []byte) (response []byte, err error) {
+
+	switch action {
+	case "Get":
+		response = metrics.Text()
+
+	default:
+		logger.Log("Unrecognized action received in '%s': '%s'", met.Name, action)
+	}
+
+	return
+}
+
+// Start -
+func (met *Metrics) Start() error {
+	return nil
+}
+
+// Stop -
+func (met *Metrics) Stop() error {
+	return nil
+}
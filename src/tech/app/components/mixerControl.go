@@ -2,24 +2,60 @@ package components
 
 import (
 	"encoding/json"
-	"os/exec"
+	"fmt"
 	"strconv"
+	"strings"
+	"sync"
+	"tech/app/fsm"
 	"tech/app/logger"
+	"tech/app/metrics"
+	"tech/app/nfc"
+	"tech/mixer/actuator"
 	"tech/mixer/config"
+	"tech/mixer/sensor"
+	"time"
 )
 
 const (
 	mixerControlName = "mixerControl"
+
+	// ChannelCount - Ingredient channels, drink0 to drink5
+	ChannelCount = 6
+)
+
+var (
+	pourCount    = metrics.NewCounter("pours_total", "Pours started per channel.", "channel")
+	pourErrors   = metrics.NewCounter("pour_errors_total", "Pours that failed per channel.", "channel")
+	pourDuration = metrics.NewHistogram("pour_duration_seconds", "Time taken by each pour per channel.", metrics.PourBuckets, "channel")
 )
 
 // MixerControl -
 type MixerControl struct {
 	MixerComponent
 
-	NfcMode         bool
-	UserStatusCode  int
-	MixerStatusCode int
-	NfcStatusCode   int
+	NfcMode bool
+
+	// idReader and payReader - Native card readers, the NFC script is used while unset
+	idReader  nfc.Reader
+	payReader nfc.Reader
+
+	// state - The pumps, stopped while an emergency stop is latched. nfcState - The reader
+	state    *fsm.Machine
+	nfcState *fsm.Machine
+
+	calibrationLock sync.Mutex
+	testCycles      [ChannelCount]int
+
+	inventoryLock sync.Mutex
+
+	// hardwareLock - Held while the motors run, the order worker and test pours take turns
+	hardwareLock sync.Mutex
+	actuator     actuator.Actuator
+	sensor       sensor.Sensor
+	orders       *Orders
+
+	estopLock sync.Mutex
+	estop     EmergencyStopState
 }
 
 // NewMixerControl -
@@ -27,17 +63,27 @@ func NewMixerControl(cfg *config.CfgService) *MixerControl {
 
 	mxr := &MixerControl{}
 	mxr.Name = mixerControlName
-	mxr.UserStatusCode = 0
-	mxr.MixerStatusCode = 0
 	mxr.ConfigService = cfg
+	mxr.state = mxr.newMixerState()
+	mxr.nfcState = newNfcState()
+	mxr.actuator = actuator.NewScript(actuator.DefaultScript)
 
 	cfg.Register(mxr.Name, mxr.createTable)
+	cfg.Register(calibrationName, mxr.createCalibrationTable)
+	cfg.Register(inventoryName, mxr.createInventoryTable)
+	cfg.Register(pourLogName, mxr.createPourLogTable)
 
 	return mxr
 }
 
 // Action -
 func (mxr *MixerControl) Action(action string, data []byte) (response []byte, err error) {
+	return mxr.CallerAction(Caller{}, action, data)
+}
+
+// CallerAction - Calibration, the pour log, ingredients, bottle stock and resetting an
+// emergency stop need an operator, everything else is open to the kiosk
+func (mxr *MixerControl) CallerAction(caller Caller, action string, data []byte) (response []byte, err error) {
 
 	var mapData map[string]interface{}
 	mapData, err = config.JsonToMap(data)
@@ -51,16 +97,36 @@ func (mxr *MixerControl) Action(action string, data []byte) (response []byte, er
 		response, err = mxr.getDrinkOptions()
 
 	case "SetDrinkOptions":
-		response, err = mxr.setDrinkOptions(data)
+		response, err = mxr.setDrinkOptions(caller, mapData)
 
 	case "InitMixing":
-		response, err = mxr.initMixing(mapData)
+		response, err = mxr.initMixing(caller, mapData)
 
 	case "GetStatus":
 		response, err = mxr.getStatus()
 
 	case "ReadNfc":
-		response, err = mxr.readNFC()
+		response, err = mxr.readNFC(mapData)
+
+	case "EmergencyStop":
+		by := caller.Username
+		if by == "" {
+			by = "kiosk"
+		}
+		mxr.EmergencyStop(by, "EmergencyStop requested")
+		response, err = mxr.getStatus()
+
+	case "ResetEmergencyStop":
+		err = mxr.resetEmergencyStop(caller)
+		if err == nil {
+			response, err = mxr.getStatus()
+		}
+
+	case "GetPourLog":
+		response, err = mxr.pourLog(caller, mapData)
+
+	case "GetCalibration", "StartCalibration", "SetCalibration":
+		response, err = mxr.calibrationAction(caller, action, mapData)
 
 	default:
 		logger.Log("Unrecognized action received in '%s': '%s'", mxr.Name, action)
@@ -74,9 +140,14 @@ func (mxr *MixerControl) Start() error {
 	return nil
 }
 
-// Stop -
+// Stop - Halts any pour under way
 func (mxr *MixerControl) Stop() error {
-	return nil
+	return mxr.actuator.Stop()
+}
+
+// SetActuator - Replaces the backend driving the pumps, the motor script by default
+func (mxr *MixerControl) SetActuator(act actuator.Actuator) {
+	mxr.actuator = act
 }
 
 // createTable -
@@ -112,104 +183,239 @@ func (mxr *MixerControl) createTable(cfg *config.CfgService) (err error) {
 	return nil
 }
 
+// getDrinkOptions - The ingredient on each channel and the mix setting, with the stock
+// level of each channel under "levels"
 func (mxr *MixerControl) getDrinkOptions() ([]byte, error) {
 
 	drinks, err := mxr.ConfigService.Get(mxr.Name)
 	if err != nil {
 		return nil, err
 	}
+	options, err := config.JsonToMap(drinks)
+	if err != nil {
+		return nil, err
+	}
+	options["levels"], err = mxr.Levels()
+	if err != nil {
+		return nil, err
+	}
 
-	return json.MarshalIndent(drinks, "", "\t")
+	return json.MarshalIndent(options, "", "\t")
 }
 
-func (mxr *MixerControl) setDrinkOptions(data []byte) ([]byte, error) {
+// setDrinkOptions - Sets the ingredient names 'drink0' to 'drink5' and 'mix', and records
+// bottles loaded with 'bottleMl0' to 'bottleMl5'. Only operators may change ingredients or
+// stock
+func (mxr *MixerControl) setDrinkOptions(caller Caller, data map[string]interface{}) ([]byte, error) {
+
+	for channel := 0; channel < ChannelCount; channel++ {
+		_, hasDrink := data[fmt.Sprintf("drink%d", channel)]
+		_, hasBottle := data[fmt.Sprintf("bottleMl%d", channel)]
+		_, hasRemaining := data[fmt.Sprintf("remainingMl%d", channel)]
+		if hasDrink || hasBottle || hasRemaining {
+			err := caller.RequireOperator("SetDrinkOptions")
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	drinks := make(map[string]interface{})
+	for channel := 0; channel < ChannelCount; channel++ {
+		key := fmt.Sprintf("drink%d", channel)
+		if value, ok := data[key]; ok {
+			name, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("'%s' must be a string", key)
+			}
+			drinks[key] = strings.TrimSpace(name)
+			data[key] = drinks[key]
+		}
+	}
+	if mix, ok := data["mix"].(bool); ok {
+		drinks["mix"] = mix
+	}
 
-	drinks, err := mxr.ConfigService.Set(mxr.Name, data)
+	err := mxr.loadBottles(data)
 	if err != nil {
 		return nil, err
 	}
 
-	return drinks, err
+	if len(drinks) > 0 {
+		encoded, err := json.Marshal(drinks)
+		if err != nil {
+			return nil, err
+		}
+		_, err = mxr.ConfigService.Set(mxr.Name, encoded)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return mxr.getDrinkOptions()
 }
 
 func (mxr *MixerControl) getStatus() ([]byte, error) {
-	controlDefault := map[string]interface{}{
-		"userStatus":  mxr.UserStatusCode,
-		"mixerStatus": mxr.MixerStatusCode,
-		"nfcStatus":   mxr.NfcStatusCode}
+	return json.MarshalIndent(mxr.status(), "", "\t")
+}
+
+// initMixing - Queues a pour of 'pourMl0' to 'pourMl5' ml from each channel and waits for it
+func (mxr *MixerControl) initMixing(caller Caller, data map[string]interface{}) ([]byte, error) {
+
+	amounts := make([]float64, ChannelCount)
+	for channel := range amounts {
+		value, ok := data[fmt.Sprintf("pourMl%d", channel)]
+		if !ok {
+			continue
+		}
+		ml, err := config.JSONfloat64(value)
+		if err != nil {
+			return nil, fmt.Errorf("'pourMl%d' must be a number of ml", channel)
+		}
+		amounts[channel] = ml
+	}
+	mix, _ := config.JSONbool(data["mix"])
 
-	return json.MarshalIndent(controlDefault, "", "\t")
+	if mxr.orders == nil {
+		return nil, mxr.PourML(amounts, mix)
+	}
+	return nil, mxr.orders.pourAndWait(caller, "", amounts, mix)
 }
 
-func (mxr *MixerControl) readNFC() ([]byte, error) {
-	mxr.UserStatusCode = 3
-	mxr.NfcStatusCode = 1
-	networkData, err := mxr.ConfigService.Get("factory")
+// Channels - Names of the ingredients loaded on each channel, "" for an empty channel
+func (mxr *MixerControl) Channels() ([]string, error) {
+
+	value, err := mxr.ConfigService.Get(mxr.Name)
 	if err != nil {
-		mxr.NfcMode = false
-	} else {
-		networkMap, _ := config.JsonToMap(networkData)
-		mxr.NfcMode, _ = config.JSONbool(networkMap["nfcMode"])
+		return nil, err
 	}
-
-	out, err := exec.Command("python3", "./scripts/read_nfc.py", strconv.FormatBool(mxr.NfcMode)).Output()
-	mxr.NfcStatusCode = 2
+	row, err := config.JsonToMap(value)
 	if err != nil {
-		logger.Log("nfc read error error: %v", err)
+		return nil, err
 	}
-	logger.LogDebug(string(out))
 
-	mxr.NfcStatusCode = 0
-	mxr.UserStatusCode = 0
-	return networkData, nil
+	channels := make([]string, ChannelCount)
+	for i := range channels {
+		channels[i], _ = config.JSONstring(row[fmt.Sprintf("drink%d", i)])
+	}
+	return channels, nil
 }
 
-func (mxr *MixerControl) initMixing(data map[string]interface{}) ([]byte, error) {
-
-	mxr.MixerStatusCode = 1
-	mxr.UserStatusCode = 1
-	pourAmt0 := int(data["pourAmt0"].(float64))
-	pourAmt1 := int(data["pourAmt1"].(float64))
-	pourAmt2 := int(data["pourAmt2"].(float64))
-	pourAmt3 := int(data["pourAmt3"].(float64))
-	pourAmt4 := int(data["pourAmt4"].(float64))
-	pourAmt5 := int(data["pourAmt5"].(float64))
-	mix, _ := config.JSONbool(data["mix"])
+// PourML - Pours 'amounts' ml on each channel in turn, measured by the sensor where there is
+// one and otherwise converted to motor cycles with the channel's calibration, then mixes if
+// asked to
+func (mxr *MixerControl) PourML(amounts []float64, mix bool) error {
 
-	if pourAmt0 != 0 {
-		mxr.motorScriptCall("0", strconv.Itoa(pourAmt0))
+	err := checkAmounts(amounts)
+	if err != nil {
+		return err
 	}
-	if pourAmt1 != 0 {
-		mxr.motorScriptCall("1", strconv.Itoa(pourAmt1))
+	calibration, err := mxr.Calibration()
+	if err != nil {
+		return err
 	}
-	if pourAmt2 != 0 {
-		mxr.motorScriptCall("2", strconv.Itoa(pourAmt2))
+	err = mxr.checkStock(amounts)
+	if err != nil {
+		return err
 	}
-	if pourAmt3 != 0 {
-		mxr.motorScriptCall("3", strconv.Itoa(pourAmt3))
+
+	mxr.hardwareLock.Lock()
+	defer mxr.hardwareLock.Unlock()
+	err = mxr.begin(MixerPouring, "pour")
+	if err != nil {
+		return err
 	}
-	if pourAmt4 != 0 {
-		mxr.motorScriptCall("4", strconv.Itoa(pourAmt4))
+
+	// A failed channel does not stop the others, the pour fails once they are done
+	var failure error
+	for channel, ml := range amounts {
+		if ml == 0 {
+			continue
+		}
+		if mxr.EmergencyStopped() {
+			return errEmergencyStop
+		}
+		logger.LogDebug("Pouring %.1f ml on channel %d", ml, channel)
+		record := mxr.pourChannel(channel, ml, calibration[channel])
+		stopped := mxr.EmergencyStopped()
+		// The measured volume, when there is one, is what left the bottle even if the pour
+		// failed or was stopped part way. Without one a stopped pour counts as the estimate,
+		// the most that can have left
+		if record.MeasuredMl != nil {
+			mxr.consume(channel, *record.MeasuredMl)
+		} else if record.Error == "" || stopped {
+			mxr.consume(channel, record.EstimatedMl)
+		}
+		if stopped {
+			return errEmergencyStop
+		}
+		if record.Error != "" {
+			failure = fmt.Errorf("pour failed on channel %d", channel)
+		}
 	}
-	if pourAmt5 != 0 {
-		mxr.motorScriptCall("5", strconv.Itoa(pourAmt5))
+	if mix && failure == nil {
+		err = mxr.state.Transition(MixerMixing, "mix")
+		if err == nil {
+			err = mxr.motorCall(actuator.MixChannel, 0)
+		}
+		if err != nil && !mxr.EmergencyStopped() {
+			failure = fmt.Errorf("mix failed")
+		}
 	}
-	if !mix {
-		mxr.motorScriptCall("mix", "0")
+
+	return mxr.settle(failure)
+}
+
+// checkAmounts - Checks a pour has at most one amount per channel, each within MaxPourMl
+func checkAmounts(amounts []float64) error {
+	if len(amounts) > ChannelCount {
+		return fmt.Errorf("only %d channels", ChannelCount)
 	}
-	if mxr.MixerStatusCode == 1 {
-		mxr.MixerStatusCode = 0
-		mxr.MixerStatusCode = 0
+	for channel, ml := range amounts {
+		if ml < 0 || ml > MaxPourMl {
+			return fmt.Errorf("channel %d must pour 0 to %.0f ml", channel, MaxPourMl)
+		}
 	}
-	return nil, nil
+	return nil
 }
 
-func (mxr *MixerControl) motorScriptCall(target string, amount string) {
-	out, err := exec.Command("python3", "./scripts/motor_control.py", target, amount).Output()
-	if err != nil {
+// motorCall - Runs 'cycles' pump cycles on 'channel', or the mixer for actuator.MixChannel
+func (mxr *MixerControl) motorCall(channel int, cycles int) error {
+	return mxr.timed(channel, func() error {
+		if channel == actuator.MixChannel {
+			return mxr.actuator.Mix()
+		}
+		return mxr.actuator.Pour(channel, cycles)
+	})
+}
+
+// timed - Runs 'run' as one pour on 'channel' in the pour metrics
+func (mxr *MixerControl) timed(channel int, run func() error) error {
+	target := strconv.Itoa(channel)
+	if channel == actuator.MixChannel {
+		target = "mix"
+	}
+
+	pourCount.Inc(target)
+	start := time.Now()
+	err := run()
+	pourDuration.Observe(time.Since(start).Seconds(), target)
+	if err != nil && !mxr.EmergencyStopped() {
+		pourErrors.Inc(target)
 		logger.Log("motor control error: %v", err)
-		mxr.MixerStatusCode = 2
-		mxr.UserStatusCode = 2
 	}
-	logger.LogDebug(string(out))
+	return err
+}
+
+// Health - Reports unhealthy if an emergency stop is latched or the actuator has a fault. A
+// failed pour leaves the mixer in fault, but it can still pour, so that alone is not reported
+func (mxr *MixerControl) Health() error {
+	if mxr.EmergencyStopped() {
+		return errEmergencyStop
+	}
+	if fault := mxr.actuator.Status().Fault; fault != "" {
+		return fmt.Errorf("%s actuator: %s", mxr.actuator.Name(), fault)
+	}
+	return nil
 }
@@ -0,0 +1,117 @@
+package components
+
+import (
+	"fmt"
+	"tech/app/events"
+	"tech/app/fsm"
+	"tech/app/logger"
+	"tech/mixer/actuator"
+)
+
+// Mixer states
+const (
+	MixerIdle        = "idle"
+	MixerPouring     = "pouring"
+	MixerMixing      = "mixing"
+	MixerCalibrating = "calibrating"
+	MixerFault       = "fault"
+	MixerStopped     = "stopped"
+)
+
+// NFC reader states
+const (
+	NfcIdle    = "idle"
+	NfcReading = "reading"
+	NfcError   = "error"
+)
+
+// stateHistory - Transitions kept by each machine for GetStatus
+const stateHistory = 32
+
+// MixerStatus - Body of GetStatus. The mixer is stopped while an emergency stop is latched
+// and in fault after a failed pour until the next one succeeds
+type MixerStatus struct {
+	Mixer         fsm.Snapshot       `json:"mixer"`
+	Nfc           fsm.Snapshot       `json:"nfc"`
+	Actuator      actuator.Status    `json:"actuator"`
+	EmergencyStop EmergencyStopState `json:"emergencyStop"`
+}
+
+// newMixerState - The pumps' state machine. Pours start from idle or, to retry, fault; any
+// state but stopped can be stopped, and only a guarded reset leaves it
+func (mxr *MixerControl) newMixerState() *fsm.Machine {
+
+	machine := fsm.New("mixer", MixerIdle, stateHistory)
+	machine.AddState(MixerPouring, nil, nil)
+	machine.AddState(MixerMixing, nil, nil)
+	machine.AddState(MixerCalibrating, nil, nil)
+	machine.AddState(MixerFault, func(record fsm.Record) {
+		logger.Log("Mixer fault: %s", record.Reason)
+	}, nil)
+	machine.AddState(MixerStopped, mxr.enterStopped, func(record fsm.Record) {
+		logger.Log("Emergency stop reset, %s", record.Reason)
+		events.Publish(EmergencyResetEvent, mxr.Name, map[string]interface{}{"reason": record.Reason})
+	})
+
+	machine.Allow(MixerPouring, nil, MixerIdle, MixerFault)
+	machine.Allow(MixerCalibrating, nil, MixerIdle, MixerFault)
+	machine.Allow(MixerMixing, nil, MixerPouring)
+	machine.Allow(MixerIdle, nil, MixerPouring, MixerMixing, MixerCalibrating)
+	machine.Allow(MixerFault, nil, MixerPouring, MixerMixing, MixerCalibrating)
+	machine.Allow(MixerStopped, nil, MixerIdle, MixerPouring, MixerMixing, MixerCalibrating, MixerFault)
+	machine.Allow(MixerIdle, mxr.guardReset, MixerStopped)
+
+	return machine
+}
+
+// newNfcState - The card reader's state machine
+func newNfcState() *fsm.Machine {
+
+	machine := fsm.New("nfc", NfcIdle, stateHistory)
+	machine.AddState(NfcReading, nil, nil)
+	machine.AddState(NfcError, nil, nil)
+
+	machine.Allow(NfcReading, nil, NfcIdle, NfcError)
+	machine.Allow(NfcIdle, nil, NfcReading)
+	machine.Allow(NfcError, nil, NfcReading)
+
+	return machine
+}
+
+// status - The GetStatus body
+func (mxr *MixerControl) status() MixerStatus {
+	return MixerStatus{
+		Mixer:         mxr.state.Snapshot(),
+		Nfc:           mxr.nfcState.Snapshot(),
+		Actuator:      mxr.actuator.Status(),
+		EmergencyStop: mxr.emergencyStopState()}
+}
+
+// settle - Ends a pour, mix or test pour in idle, or in fault if it failed. A pour cut short
+// by an emergency stop stays stopped
+func (mxr *MixerControl) settle(failure error) error {
+
+	if mxr.EmergencyStopped() {
+		return errEmergencyStop
+	}
+	if failure == nil {
+		return mxr.state.Transition(MixerIdle, "done")
+	}
+	err := mxr.state.Transition(MixerFault, failure.Error())
+	if err != nil {
+		logger.Log("Failed to record the mixer fault, %v", err)
+	}
+	return failure
+}
+
+// begin - Enters 'state' to run the pumps, refusing while stopped or already running
+func (mxr *MixerControl) begin(state string, reason string) error {
+	err := mxr.state.Transition(state, reason)
+	if _, ok := err.(fsm.TransitionError); ok {
+		if mxr.EmergencyStopped() {
+			return errEmergencyStop
+		}
+		return fmt.Errorf("the mixer is busy %s", mxr.state.State())
+	}
+	return err
+}
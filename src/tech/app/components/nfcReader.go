@@ -0,0 +1,133 @@
+package components
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"tech/app/logger"
+	"tech/app/nfc"
+	"tech/mixer/config"
+	"time"
+)
+
+const (
+	nfcScript = "./scripts/read_nfc.py"
+
+	defaultNfcTimeout = 10
+	maxNfcTimeout     = 30
+
+	nfcPollInterval = 200 * time.Millisecond
+
+	// paymentBlock - Block holding the payment data on payment cards, read with the
+	// transport key
+	paymentBlock = 8
+)
+
+// SetNfcReaders - Reads cards natively, 'identify' in identification mode and 'payment' in
+// payment mode. A nil reader leaves that mode on the NFC script
+func (mxr *MixerControl) SetNfcReaders(identify nfc.Reader, payment nfc.Reader) {
+	mxr.idReader = identify
+	mxr.payReader = payment
+}
+
+// readNFC - Waits up to 'timeout' seconds for a card on the reader and returns its UID, which
+// userAuth can log in with using LoginByCard. Payment mode also returns the payment block
+func (mxr *MixerControl) readNFC(data map[string]interface{}) ([]byte, error) {
+
+	timeout := defaultNfcTimeout
+	if value, ok := data["timeout"].(float64); ok {
+		if value != math.Trunc(value) || value < 1 || value > maxNfcTimeout {
+			return nil, fmt.Errorf("'timeout' must be 1 to %d seconds", maxNfcTimeout)
+		}
+		timeout = int(value)
+	}
+
+	err := mxr.nfcState.Transition(NfcReading, "ReadNfc")
+	if err != nil {
+		return nil, fmt.Errorf("the card reader is busy")
+	}
+	networkData, err := mxr.ConfigService.Get("factory")
+	if err != nil {
+		mxr.NfcMode = false
+	} else {
+		networkMap, _ := config.JsonToMap(networkData)
+		mxr.NfcMode, _ = config.JSONbool(networkMap["nfcMode"])
+	}
+
+	reader := mxr.idReader
+	if mxr.NfcMode {
+		reader = mxr.payReader
+	}
+
+	response := map[string]interface{}{"nfcMode": mxr.NfcMode}
+	if reader != nil {
+		err = readCard(reader, time.Duration(timeout)*time.Second, mxr.NfcMode, response)
+	} else {
+		err = readCardScript(time.Duration(timeout)*time.Second, mxr.NfcMode, response)
+	}
+	if err != nil {
+		mxr.nfcState.Transition(NfcError, err.Error())
+		if err == nfc.ErrTimeout {
+			return nil, fmt.Errorf("no card read")
+		}
+		logger.Log("nfc read error: %v", err)
+		return nil, fmt.Errorf("card read failed")
+	}
+
+	mxr.nfcState.Transition(NfcIdle, "card read")
+	return json.MarshalIndent(response, "", "\t")
+}
+
+// readCard - Polls 'reader' for a card, reading the payment block in payment mode
+func readCard(reader nfc.Reader, timeout time.Duration, payment bool, response map[string]interface{}) error {
+
+	card, err := nfc.Poll(reader, timeout, nfcPollInterval)
+	if err != nil {
+		return err
+	}
+	response["uid"] = card.UIDString()
+	response["reader"] = reader.Name()
+	if !payment {
+		return nil
+	}
+
+	block, err := reader.ReadBlock(card, paymentBlock, nfc.KeyA, nfc.DefaultKey)
+	if err != nil {
+		return err
+	}
+	response["data"] = strings.ToUpper(hex.EncodeToString(block))
+	return nil
+}
+
+// readCardScript - Runs the NFC script, which prints the card's UID as hex on its last line,
+// or in payment mode the payment data, kept under the same keys as readCard uses
+func readCardScript(timeout time.Duration, payment bool, response map[string]interface{}) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "python3", nfcScript, strconv.FormatBool(payment)).Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nfc.ErrTimeout
+	}
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(strings.TrimSpace(string(out)))
+	if len(fields) == 0 {
+		return nfc.ErrTimeout
+	}
+	if payment {
+		response["data"] = fields[len(fields)-1]
+	} else {
+		response["uid"] = fields[len(fields)-1]
+	}
+	response["reader"] = "script"
+	return nil
+}
@@ -0,0 +1,64 @@
+package components
+
+import (
+	"strings"
+	"tech/app/nfc"
+	"testing"
+	"time"
+)
+
+func TestReadCard(t *testing.T) {
+
+	uid := []byte{0x04, 0xA1, 0xB2, 0xC3}
+	payment := []byte{0x01, 0x02, 0x03, 0x04}
+
+	tests := []struct {
+		name     string
+		present  bool
+		payment  bool
+		err      error
+		response map[string]interface{}
+	}{
+		{
+			name:     "identify",
+			present:  true,
+			response: map[string]interface{}{"uid": "04A1B2C3", "reader": "fake"},
+		},
+		{
+			name:    "payment",
+			present: true,
+			payment: true,
+			response: map[string]interface{}{
+				"uid":    "04A1B2C3",
+				"reader": "fake",
+				"data":   "01020304" + strings.Repeat("00", nfc.BlockSize-len(payment))},
+		},
+		{
+			name:     "no card",
+			err:      nfc.ErrTimeout,
+			response: map[string]interface{}{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := nfc.NewFake()
+			if test.present {
+				reader.Present(uid, map[byte][]byte{paymentBlock: payment})
+			}
+
+			response := map[string]interface{}{}
+			err := readCard(reader, 50*time.Millisecond, test.payment, response)
+			if err != test.err {
+				t.Fatalf("readCard returned %v, want %v", err, test.err)
+			}
+			if len(response) != len(test.response) {
+				t.Errorf("response is %v, want %v", response, test.response)
+			}
+			for key, want := range test.response {
+				if response[key] != want {
+					t.Errorf("response[%q] = %v, want %v", key, response[key], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,551 @@
+package components
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"tech/app/logger"
+	"tech/mixer/config"
+	"time"
+)
+
+const (
+	ordersName = "orders"
+
+	// Order states
+	OrderQueued    = "queued"
+	OrderPouring   = "pouring"
+	OrderDone      = "done"
+	OrderFailed    = "failed"
+	OrderCancelled = "cancelled"
+
+	maxQueuedOrders  = 20
+	maxOrdersPerUser = 3
+	orderColumns     = "configID, owner, recipe, amounts, mix, state, error, created, started, finished"
+)
+
+// Order - A drink waiting for, or poured by, the order worker. Position is the order's place
+// in the queue, 1 being the one at the machine, and 0 once it has left the queue
+type Order struct {
+	ID       int64     `json:"id"`
+	Owner    string    `json:"owner"`
+	Recipe   string    `json:"recipe"`
+	Amounts  []float64 `json:"amounts"`
+	Mix      bool      `json:"mix"`
+	State    string    `json:"state"`
+	Position int       `json:"position"`
+	Error    string    `json:"error"`
+	Created  string    `json:"created"`
+	Started  string    `json:"started"`
+	Finished string    `json:"finished"`
+
+	// source - Address the order came from, limits anonymous orders as owners are
+	source string
+
+	// done - Closed when the order leaves the queue
+	done chan struct{}
+}
+
+// Orders - First in, first out queue of pours. A single worker takes orders off the front,
+// so only one pour ever drives the motors
+type Orders struct {
+	MixerComponent
+
+	mixerControl *MixerControl
+	recipes      *Recipes
+
+	lock     sync.Mutex
+	queue    []*Order
+	wake     chan struct{}
+	quit     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewOrders - Creates the queue, InitMixing and OrderRecipe are routed through it from then on
+func NewOrders(cfg *config.CfgService, mixerControl *MixerControl, recipes *Recipes) *Orders {
+
+	ord := &Orders{
+		mixerControl: mixerControl,
+		recipes:      recipes,
+		wake:         make(chan struct{}, 1),
+		quit:         make(chan struct{})}
+	ord.Name = ordersName
+	ord.ConfigService = cfg
+
+	mixerControl.orders = ord
+	recipes.orders = ord
+
+	cfg.Register(ord.Name, ord.createTable)
+
+	return ord
+}
+
+// Action -
+func (ord *Orders) Action(action string, data []byte) (response []byte, err error) {
+	return ord.CallerAction(Caller{}, action, data)
+}
+
+// CallerAction - Users place orders and see or cancel their own, operators and admins see
+// and cancel anyone's
+func (ord *Orders) CallerAction(caller Caller, action string, data []byte) (response []byte, err error) {
+
+	mapData := make(map[string]interface{})
+	if len(data) > 0 {
+		mapData, err = config.JsonToMap(data)
+		if err != nil {
+			logger.Log("Failed to unmarshall data on '%s'", ord.Name)
+			return
+		}
+	}
+
+	switch action {
+	case "PlaceOrder":
+		response, err = ord.placeOrder(caller, mapData)
+
+	case "CancelOrder":
+		response, err = ord.cancelOrder(caller, mapData)
+
+	case "GetOrder":
+		response, err = ord.getOrder(caller, mapData)
+
+	case "ListQueue":
+		response, err = ord.listQueue(caller)
+
+	default:
+		logger.Log("Unrecognized action received in '%s': '%s'", ord.Name, action)
+	}
+
+	return
+}
+
+// Start - Fails whatever a previous run left in the queue, then starts the worker
+func (ord *Orders) Start() error {
+
+	_, err := ord.ConfigService.Exec("UPDATE "+ord.Name+" SET state = ?, error = ?, finished = ? WHERE state IN (?, ?)",
+		OrderFailed, "interrupted by a restart", timestamp(), OrderQueued, OrderPouring)
+	if err != nil {
+		return err
+	}
+
+	go ord.work()
+	return nil
+}
+
+// Stop - Stops the worker once the current pour, if any, has finished
+func (ord *Orders) Stop() error {
+	ord.stopOnce.Do(func() { close(ord.quit) })
+	return nil
+}
+
+func (ord *Orders) createTable(cfg *config.CfgService) (err error) {
+	orderSchema := []string{
+		"owner TEXT",
+		"recipe TEXT",
+		"amounts TEXT",
+		"mix INTEGER DEFAULT 0",
+		"state TEXT",
+		"error TEXT",
+		"created TEXT",
+		"started TEXT",
+		"finished TEXT"}
+
+	err = cfg.CreateTable(ord.Name, orderSchema)
+	if err != nil {
+		return
+	}
+	_, err = cfg.Exec("CREATE INDEX IF NOT EXISTS " + ord.Name + "_state ON " + ord.Name + " (state)")
+	return
+}
+
+func timestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// place - Checks the pour against the stock left once everything ahead of it has poured,
+// then adds it to the back of the queue. Callers without a session are limited by their
+// address instead of their username
+func (ord *Orders) place(caller Caller, recipe string, amounts []float64, mix bool) (*Order, error) {
+
+	err := checkAmounts(amounts)
+	if err != nil {
+		return nil, err
+	}
+	if ord.mixerControl.EmergencyStopped() {
+		return nil, errEmergencyStop
+	}
+	total := make([]float64, ChannelCount)
+	poured := 0.0
+	for channel, ml := range amounts {
+		total[channel] += ml
+		poured += ml
+	}
+	if poured == 0 {
+		return nil, fmt.Errorf("nothing to pour")
+	}
+
+	ord.lock.Lock()
+	defer ord.lock.Unlock()
+
+	if len(ord.queue) >= maxQueuedOrders {
+		return nil, fmt.Errorf("the queue is full, try again shortly")
+	}
+	owner := caller.Username
+	source := sourceHost(caller.Source)
+	owned := 0
+	for _, queued := range ord.queue {
+		if queued.Owner == owner && (owner != "" || queued.source == source) {
+			owned++
+		}
+		for channel, ml := range queued.Amounts {
+			total[channel] += ml
+		}
+	}
+	if owned >= maxOrdersPerUser {
+		return nil, fmt.Errorf("at most %d orders in the queue at once", maxOrdersPerUser)
+	}
+	err = ord.mixerControl.checkStock(total)
+	if err != nil {
+		return nil, err
+	}
+
+	order := &Order{
+		Owner:   owner,
+		Recipe:  recipe,
+		Amounts: amounts,
+		Mix:     mix,
+		State:   OrderQueued,
+		Created: timestamp(),
+		source:  source,
+		done:    make(chan struct{})}
+	encoded, err := json.Marshal(amounts)
+	if err != nil {
+		return nil, err
+	}
+	order.ID, err = ord.ConfigService.Exec("INSERT INTO "+ord.Name+" (owner, recipe, amounts, mix, state, error, created, started, finished) VALUES (?, ?, ?, ?, ?, '', ?, '', '')",
+		owner, recipe, string(encoded), boolInt(mix), order.State, order.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	ord.queue = append(ord.queue, order)
+	select {
+	case ord.wake <- struct{}{}:
+	default:
+	}
+
+	return order, nil
+}
+
+// sourceHost - The address of a caller's source without the port, which changes with
+// every connection
+func sourceHost(source string) string {
+	host, _, err := net.SplitHostPort(source)
+	if err != nil {
+		return source
+	}
+	return host
+}
+
+func boolInt(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// pourAndWait - Queues a pour and blocks until the worker has finished with it
+func (ord *Orders) pourAndWait(caller Caller, recipe string, amounts []float64, mix bool) error {
+
+	order, err := ord.place(caller, recipe, amounts, mix)
+	if err != nil {
+		return err
+	}
+	<-order.done
+
+	ord.lock.Lock()
+	defer ord.lock.Unlock()
+	switch order.State {
+	case OrderDone:
+		return nil
+	case OrderCancelled:
+		return fmt.Errorf("order %d was cancelled", order.ID)
+	}
+	return fmt.Errorf("%s", order.Error)
+}
+
+// work - Pours the order at the front of the queue, one at a time, until stopped
+func (ord *Orders) work() {
+	for {
+		order := ord.next()
+		if order == nil {
+			select {
+			case <-ord.wake:
+				continue
+			case <-ord.quit:
+				return
+			}
+		}
+
+		logger.Log("Pouring order %d for '%s'", order.ID, order.Owner)
+		err := ord.mixerControl.PourML(order.Amounts, order.Mix)
+		ord.finish(order, err)
+	}
+}
+
+// next - Marks the order at the front of the queue as pouring, nil when the queue is empty
+// or the worker is stopping
+func (ord *Orders) next() *Order {
+
+	select {
+	case <-ord.quit:
+		return nil
+	default:
+	}
+
+	ord.lock.Lock()
+	defer ord.lock.Unlock()
+
+	if len(ord.queue) == 0 {
+		return nil
+	}
+	order := ord.queue[0]
+	order.State = OrderPouring
+	order.Started = timestamp()
+	ord.update(order)
+	return order
+}
+
+// finish - Records the outcome of the pour and takes the order off the queue
+func (ord *Orders) finish(order *Order, err error) {
+
+	ord.lock.Lock()
+	defer ord.lock.Unlock()
+
+	order.State = OrderDone
+	if err != nil {
+		order.State = OrderFailed
+		order.Error = err.Error()
+		logger.Log("Order %d failed, %v", order.ID, err)
+	}
+	ord.remove(order)
+}
+
+// remove - Takes 'order' off the queue, stores its final state and wakes anyone waiting on
+// it. The lock must be held
+func (ord *Orders) remove(order *Order) {
+
+	for i, queued := range ord.queue {
+		if queued == order {
+			ord.queue = append(ord.queue[:i], ord.queue[i+1:]...)
+			break
+		}
+	}
+	order.Finished = timestamp()
+	order.Position = 0
+	ord.update(order)
+	close(order.done)
+}
+
+// failQueued - Fails every order still waiting, e.g. after an emergency stop, so nothing
+// pours unattended once the stop is reset
+func (ord *Orders) failQueued(reason string) {
+
+	ord.lock.Lock()
+	defer ord.lock.Unlock()
+
+	for _, order := range append([]*Order(nil), ord.queue...) {
+		if order.State == OrderQueued {
+			order.State = OrderFailed
+			order.Error = reason
+			ord.remove(order)
+		}
+	}
+}
+
+// update - Stores the state of 'order'. The lock must be held
+func (ord *Orders) update(order *Order) {
+	_, err := ord.ConfigService.Exec("UPDATE "+ord.Name+" SET state = ?, error = ?, started = ?, finished = ? WHERE configID = ?",
+		order.State, order.Error, order.Started, order.Finished, order.ID)
+	if err != nil {
+		logger.Log("Failed to store order %d, %v", order.ID, err)
+	}
+}
+
+// placeOrder - Queues a recipe, by 'recipeId' or 'recipe' name, or a custom pour of
+// 'pourMl0' to 'pourMl5' ml with 'mix', and returns at once with the queued order
+func (ord *Orders) placeOrder(caller Caller, data map[string]interface{}) ([]byte, error) {
+
+	if caller.Username == "" {
+		return nil, PermissionError{Action: "PlaceOrder", Reason: "requires a session"}
+	}
+
+	var order *Order
+	var err error
+	id, hasID := data["recipeId"]
+	name, hasName := data["recipe"]
+	if hasID || hasName {
+		var recipe Recipe
+		recipe, err = ord.recipes.find(map[string]interface{}{"id": id, "name": name})
+		if err != nil {
+			return nil, err
+		}
+		if !recipe.Available {
+			return nil, fmt.Errorf("'%s' is unavailable, not enough %s", recipe.Name, strings.Join(recipe.Missing, ", "))
+		}
+		order, err = ord.place(caller, recipe.Name, recipe.amounts(), recipe.Mix)
+	} else {
+		amounts := make([]float64, ChannelCount)
+		for channel := range amounts {
+			value, ok := data[fmt.Sprintf("pourMl%d", channel)]
+			if !ok {
+				continue
+			}
+			amounts[channel], err = config.JSONfloat64(value)
+			if err != nil {
+				return nil, fmt.Errorf("'pourMl%d' must be a number of ml", channel)
+			}
+		}
+		mix, _ := config.JSONbool(data["mix"])
+		order, err = ord.place(caller, "", amounts, mix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.Log("'%s' placed order %d", caller.Username, order.ID)
+
+	return ord.orderJSON(caller, order.ID)
+}
+
+// orderParam - The order named by 'id'
+func orderParam(data map[string]interface{}) (int64, error) {
+	value, ok := data["id"].(float64)
+	if !ok || value != math.Trunc(value) || value < 1 {
+		return 0, fmt.Errorf("'id' must be an order number")
+	}
+	return int64(value), nil
+}
+
+// canSee - Owners see their own orders, operators and admins see everyone's
+func canSee(caller Caller, order Order) bool {
+	return (caller.Username != "" && order.Owner == caller.Username) || caller.RequireOperator("GetOrder") == nil
+}
+
+// cancelOrder - Takes a queued order off the queue. An order already pouring runs to the end
+func (ord *Orders) cancelOrder(caller Caller, data map[string]interface{}) ([]byte, error) {
+
+	id, err := orderParam(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ord.lock.Lock()
+	var order *Order
+	for _, queued := range ord.queue {
+		if queued.ID == id {
+			order = queued
+			break
+		}
+	}
+	switch {
+	case order == nil:
+		err = fmt.Errorf("order %d is not in the queue", id)
+	case !canSee(caller, *order):
+		err = PermissionError{Action: "CancelOrder", Reason: "is only allowed for your own orders"}
+	case order.State != OrderQueued:
+		err = fmt.Errorf("order %d is already pouring", id)
+	default:
+		order.State = OrderCancelled
+		ord.remove(order)
+		logger.Log("Order %d cancelled by '%s'", id, caller.Username)
+	}
+	ord.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return ord.orderJSON(caller, id)
+}
+
+func (ord *Orders) getOrder(caller Caller, data map[string]interface{}) ([]byte, error) {
+	id, err := orderParam(data)
+	if err != nil {
+		return nil, err
+	}
+	return ord.orderJSON(caller, id)
+}
+
+// orderJSON - The order 'id' as the caller may see it, from the queue while it is waiting
+// or pouring and from the database after
+func (ord *Orders) orderJSON(caller Caller, id int64) ([]byte, error) {
+
+	order, found := ord.queued(id)
+	if !found {
+		rows, err := ord.ConfigService.Query("SELECT "+orderColumns+" FROM "+ord.Name+" WHERE configID = ?", id)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return nil, fmt.Errorf("no such order")
+		}
+		order = orderFromRow(rows[0])
+	}
+
+	if !canSee(caller, order) {
+		return nil, PermissionError{Action: "GetOrder", Reason: "is only allowed for your own orders"}
+	}
+	return json.MarshalIndent(order, "", "\t")
+}
+
+// queued - A copy of order 'id' with its position, if it is still in the queue
+func (ord *Orders) queued(id int64) (Order, bool) {
+
+	ord.lock.Lock()
+	defer ord.lock.Unlock()
+
+	for position, order := range ord.queue {
+		if order.ID == id {
+			found := *order
+			found.Position = position + 1
+			return found, true
+		}
+	}
+	return Order{}, false
+}
+
+func orderFromRow(row map[string]interface{}) Order {
+	var order Order
+	order.ID, _ = config.JSONint64(row["configID"])
+	order.Owner, _ = config.JSONstring(row["owner"])
+	order.Recipe, _ = config.JSONstring(row["recipe"])
+	order.Mix, _ = config.JSONbool(row["mix"])
+	order.State, _ = config.JSONstring(row["state"])
+	order.Error, _ = config.JSONstring(row["error"])
+	order.Created, _ = config.JSONstring(row["created"])
+	order.Started, _ = config.JSONstring(row["started"])
+	order.Finished, _ = config.JSONstring(row["finished"])
+	amounts, _ := config.JSONstring(row["amounts"])
+	order.Amounts = []float64{}
+	json.Unmarshal([]byte(amounts), &order.Amounts)
+	return order
+}
+
+// listQueue - Every order waiting or pouring, front first. Other people's orders show
+// without their owner unless the caller is an operator or admin
+func (ord *Orders) listQueue(caller Caller) ([]byte, error) {
+
+	ord.lock.Lock()
+	queue := make([]Order, len(ord.queue))
+	for position, order := range ord.queue {
+		queue[position] = *order
+		queue[position].Position = position + 1
+		if !canSee(caller, queue[position]) {
+			queue[position].Owner = ""
+		}
+	}
+	ord.lock.Unlock()
+
+	return json.MarshalIndent(queue, "", "\t")
+}
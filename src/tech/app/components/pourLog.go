@@ -0,0 +1,214 @@
+package components
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"tech/app/logger"
+	"tech/mixer/config"
+	"tech/mixer/sensor"
+	"time"
+
+	"github.com/VividCortex/ewma"
+)
+
+const (
+	pourLogName = "pourLog"
+
+	defaultPourLogLimit = 50
+	maxPourLogLimit     = 500
+
+	// maxPourRecords - Older records are dropped as new ones are added
+	maxPourRecords = 1000
+
+	// sensorSettle - Time for the last drops to reach the sensor after a cycle
+	sensorSettle = 500 * time.Millisecond
+
+	// perCycleAge - Cycles the measured volume per cycle is averaged over
+	perCycleAge = 3
+
+	// A cycle measuring under a tenth of the calibrated volume delivered nothing, two in a
+	// row mean an empty bottle, a blocked line or a disconnected sensor
+	noFlowFraction = 0.1
+	maxNoFlow      = 2
+
+	// maxExtraCycles - Cycles a measured pour may run beyond the calibrated number, as a
+	// fraction of it. A flow meter can miss pulses that arrive together, so it is not
+	// trusted to end a pour on its own
+	maxExtraCycles = 0.25
+)
+
+// PourRecord - One channel of a pour. MeasuredMl is nil when no sensor measured it,
+// EstimatedMl is the calibrated volume of the cycles run
+type PourRecord struct {
+	ID          int64    `json:"id"`
+	At          string   `json:"at"`
+	Channel     int      `json:"channel"`
+	RequestedMl float64  `json:"requestedMl"`
+	MeasuredMl  *float64 `json:"measuredMl"`
+	EstimatedMl float64  `json:"estimatedMl"`
+	Cycles      int      `json:"cycles"`
+	Sensor      string   `json:"sensor"`
+	Error       string   `json:"error"`
+}
+
+func (mxr *MixerControl) createPourLogTable(cfg *config.CfgService) error {
+	return cfg.CreateTable(pourLogName, []string{
+		"at TEXT",
+		"channel INTEGER",
+		"requestedMl REAL",
+		"measuredMl REAL",
+		"estimatedMl REAL",
+		"cycles INTEGER",
+		"sensor TEXT",
+		"error TEXT"})
+}
+
+// SetSensor - Measures pours with 'sens', pours run open loop while unset
+func (mxr *MixerControl) SetSensor(sens sensor.Sensor) {
+	mxr.sensor = sens
+}
+
+// pourChannel - Pours 'ml' on 'channel'. With a sensor on the channel the pump runs a cycle
+// at a time until the measured volume is within half a cycle of 'ml', otherwise the
+// calibrated number of cycles runs open loop
+func (mxr *MixerControl) pourChannel(channel int, ml float64, calibration ChannelCalibration) PourRecord {
+
+	record := PourRecord{At: timestamp(), Channel: channel, RequestedMl: ml, Sensor: sensor.KindNone}
+	var err error
+	if mxr.sensor != nil {
+		err = mxr.sensor.Begin(channel)
+		if err == nil {
+			record.Sensor = mxr.sensor.Name()
+			err = mxr.pourMeasured(&record, calibration)
+			mxr.sensor.End()
+		} else if err == sensor.ErrNotFitted {
+			err = nil
+		} else {
+			logger.Log("Cannot measure channel %d, %v", channel, err)
+			err = fmt.Errorf("%s sensor: %v", mxr.sensor.Name(), err)
+		}
+	}
+	if record.Sensor == sensor.KindNone && err == nil {
+		record.Cycles = cyclesFor(ml, calibration)
+		err = mxr.motorCall(channel, record.Cycles)
+	}
+
+	record.EstimatedMl = float64(record.Cycles) * calibration.MlPerCycle
+	if err != nil {
+		record.Error = err.Error()
+	}
+	mxr.logPour(record)
+	return record
+}
+
+// pourMeasured - The closed loop. The volume expected from the next cycle starts at the
+// calibrated volume and follows what each cycle measures. An emergency stop ends it
+// between cycles
+func (mxr *MixerControl) pourMeasured(record *PourRecord, calibration ChannelCalibration) error {
+
+	perCycle := ewma.NewMovingAverage(perCycleAge)
+	perCycle.Set(calibration.MlPerCycle)
+	cycles := cyclesFor(record.RequestedMl, calibration)
+	maxCycles := cycles + int(math.Ceil(float64(cycles)*maxExtraCycles))
+
+	measured := 0.0
+	noFlow := 0
+	err := mxr.timed(record.Channel, func() error {
+		for record.RequestedMl-measured >= perCycle.Value()/2 {
+			if record.Cycles >= maxCycles {
+				return fmt.Errorf("only %.1f of %.1f ml measured after %d cycles, %d calibrated", measured, record.RequestedMl, record.Cycles, cycles)
+			}
+			if mxr.EmergencyStopped() {
+				return errEmergencyStop
+			}
+			err := mxr.actuator.Pour(record.Channel, 1)
+			if err != nil {
+				return err
+			}
+			record.Cycles++
+			time.Sleep(sensorSettle)
+
+			volume, err := mxr.sensor.Volume()
+			if err != nil {
+				return err
+			}
+			delivered := volume - measured
+			measured = volume
+			// Checked once the cycle's volume is in, so a stopped pour records what it poured
+			if mxr.EmergencyStopped() {
+				return errEmergencyStop
+			}
+			if delivered < noFlowFraction*calibration.MlPerCycle {
+				noFlow++
+				if noFlow >= maxNoFlow {
+					return fmt.Errorf("no flow measured")
+				}
+				continue
+			}
+			noFlow = 0
+			perCycle.Add(delivered)
+		}
+		return nil
+	})
+	record.MeasuredMl = &measured
+	return err
+}
+
+// logPour - Records a channel's pour, dropping the oldest records beyond maxPourRecords
+func (mxr *MixerControl) logPour(record PourRecord) {
+
+	var measured interface{}
+	if record.MeasuredMl != nil {
+		measured = *record.MeasuredMl
+		logger.Log("Channel %d poured %.1f ml of %.1f requested in %d cycles", record.Channel, measured, record.RequestedMl, record.Cycles)
+	}
+	id, err := mxr.ConfigService.Exec("INSERT INTO "+pourLogName+
+		" (at, channel, requestedMl, measuredMl, estimatedMl, cycles, sensor, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		record.At, record.Channel, record.RequestedMl, measured, record.EstimatedMl, record.Cycles, record.Sensor, record.Error)
+	if err == nil {
+		_, err = mxr.ConfigService.Exec("DELETE FROM "+pourLogName+" WHERE configID <= ?", id-maxPourRecords)
+	}
+	if err != nil {
+		logger.Log("Failed to record pour on channel %d, %v", record.Channel, err)
+	}
+}
+
+// pourLog - The latest pour records, newest first, operators and admins only
+func (mxr *MixerControl) pourLog(caller Caller, data map[string]interface{}) ([]byte, error) {
+
+	err := caller.RequireOperator("GetPourLog")
+	if err != nil {
+		return nil, err
+	}
+	limit := defaultPourLogLimit
+	if value, ok := data["limit"]; ok {
+		limit, _ = config.JSONint(value)
+		if limit < 1 || limit > maxPourLogLimit {
+			return nil, fmt.Errorf("'limit' must be 1 to %d", maxPourLogLimit)
+		}
+	}
+
+	rows, err := mxr.ConfigService.Query("SELECT * FROM "+pourLogName+" ORDER BY configID DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]PourRecord, 0, len(rows))
+	for _, row := range rows {
+		var record PourRecord
+		record.ID, _ = config.JSONint64(row["configID"])
+		record.At, _ = config.JSONstring(row["at"])
+		record.Channel, _ = config.JSONint(row["channel"])
+		record.RequestedMl, _ = config.JSONfloat64(row["requestedMl"])
+		record.EstimatedMl, _ = config.JSONfloat64(row["estimatedMl"])
+		record.Cycles, _ = config.JSONint(row["cycles"])
+		record.Sensor, _ = config.JSONstring(row["sensor"])
+		record.Error, _ = config.JSONstring(row["error"])
+		if row["measuredMl"] != nil {
+			measured, _ := config.JSONfloat64(row["measuredMl"])
+			record.MeasuredMl = &measured
+		}
+		records = append(records, record)
+	}
+	return json.MarshalIndent(records, "", "\t")
+}
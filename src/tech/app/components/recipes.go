@@ -0,0 +1,451 @@
+package components
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"tech/app/logger"
+	"tech/mixer/config"
+	"unicode/utf8"
+)
+
+const (
+	recipesName           = "recipes"
+	recipeIngredientsName = "recipeIngredients"
+
+	maxRecipeNameLength   = 64
+	maxDescriptionLength  = 1024
+	maxImageLength        = 256
+	maxRecipeSteps        = 20
+	maxStepLength         = 256
+	maxIngredientMl       = MaxPourMl
+	ingredientNotLoaded   = -1
+	recipeColumns         = "configID, name, description, image, steps, mix"
+	recipeIngredientQuery = "SELECT ingredient, ml FROM " + recipeIngredientsName + " WHERE recipeID = ? ORDER BY position"
+)
+
+// Ingredient - One ingredient of a recipe. Channel is where it is currently loaded, or -1
+type Ingredient struct {
+	Ingredient string  `json:"ingredient"`
+	ML         float64 `json:"ml"`
+	Channel    int     `json:"channel"`
+}
+
+// Recipe - A named drink. Available reports whether every ingredient is loaded on a channel
+// with enough left to pour, the ones that are not are listed in Missing
+type Recipe struct {
+	ID          int64        `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Image       string       `json:"image"`
+	Steps       []string     `json:"steps"`
+	Mix         bool         `json:"mix"`
+	Ingredients []Ingredient `json:"ingredients"`
+	Available   bool         `json:"available"`
+	Missing     []string     `json:"missing"`
+}
+
+// Recipes - Catalog of named drinks, ordered by name and poured from whichever channels
+// hold their ingredients
+type Recipes struct {
+	MixerComponent
+
+	mixerControl *MixerControl
+	orders       *Orders
+}
+
+// NewRecipes -
+func NewRecipes(cfg *config.CfgService, mixerControl *MixerControl) *Recipes {
+
+	rcp := &Recipes{mixerControl: mixerControl}
+	rcp.Name = recipesName
+	rcp.ConfigService = cfg
+
+	cfg.Register(rcp.Name, rcp.createTables)
+
+	return rcp
+}
+
+// Action -
+func (rcp *Recipes) Action(action string, data []byte) (response []byte, err error) {
+	return rcp.CallerAction(Caller{}, action, data)
+}
+
+// CallerAction - Anyone may browse and order recipes, operators and admins edit them
+func (rcp *Recipes) CallerAction(caller Caller, action string, data []byte) (response []byte, err error) {
+
+	mapData := make(map[string]interface{})
+	if len(data) > 0 {
+		mapData, err = config.JsonToMap(data)
+		if err != nil {
+			logger.Log("Failed to unmarshall data on '%s'", rcp.Name)
+			return
+		}
+	}
+
+	switch action {
+	case "ListRecipes":
+		response, err = rcp.listRecipes()
+
+	case "GetRecipe":
+		var recipe Recipe
+		recipe, err = rcp.find(mapData)
+		if err == nil {
+			response, err = json.MarshalIndent(recipe, "", "\t")
+		}
+
+	case "SaveRecipe":
+		err = caller.RequireOperator(action)
+		if err == nil {
+			response, err = rcp.saveRecipe(caller, data)
+		}
+
+	case "DeleteRecipe":
+		err = caller.RequireOperator(action)
+		if err == nil {
+			response, err = rcp.deleteRecipe(caller, mapData)
+		}
+
+	case "OrderRecipe":
+		response, err = rcp.orderRecipe(caller, mapData)
+
+	default:
+		logger.Log("Unrecognized action received in '%s': '%s'", rcp.Name, action)
+	}
+
+	return
+}
+
+// Start -
+func (rcp *Recipes) Start() error {
+	return nil
+}
+
+// Stop -
+func (rcp *Recipes) Stop() error {
+	return nil
+}
+
+// createTables - Creates the recipe tables and seeds an empty catalog with drinks made from
+// the default channel ingredients
+func (rcp *Recipes) createTables(cfg *config.CfgService) (err error) {
+	recipeSchema := []string{
+		"name TEXT NOT NULL",
+		"description TEXT",
+		"image TEXT",
+		"steps TEXT",
+		"mix INTEGER DEFAULT 0"}
+
+	ingredientSchema := []string{
+		"recipeID INTEGER NOT NULL",
+		"ingredient TEXT NOT NULL",
+		"ml REAL NOT NULL",
+		"position INTEGER"}
+
+	err = cfg.CreateTable(rcp.Name, recipeSchema)
+	if err != nil {
+		return
+	}
+	_, err = cfg.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + rcp.Name + "_name ON " + rcp.Name + " (name COLLATE NOCASE)")
+	if err != nil {
+		return
+	}
+	err = cfg.CreateTable(recipeIngredientsName, ingredientSchema)
+	if err != nil {
+		return
+	}
+	_, err = cfg.Exec("CREATE INDEX IF NOT EXISTS " + recipeIngredientsName + "_recipe ON " + recipeIngredientsName + " (recipeID)")
+	if err != nil {
+		return
+	}
+
+	count, err := cfg.Query("SELECT count(*) AS recipes FROM " + rcp.Name)
+	if err != nil {
+		return
+	}
+	if recipes, _ := config.JSONint64(count[0]["recipes"]); recipes > 0 {
+		return nil
+	}
+
+	defaults := []Recipe{
+		{Name: "Crown and Coke", Description: "Canadian whisky over cola.", Ingredients: []Ingredient{
+			{Ingredient: "Crown Royal", ML: 45}, {Ingredient: "Coca-Cola", ML: 150}}},
+		{Name: "Gin Soda", Description: "Gin topped with soda water.", Ingredients: []Ingredient{
+			{Ingredient: "Tofino Gin", ML: 45}, {Ingredient: "Soda Water", ML: 150}}},
+		{Name: "Gin Sour", Description: "Gin shaken with lemon and agave.", Mix: true,
+			Steps: []string{"Garnish with a lemon twist"}, Ingredients: []Ingredient{
+				{Ingredient: "Tofino Gin", ML: 60}, {Ingredient: "Lemon Juice", ML: 30}, {Ingredient: "Agave Syrup", ML: 15}}}}
+
+	for _, recipe := range defaults {
+		_, err = rcp.store(recipe)
+		if err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// validateRecipe - Tidies 'recipe' and checks it can be stored and poured
+func validateRecipe(recipe *Recipe) error {
+
+	recipe.Name = strings.TrimSpace(recipe.Name)
+	if recipe.Name == "" || utf8.RuneCountInString(recipe.Name) > maxRecipeNameLength {
+		return fmt.Errorf("recipe name must be 1 to %d characters", maxRecipeNameLength)
+	}
+	if utf8.RuneCountInString(recipe.Description) > maxDescriptionLength {
+		return fmt.Errorf("description must be at most %d characters", maxDescriptionLength)
+	}
+	if len(recipe.Image) > maxImageLength {
+		return fmt.Errorf("image must be at most %d characters", maxImageLength)
+	}
+
+	if len(recipe.Steps) > maxRecipeSteps {
+		return fmt.Errorf("at most %d steps", maxRecipeSteps)
+	}
+	for _, step := range recipe.Steps {
+		if utf8.RuneCountInString(step) > maxStepLength {
+			return fmt.Errorf("steps must be at most %d characters", maxStepLength)
+		}
+	}
+
+	// Each ingredient needs its own channel
+	if len(recipe.Ingredients) == 0 || len(recipe.Ingredients) > ChannelCount {
+		return fmt.Errorf("a recipe needs 1 to %d ingredients", ChannelCount)
+	}
+	seen := make(map[string]bool)
+	for i := range recipe.Ingredients {
+		ingredient := &recipe.Ingredients[i]
+		ingredient.Ingredient = strings.TrimSpace(ingredient.Ingredient)
+		key := strings.ToLower(ingredient.Ingredient)
+		if key == "" || seen[key] {
+			return fmt.Errorf("ingredients must be named and listed once")
+		}
+		seen[key] = true
+		if ingredient.ML <= 0 || ingredient.ML > maxIngredientMl {
+			return fmt.Errorf("'%s' must be more than 0 and at most %.0f ml", ingredient.Ingredient, maxIngredientMl)
+		}
+	}
+
+	return nil
+}
+
+// store - Inserts 'recipe', or replaces the stored one if it has an ID, and returns its ID
+func (rcp *Recipes) store(recipe Recipe) (int64, error) {
+
+	if recipe.Steps == nil {
+		recipe.Steps = []string{}
+	}
+	steps, err := json.Marshal(recipe.Steps)
+	if err != nil {
+		return 0, err
+	}
+	mix := int64(0)
+	if recipe.Mix {
+		mix = 1
+	}
+
+	id := recipe.ID
+	if id == 0 {
+		id, err = rcp.ConfigService.Exec("INSERT INTO "+rcp.Name+" (name, description, image, steps, mix) VALUES (?, ?, ?, ?, ?)",
+			recipe.Name, recipe.Description, recipe.Image, string(steps), mix)
+	} else {
+		_, err = rcp.ConfigService.Exec("UPDATE "+rcp.Name+" SET name = ?, description = ?, image = ?, steps = ?, mix = ? WHERE configID = ?",
+			recipe.Name, recipe.Description, recipe.Image, string(steps), mix, id)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return 0, fmt.Errorf("a recipe named '%s' already exists", recipe.Name)
+		}
+		return 0, err
+	}
+
+	_, err = rcp.ConfigService.Exec("DELETE FROM "+recipeIngredientsName+" WHERE recipeID = ?", id)
+	if err != nil {
+		return 0, err
+	}
+	for position, ingredient := range recipe.Ingredients {
+		_, err = rcp.ConfigService.Exec("INSERT INTO "+recipeIngredientsName+" (recipeID, ingredient, ml, position) VALUES (?, ?, ?, ?)",
+			id, ingredient.Ingredient, ingredient.ML, int64(position))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
+
+// load - Reads the recipes matching 'where' and resolves their ingredients to channels
+func (rcp *Recipes) load(where string, args ...interface{}) ([]Recipe, error) {
+
+	rows, err := rcp.ConfigService.Query("SELECT "+recipeColumns+" FROM "+rcp.Name+" "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	levels, err := rcp.mixerControl.Levels()
+	if err != nil {
+		return nil, err
+	}
+	channels := make([]string, len(levels))
+	for channel, level := range levels {
+		channels[channel] = level.Ingredient
+	}
+
+	recipes := make([]Recipe, 0, len(rows))
+	for _, row := range rows {
+		var recipe Recipe
+		recipe.ID, _ = config.JSONint64(row["configID"])
+		recipe.Name, _ = config.JSONstring(row["name"])
+		recipe.Description, _ = config.JSONstring(row["description"])
+		recipe.Image, _ = config.JSONstring(row["image"])
+		recipe.Mix, _ = config.JSONbool(row["mix"])
+		steps, _ := config.JSONstring(row["steps"])
+		recipe.Steps = []string{}
+		json.Unmarshal([]byte(steps), &recipe.Steps)
+
+		ingredients, err := rcp.ConfigService.Query(recipeIngredientQuery, recipe.ID)
+		if err != nil {
+			return nil, err
+		}
+		recipe.Ingredients = []Ingredient{}
+		recipe.Missing = []string{}
+		for _, row := range ingredients {
+			var ingredient Ingredient
+			ingredient.Ingredient, _ = config.JSONstring(row["ingredient"])
+			ingredient.ML, _ = config.JSONfloat64(row["ml"])
+			ingredient.Channel = findChannel(channels, ingredient.Ingredient)
+			if ingredient.Channel == ingredientNotLoaded || !levels[ingredient.Channel].canPour(ingredient.ML) {
+				recipe.Missing = append(recipe.Missing, ingredient.Ingredient)
+			}
+			recipe.Ingredients = append(recipe.Ingredients, ingredient)
+		}
+		recipe.Available = len(recipe.Missing) == 0
+		recipes = append(recipes, recipe)
+	}
+
+	return recipes, nil
+}
+
+// amounts - Ml to pour from each channel, only meaningful for an available recipe
+func (recipe Recipe) amounts() []float64 {
+	amounts := make([]float64, ChannelCount)
+	for _, ingredient := range recipe.Ingredients {
+		if ingredient.Channel != ingredientNotLoaded {
+			amounts[ingredient.Channel] += ingredient.ML
+		}
+	}
+	return amounts
+}
+
+// findChannel - The channel holding 'ingredient', names are compared ignoring case
+func findChannel(channels []string, ingredient string) int {
+	for channel, name := range channels {
+		if name != "" && strings.EqualFold(strings.TrimSpace(name), ingredient) {
+			return channel
+		}
+	}
+	return ingredientNotLoaded
+}
+
+// find - Looks a recipe up by 'id' or 'name'
+func (rcp *Recipes) find(data map[string]interface{}) (Recipe, error) {
+
+	var recipes []Recipe
+	var err error
+	if id, ok := data["id"].(float64); ok {
+		recipes, err = rcp.load("WHERE configID = ?", int64(id))
+	} else if name, ok := data["name"].(string); ok && name != "" {
+		recipes, err = rcp.load("WHERE name = ? COLLATE NOCASE", strings.TrimSpace(name))
+	} else {
+		return Recipe{}, fmt.Errorf("'id' or 'name' is required")
+	}
+	if err != nil {
+		return Recipe{}, err
+	}
+	if len(recipes) == 0 {
+		return Recipe{}, fmt.Errorf("no such recipe")
+	}
+	return recipes[0], nil
+}
+
+func (rcp *Recipes) listRecipes() ([]byte, error) {
+	recipes, err := rcp.load("ORDER BY name COLLATE NOCASE")
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(recipes, "", "\t")
+}
+
+// saveRecipe - Creates a recipe, or replaces the one given by 'id'
+func (rcp *Recipes) saveRecipe(caller Caller, data []byte) ([]byte, error) {
+
+	var recipe Recipe
+	err := json.Unmarshal(data, &recipe)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipe")
+	}
+	err = validateRecipe(&recipe)
+	if err != nil {
+		return nil, err
+	}
+	if recipe.ID != 0 {
+		if _, err = rcp.find(map[string]interface{}{"id": float64(recipe.ID)}); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := rcp.store(recipe)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log("'%s' saved recipe '%s'", caller.Username, recipe.Name)
+
+	saved, err := rcp.find(map[string]interface{}{"id": float64(id)})
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(saved, "", "\t")
+}
+
+func (rcp *Recipes) deleteRecipe(caller Caller, data map[string]interface{}) ([]byte, error) {
+
+	recipe, err := rcp.find(data)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = rcp.ConfigService.Exec("DELETE FROM "+recipeIngredientsName+" WHERE recipeID = ?", recipe.ID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = rcp.ConfigService.Exec("DELETE FROM "+rcp.Name+" WHERE configID = ?", recipe.ID)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log("'%s' deleted recipe '%s'", caller.Username, recipe.Name)
+
+	return nil, nil
+}
+
+// orderRecipe - Pours a recipe from the channels holding its ingredients
+func (rcp *Recipes) orderRecipe(caller Caller, data map[string]interface{}) ([]byte, error) {
+
+	recipe, err := rcp.find(data)
+	if err != nil {
+		return nil, err
+	}
+	if !recipe.Available {
+		return nil, fmt.Errorf("'%s' is unavailable, not enough %s", recipe.Name, strings.Join(recipe.Missing, ", "))
+	}
+
+	logger.Log("Pouring '%s' for '%s'", recipe.Name, caller.Username)
+	if rcp.orders == nil {
+		err = rcp.mixerControl.PourML(recipe.amounts(), recipe.Mix)
+	} else {
+		err = rcp.orders.pourAndWait(caller, recipe.Name, recipe.amounts(), recipe.Mix)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(recipe, "", "\t")
+}
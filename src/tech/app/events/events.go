@@ -0,0 +1,88 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// RecentSize - Number of events kept for GetRecent
+	RecentSize = 100
+)
+
+// Event - Something that happened on the device which other parts may want to react to
+type Event struct {
+	Time   time.Time              `json:"time"`
+	Type   string                 `json:"type"`
+	Source string                 `json:"source"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+// Handler - Called for each published event, must not block
+type Handler func(event Event)
+
+// Bus - Delivers published events to subscribers and remembers the most recent ones
+type Bus struct {
+	lock     sync.Mutex
+	handlers map[string][]Handler
+	recent   []Event
+	size     int
+}
+
+// Default - The bus used by the package level functions
+var Default = NewBus(RecentSize)
+
+// NewBus - Creates a bus keeping the last 'size' events
+func NewBus(size int) *Bus {
+	return &Bus{handlers: make(map[string][]Handler), size: size}
+}
+
+// Subscribe - Registers 'handler' for events of 'eventType', an empty type receives every event
+func (bus *Bus) Subscribe(eventType string, handler Handler) {
+	bus.lock.Lock()
+	bus.handlers[eventType] = append(bus.handlers[eventType], handler)
+	bus.lock.Unlock()
+}
+
+// Publish - Records the event and calls its subscribers on the caller's goroutine
+func (bus *Bus) Publish(eventType string, source string, data map[string]interface{}) {
+
+	event := Event{Time: time.Now().UTC(), Type: eventType, Source: source, Data: data}
+
+	bus.lock.Lock()
+	bus.recent = append(bus.recent, event)
+	if len(bus.recent) > bus.size {
+		bus.recent = bus.recent[len(bus.recent)-bus.size:]
+	}
+	handlers := append([]Handler{}, bus.handlers[eventType]...)
+	if eventType != "" {
+		handlers = append(handlers, bus.handlers[""]...)
+	}
+	bus.lock.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// Recent - Returns the remembered events, oldest first
+func (bus *Bus) Recent() []Event {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	return append([]Event{}, bus.recent...)
+}
+
+// Subscribe - Registers 'handler' on the default bus
+func Subscribe(eventType string, handler Handler) {
+	Default.Subscribe(eventType, handler)
+}
+
+// Publish - Publishes on the default bus
+func Publish(eventType string, source string, data map[string]interface{}) {
+	Default.Publish(eventType, source, data)
+}
+
+// Recent - Returns the default bus's remembered events
+func Recent() []Event {
+	return Default.Recent()
+}
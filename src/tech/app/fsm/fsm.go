@@ -0,0 +1,160 @@
+package fsm
+
+import (
+	"fmt"
+	"sync"
+	"tech/app/logger"
+	"time"
+)
+
+// Record - One transition, kept in the machine's history
+type Record struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+// Snapshot - The machine's state as reported to clients. History is oldest first
+type Snapshot struct {
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Previous string    `json:"previous"`
+	Reason   string    `json:"reason"`
+	History  []Record  `json:"history"`
+}
+
+// Guard - Refuses a transition by returning an error
+type Guard func(record Record) error
+
+// Action - Runs on entering or leaving a state. Actions must not start transitions of their
+// own machine
+type Action func(record Record)
+
+// TransitionError - A transition the machine does not allow from its current state
+type TransitionError struct {
+	Machine string
+	From    string
+	To      string
+}
+
+func (e TransitionError) Error() string {
+	return fmt.Sprintf("%s cannot go from %s to %s", e.Machine, e.From, e.To)
+}
+
+type state struct {
+	onEnter Action
+	onExit  Action
+}
+
+// Machine - A finite state machine safe for use from several goroutines. Transitions are
+// run one at a time: guard, exit action of the old state, then entry action of the new one
+type Machine struct {
+	name         string
+	states       map[string]*state
+	rules        map[string]map[string]Guard
+	historyLimit int
+
+	// transitionLock - Held for a whole transition, lock only while fields change
+	transitionLock sync.Mutex
+	lock           sync.Mutex
+	current        Record
+	history        []Record
+}
+
+// New - A machine called 'name' starting in 'initial', keeping the last 'historyLimit'
+// transitions
+func New(name string, initial string, historyLimit int) *Machine {
+	machine := &Machine{
+		name:         name,
+		states:       make(map[string]*state),
+		rules:        make(map[string]map[string]Guard),
+		historyLimit: historyLimit,
+		current:      Record{To: initial, Reason: "initial", At: time.Now().UTC()}}
+	machine.AddState(initial, nil, nil)
+	return machine
+}
+
+// AddState - Declares a state, with optional entry and exit actions
+func (machine *Machine) AddState(name string, onEnter Action, onExit Action) {
+	machine.states[name] = &state{onEnter: onEnter, onExit: onExit}
+}
+
+// Allow - Permits moving from each of 'from' to 'to', when 'guard', if any, agrees
+func (machine *Machine) Allow(to string, guard Guard, from ...string) {
+	for _, name := range from {
+		if machine.rules[name] == nil {
+			machine.rules[name] = make(map[string]Guard)
+		}
+		machine.rules[name][to] = guard
+	}
+}
+
+// Transition - Moves to 'to' for 'reason', or returns a TransitionError or the guard's error
+func (machine *Machine) Transition(to string, reason string) error {
+
+	machine.transitionLock.Lock()
+	defer machine.transitionLock.Unlock()
+
+	from := machine.State()
+	guard, allowed := machine.rules[from][to]
+	if !allowed || machine.states[to] == nil {
+		return TransitionError{Machine: machine.name, From: from, To: to}
+	}
+	record := Record{From: from, To: to, Reason: reason, At: time.Now().UTC()}
+	if guard != nil {
+		err := guard(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	if exit := machine.states[from].onExit; exit != nil {
+		exit(record)
+	}
+
+	machine.lock.Lock()
+	machine.current = record
+	machine.history = append(machine.history, record)
+	if len(machine.history) > machine.historyLimit {
+		machine.history = append([]Record(nil), machine.history[len(machine.history)-machine.historyLimit:]...)
+	}
+	machine.lock.Unlock()
+	logger.LogDebug("%s: %s -> %s (%s)", machine.name, from, to, reason)
+
+	if enter := machine.states[to].onEnter; enter != nil {
+		enter(record)
+	}
+	return nil
+}
+
+// State - The current state
+func (machine *Machine) State() string {
+	machine.lock.Lock()
+	defer machine.lock.Unlock()
+	return machine.current.To
+}
+
+// Is - Whether the machine is in one of 'states'
+func (machine *Machine) Is(states ...string) bool {
+	current := machine.State()
+	for _, name := range states {
+		if name == current {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot -
+func (machine *Machine) Snapshot() Snapshot {
+	machine.lock.Lock()
+	defer machine.lock.Unlock()
+
+	return Snapshot{
+		State:    machine.current.To,
+		Since:    machine.current.At,
+		Previous: machine.current.From,
+		Reason:   machine.current.Reason,
+		History:  append([]Record{}, machine.history...)}
+}
@@ -0,0 +1,169 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// newDoor - A door that opens and closes, and only locks when closed with the key
+func newDoor(haveKey *bool, actions *[]string, historyLimit int) *Machine {
+
+	log := func(what string) Action {
+		return func(record Record) {
+			*actions = append(*actions, fmt.Sprintf("%s %s->%s", what, record.From, record.To))
+		}
+	}
+
+	machine := New("door", "closed", historyLimit)
+	machine.AddState("closed", log("enter"), log("exit"))
+	machine.AddState("open", log("enter"), log("exit"))
+	machine.AddState("locked", nil, nil)
+	machine.AddState("broken", nil, nil)
+
+	machine.Allow("open", nil, "closed")
+	machine.Allow("closed", nil, "open", "locked")
+	machine.Allow("locked", func(record Record) error {
+		if !*haveKey {
+			return errors.New("no key")
+		}
+		return nil
+	}, "closed")
+	// Allowed but never declared with AddState
+	machine.Allow("missing", nil, "closed")
+
+	return machine
+}
+
+func TestTransition(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		to      string
+		haveKey bool
+		from    string
+		err     string
+		actions []string
+	}{
+		{name: "allowed", to: "open", actions: []string{"exit closed->open", "enter closed->open"}},
+		{name: "not allowed", to: "broken", err: "door cannot go from closed to broken"},
+		{name: "unknown state", to: "missing", err: "door cannot go from closed to missing"},
+		{name: "guard accepts", to: "locked", haveKey: true, actions: []string{"exit closed->locked"}},
+		{name: "guard refuses", to: "locked", err: "no key"},
+		{name: "from elsewhere", from: "open", to: "open", err: "door cannot go from open to open"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var actions []string
+			haveKey := test.haveKey
+			machine := newDoor(&haveKey, &actions, 10)
+			if test.from != "" {
+				if err := machine.Transition(test.from, "setup"); err != nil {
+					t.Fatalf("Transition to %s: %v", test.from, err)
+				}
+			}
+			before := machine.Snapshot()
+			actions = nil
+
+			err := machine.Transition(test.to, "test")
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("Transition returned %v, want %q", err, test.err)
+				}
+				if _, ok := err.(TransitionError); ok != (test.err != "no key") {
+					t.Errorf("Transition returned a %T", err)
+				}
+				after := machine.Snapshot()
+				if after.State != before.State || len(after.History) != len(before.History) {
+					t.Errorf("a refused transition changed the machine from %+v to %+v", before, after)
+				}
+				if len(actions) != 0 {
+					t.Errorf("a refused transition ran the actions %v", actions)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Transition: %v", err)
+			}
+			if !machine.Is(test.to) || machine.Is(before.State) {
+				t.Errorf("state is %s, want %s", machine.State(), test.to)
+			}
+			snapshot := machine.Snapshot()
+			if snapshot.Previous != before.State || snapshot.Reason != "test" || snapshot.Since.IsZero() {
+				t.Errorf("snapshot is %+v, want previous %s for the reason test", snapshot, before.State)
+			}
+			if fmt.Sprint(actions) != fmt.Sprint(test.actions) {
+				t.Errorf("actions ran %v, want %v", actions, test.actions)
+			}
+		})
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+
+	var actions []string
+	haveKey := false
+	machine := newDoor(&haveKey, &actions, 3)
+
+	snapshot := machine.Snapshot()
+	if snapshot.State != "closed" || snapshot.Reason != "initial" || len(snapshot.History) != 0 {
+		t.Fatalf("new machine is %+v, want closed with no history", snapshot)
+	}
+
+	for i := 0; i < 5; i++ {
+		to := "open"
+		if i%2 == 1 {
+			to = "closed"
+		}
+		if err := machine.Transition(to, fmt.Sprint(i)); err != nil {
+			t.Fatalf("transition %d: %v", i, err)
+		}
+	}
+
+	// Only the last 3 are kept, oldest first
+	history := machine.Snapshot().History
+	if len(history) != 3 {
+		t.Fatalf("history holds %d records, want 3", len(history))
+	}
+	for i, record := range history {
+		if want := fmt.Sprint(i + 2); record.Reason != want {
+			t.Errorf("history[%d] is for %q, want %q", i, record.Reason, want)
+		}
+	}
+
+	// The snapshot is a copy
+	history[0].Reason = "changed"
+	if machine.Snapshot().History[0].Reason == "changed" {
+		t.Error("changing a snapshot's history changed the machine")
+	}
+}
+
+func TestConcurrentTransitions(t *testing.T) {
+
+	// Many goroutines race to leave closed, exactly one may win
+	machine := New("race", "closed", 10)
+	machine.AddState("open", nil, nil)
+	machine.Allow("open", nil, "closed")
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	won := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if machine.Transition("open", "race") == nil {
+				lock.Lock()
+				won++
+				lock.Unlock()
+			}
+			machine.Snapshot()
+		}()
+	}
+	wg.Wait()
+	if won != 1 {
+		t.Errorf("%d transitions out of closed succeeded, want 1", won)
+	}
+}
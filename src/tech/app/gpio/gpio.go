@@ -0,0 +1,127 @@
+package gpio
+
+import (
+	"sync"
+	"time"
+)
+
+// Edges an input can wait for
+const (
+	EdgeNone    = "none"
+	EdgeRising  = "rising"
+	EdgeFalling = "falling"
+	EdgeBoth    = "both"
+)
+
+// Input - A digital input. WaitEdge blocks until the edge set with SetEdge or 'timeout',
+// reporting whether an edge arrived
+type Input interface {
+	Read() (bool, error)
+	SetEdge(edge string) error
+	WaitEdge(timeout time.Duration) (bool, error)
+	Close() error
+}
+
+// Output - A digital output
+type Output interface {
+	Write(high bool) error
+	Close() error
+}
+
+// Fake - In memory input for running code off-device. Set changes the level and wakes
+// WaitEdge when it matches the edge being waited for
+type Fake struct {
+	lock  sync.Mutex
+	level bool
+	edge  string
+	edges chan struct{}
+}
+
+// NewFake - An input reading 'level'
+func NewFake(level bool) *Fake {
+	return &Fake{level: level, edge: EdgeNone, edges: make(chan struct{}, 1024)}
+}
+
+// Set - Drives the input to 'level'
+func (fake *Fake) Set(level bool) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	changed := level != fake.level
+	fake.level = level
+	if !changed {
+		return
+	}
+	if fake.edge == EdgeBoth || (fake.edge == EdgeRising && level) || (fake.edge == EdgeFalling && !level) {
+		select {
+		case fake.edges <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Read -
+func (fake *Fake) Read() (bool, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return fake.level, nil
+}
+
+// SetEdge -
+func (fake *Fake) SetEdge(edge string) error {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.edge = edge
+	return nil
+}
+
+// WaitEdge -
+func (fake *Fake) WaitEdge(timeout time.Duration) (bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-fake.edges:
+		return true, nil
+	case <-timer.C:
+		return false, nil
+	}
+}
+
+// Close -
+func (fake *Fake) Close() error {
+	return nil
+}
+
+// FakeOutput - In memory output, OnWrite is called with each level written
+type FakeOutput struct {
+	lock  sync.Mutex
+	level bool
+
+	OnWrite func(high bool)
+}
+
+// Write -
+func (fake *FakeOutput) Write(high bool) error {
+	fake.lock.Lock()
+	fake.level = high
+	onWrite := fake.OnWrite
+	fake.lock.Unlock()
+
+	if onWrite != nil {
+		onWrite(high)
+	}
+	return nil
+}
+
+// Level - The level last written
+func (fake *FakeOutput) Level() bool {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return fake.level
+}
+
+// Close -
+func (fake *FakeOutput) Close() error {
+	return nil
+}
@@ -0,0 +1,140 @@
+package gpio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const sysfsRoot = "/sys/class/gpio"
+
+// Pin - An input exported through the kernel's sysfs GPIO interface
+type Pin struct {
+	number int
+	value  *os.File
+	epoll  int
+}
+
+// OutputPin - An output exported through the kernel's sysfs GPIO interface
+type OutputPin struct {
+	value *os.File
+}
+
+// export - Exports GPIO 'number' if needed and sets its 'direction', returning its directory
+func export(number int, direction string) (string, error) {
+
+	dir := fmt.Sprintf("%s/gpio%d", sysfsRoot, number)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		err = ioutil.WriteFile(sysfsRoot+"/export", []byte(strconv.Itoa(number)), 0)
+		if err != nil {
+			return "", err
+		}
+		// udev takes a moment to make the new files writable
+		time.Sleep(100 * time.Millisecond)
+	}
+	return dir, ioutil.WriteFile(dir+"/direction", []byte(direction), 0)
+}
+
+// Open - Exports GPIO 'number', as numbered by the kernel, as an input
+func Open(number int) (*Pin, error) {
+
+	dir, err := export(number, "in")
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := os.Open(dir + "/value")
+	if err != nil {
+		return nil, err
+	}
+	epoll, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		value.Close()
+		return nil, err
+	}
+	event := syscall.EpollEvent{Events: syscall.EPOLLPRI | syscall.EPOLLERR, Fd: int32(value.Fd())}
+	err = syscall.EpollCtl(epoll, syscall.EPOLL_CTL_ADD, int(value.Fd()), &event)
+	if err != nil {
+		value.Close()
+		syscall.Close(epoll)
+		return nil, err
+	}
+
+	return &Pin{number: number, value: value, epoll: epoll}, nil
+}
+
+// Read - The current level, true for high
+func (pin *Pin) Read() (bool, error) {
+	level := make([]byte, 1)
+	_, err := pin.value.ReadAt(level, 0)
+	if err != nil {
+		return false, err
+	}
+	return level[0] == '1', nil
+}
+
+// SetEdge - Chooses the edges WaitEdge wakes for
+func (pin *Pin) SetEdge(edge string) error {
+	err := ioutil.WriteFile(fmt.Sprintf("%s/gpio%d/edge", sysfsRoot, pin.number), []byte(edge), 0)
+	if err != nil {
+		return err
+	}
+	// An edge pending from before would wake the first wait at once
+	_, err = pin.Read()
+	return err
+}
+
+// WaitEdge -
+func (pin *Pin) WaitEdge(timeout time.Duration) (bool, error) {
+	events := make([]syscall.EpollEvent, 1)
+	for {
+		count, err := syscall.EpollWait(pin.epoll, events, int(timeout/time.Millisecond))
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || count == 0 {
+			return false, err
+		}
+		// Reading the value acknowledges the edge
+		_, err = pin.Read()
+		return true, err
+	}
+}
+
+// Close -
+func (pin *Pin) Close() error {
+	syscall.Close(pin.epoll)
+	return pin.value.Close()
+}
+
+// OpenOutput - Exports GPIO 'number' as an output, driven low
+func OpenOutput(number int) (*OutputPin, error) {
+
+	dir, err := export(number, "low")
+	if err != nil {
+		return nil, err
+	}
+	value, err := os.OpenFile(dir+"/value", os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &OutputPin{value: value}, nil
+}
+
+// Write - Drives the output high or low
+func (pin *OutputPin) Write(high bool) error {
+	level := []byte{'0'}
+	if high {
+		level[0] = '1'
+	}
+	_, err := pin.value.WriteAt(level, 0)
+	return err
+}
+
+// Close -
+func (pin *OutputPin) Close() error {
+	return pin.value.Close()
+}
@@ -0,0 +1,56 @@
+//go:build !linux
+// +build !linux
+
+package gpio
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// Pin - sysfs GPIO only exists on Linux
+type Pin struct{}
+
+// OutputPin - sysfs GPIO only exists on Linux
+type OutputPin struct{}
+
+// Open - Always fails off Linux, use a Fake instead
+func Open(number int) (*Pin, error) {
+	return nil, fmt.Errorf("gpio is not available on %s", runtime.GOOS)
+}
+
+// Read -
+func (pin *Pin) Read() (bool, error) {
+	return false, fmt.Errorf("gpio is not available on %s", runtime.GOOS)
+}
+
+// SetEdge -
+func (pin *Pin) SetEdge(edge string) error {
+	return fmt.Errorf("gpio is not available on %s", runtime.GOOS)
+}
+
+// WaitEdge -
+func (pin *Pin) WaitEdge(timeout time.Duration) (bool, error) {
+	return false, fmt.Errorf("gpio is not available on %s", runtime.GOOS)
+}
+
+// Close -
+func (pin *Pin) Close() error {
+	return nil
+}
+
+// OpenOutput - Always fails off Linux, use a FakeOutput instead
+func OpenOutput(number int) (*OutputPin, error) {
+	return nil, fmt.Errorf("gpio is not available on %s", runtime.GOOS)
+}
+
+// Write -
+func (pin *OutputPin) Write(high bool) error {
+	return fmt.Errorf("gpio is not available on %s", runtime.GOOS)
+}
+
+// Close -
+func (pin *OutputPin) Close() error {
+	return nil
+}
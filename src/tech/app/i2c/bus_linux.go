@@ -0,0 +1,93 @@
+package i2c
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// From linux/i2c-dev.h and linux/i2c.h
+const (
+	ioctlRdwr = 0x0707
+	flagRead  = 0x0001
+
+	// maxMessage - i2c-dev refuses messages longer than 8192 bytes
+	maxMessage = 8192
+)
+
+// message - struct i2c_msg
+type message struct {
+	addr   uint16
+	flags  uint16
+	length uint16
+	buf    uintptr
+}
+
+// rdwrData - struct i2c_rdwr_ioctl_data
+type rdwrData struct {
+	msgs  uintptr
+	nmsgs uint32
+}
+
+// DevBus - A bus opened through the kernel's i2c-dev driver, e.g. /dev/i2c-1
+type DevBus struct {
+	path string
+	file *os.File
+	lock sync.Mutex
+}
+
+// Open - Opens the i2c-dev bus at 'path'
+func Open(path string) (*DevBus, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &DevBus{path: path, file: file}, nil
+}
+
+// Tx - Runs the write and read as one I2C_RDWR transfer, so the read follows a repeated
+// start rather than a stop
+func (bus *DevBus) Tx(addr uint16, write []byte, read []byte) error {
+
+	if len(write) > maxMessage || len(read) > maxMessage {
+		return Error{Addr: addr, Err: fmt.Errorf("transfer longer than %d bytes", maxMessage)}
+	}
+
+	msgs := make([]message, 0, 2)
+	if len(write) > 0 {
+		msgs = append(msgs, message{addr: addr, length: uint16(len(write)), buf: uintptr(unsafe.Pointer(&write[0]))})
+	}
+	if len(read) > 0 {
+		msgs = append(msgs, message{addr: addr, flags: flagRead, length: uint16(len(read)), buf: uintptr(unsafe.Pointer(&read[0]))})
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	data := rdwrData{msgs: uintptr(unsafe.Pointer(&msgs[0])), nmsgs: uint32(len(msgs))}
+
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, bus.file.Fd(), ioctlRdwr, uintptr(unsafe.Pointer(&data)))
+	// Keep the buffers alive until the kernel is done with them
+	runtime.KeepAlive(write)
+	runtime.KeepAlive(read)
+	runtime.KeepAlive(msgs)
+	if errno != 0 {
+		return Error{Addr: addr, Err: errno}
+	}
+	return nil
+}
+
+// Close -
+func (bus *DevBus) Close() error {
+	return bus.file.Close()
+}
+
+// String -
+func (bus *DevBus) String() string {
+	return bus.path
+}
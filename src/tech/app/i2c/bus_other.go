@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package i2c
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// DevBus - i2c-dev only exists on Linux
+type DevBus struct{}
+
+// Open - Always fails off Linux, use a Fake instead
+func Open(path string) (*DevBus, error) {
+	return nil, fmt.Errorf("i2c-dev is not available on %s", runtime.GOOS)
+}
+
+// Tx -
+func (bus *DevBus) Tx(addr uint16, write []byte, read []byte) error {
+	return fmt.Errorf("i2c-dev is not available on %s", runtime.GOOS)
+}
+
+// Close -
+func (bus *DevBus) Close() error {
+	return nil
+}
@@ -0,0 +1,93 @@
+package i2c
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Fake - In memory bus for running drivers off-device. Each device added is a bank of 256
+// registers; a write sets registers from the first byte on, a read returns registers from
+// the last register written, both incrementing as they go
+type Fake struct {
+	lock      sync.Mutex
+	registers map[uint16]*[256]byte
+	pointer   map[uint16]byte
+
+	// Writes - Every write made, in order, for checking what a driver sent
+	Writes []FakeWrite
+
+	// Fail - Returned by every transfer while set, to simulate a missing or unpowered device
+	Fail error
+}
+
+// FakeWrite - One write seen by a Fake
+type FakeWrite struct {
+	Addr uint16
+	Data []byte
+}
+
+// NewFake - A bus with a device at each of 'addrs'
+func NewFake(addrs ...uint16) *Fake {
+	fake := &Fake{registers: make(map[uint16]*[256]byte), pointer: make(map[uint16]byte)}
+	for _, addr := range addrs {
+		fake.registers[addr] = &[256]byte{}
+	}
+	return fake
+}
+
+// Tx -
+func (fake *Fake) Tx(addr uint16, write []byte, read []byte) error {
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	if fake.Fail != nil {
+		return Error{Addr: addr, Err: fake.Fail}
+	}
+	registers, ok := fake.registers[addr]
+	if !ok {
+		return Error{Addr: addr, Err: fmt.Errorf("no device")}
+	}
+
+	if len(write) > 0 {
+		fake.Writes = append(fake.Writes, FakeWrite{Addr: addr, Data: append([]byte(nil), write...)})
+		reg := write[0]
+		fake.pointer[addr] = reg
+		for _, value := range write[1:] {
+			registers[reg] = value
+			reg++
+		}
+	}
+	reg := fake.pointer[addr]
+	for i := range read {
+		read[i] = registers[reg]
+		reg++
+	}
+	return nil
+}
+
+// Close -
+func (fake *Fake) Close() error {
+	return nil
+}
+
+// Register - The current value of register 'reg' of the device at 'addr'
+func (fake *Fake) Register(addr uint16, reg byte) byte {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	if registers, ok := fake.registers[addr]; ok {
+		return registers[reg]
+	}
+	return 0
+}
+
+// SetRegister - Sets a register as the device itself would, e.g. a status flag
+func (fake *Fake) SetRegister(addr uint16, reg byte, value byte) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	if registers, ok := fake.registers[addr]; ok {
+		registers[reg] = value
+	}
+}
@@ -0,0 +1,48 @@
+package i2c
+
+import (
+	"fmt"
+)
+
+// DefaultBus - The I2C bus on the Raspberry Pi header pins
+const DefaultBus = "/dev/i2c-1"
+
+// Bus - An I2C bus. Tx writes 'write' to the device at 'addr' then, after a repeated start,
+// fills 'read' from it. Either may be empty
+type Bus interface {
+	Tx(addr uint16, write []byte, read []byte) error
+	Close() error
+}
+
+// Device - One device on a Bus, addressed by register
+type Device struct {
+	Bus  Bus
+	Addr uint16
+}
+
+// WriteReg - Writes 'data' starting at register 'reg'
+func (dev Device) WriteReg(reg byte, data ...byte) error {
+	return dev.Bus.Tx(dev.Addr, append([]byte{reg}, data...), nil)
+}
+
+// ReadReg - Reads len('data') bytes starting at register 'reg'
+func (dev Device) ReadReg(reg byte, data []byte) error {
+	return dev.Bus.Tx(dev.Addr, []byte{reg}, data)
+}
+
+// ReadRegByte - Reads the single register 'reg'
+func (dev Device) ReadRegByte(reg byte) (byte, error) {
+	data := make([]byte, 1)
+	err := dev.ReadReg(reg, data)
+	return data[0], err
+}
+
+// Error - A transfer the bus or device refused
+type Error struct {
+	Addr uint16
+	Err  error
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("i2c device 0x%02x: %v", e.Addr, e.Err)
+}
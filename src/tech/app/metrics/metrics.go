@@ -0,0 +1,284 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets - Histogram buckets in seconds, suited to HTTP and IPC latencies
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PourBuckets - Histogram buckets in seconds, suited to pour durations
+var PourBuckets = []float64{1, 2, 5, 10, 20, 30, 60, 120}
+
+// collector is implemented by every metric type
+type collector interface {
+	write(w io.Writer, namespace string)
+}
+
+// Registry - Holds metrics and renders them in the Prometheus text exposition format
+type Registry struct {
+	Namespace string
+
+	lock       sync.Mutex
+	collectors []collector
+}
+
+// Default - Registry used by the package level constructors
+var Default = &Registry{}
+
+// Init - Sets the prefix applied to every metric name in the default registry and adds the
+// Go runtime collector
+func Init(namespace string) {
+	Default.Namespace = namespace
+	Default.register(newRuntimeCollector())
+}
+
+// NewRegistry -
+func NewRegistry(namespace string) *Registry {
+	return &Registry{Namespace: namespace}
+}
+
+func (reg *Registry) register(c collector) {
+	reg.lock.Lock()
+	reg.collectors = append(reg.collectors, c)
+	reg.lock.Unlock()
+}
+
+// WriteText - Renders all registered metrics
+func (reg *Registry) WriteText(w io.Writer) {
+	reg.lock.Lock()
+	collectors := make([]collector, len(reg.collectors))
+	copy(collectors, reg.collectors)
+	reg.lock.Unlock()
+
+	for _, c := range collectors {
+		c.write(w, reg.Namespace)
+	}
+}
+
+// Text - Returns the rendered metrics of the default registry
+func Text() []byte {
+	var buf bytes.Buffer
+	Default.WriteText(&buf)
+	return buf.Bytes()
+}
+
+// vec - Common label handling for all metric types
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	lock sync.Mutex
+	keys []string
+}
+
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (v *vec) fullName(namespace string) string {
+	if namespace == "" {
+		return v.name
+	}
+	return namespace + "_" + v.name
+}
+
+func (v *vec) header(w io.Writer, name string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, v.kind)
+}
+
+// labelString - Renders the label set for 'key', 'extra' is appended as already formatted pairs
+func (v *vec) labelString(key string, extra string) string {
+	var pairs []string
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, v.labels[i]+"=\""+escapeLabel(value)+"\"")
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (v *vec) sortedKeys() []string {
+	v.lock.Lock()
+	keys := make([]string, len(v.keys))
+	copy(keys, v.keys)
+	v.lock.Unlock()
+	sort.Strings(keys)
+	return keys
+}
+
+func escapeLabel(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\"", "\\\"", -1)
+	return strings.Replace(value, "\n", "\\n", -1)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Counter - A monotonically increasing value per label set
+type Counter struct {
+	vec
+	values map[string]float64
+}
+
+// NewCounter - Creates a counter in the default registry
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{vec: vec{name: name, help: help, kind: "counter", labels: labels}, values: make(map[string]float64)}
+	Default.register(c)
+	return c
+}
+
+// Inc - Adds one to the counter for 'labelValues'
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add - Adds 'delta' to the counter for 'labelValues'
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.lock.Lock()
+	if _, ok := c.values[key]; !ok {
+		c.keys = append(c.keys, key)
+	}
+	c.values[key] += delta
+	c.lock.Unlock()
+}
+
+func (c *Counter) write(w io.Writer, namespace string) {
+	name := c.fullName(namespace)
+	c.header(w, name)
+	for _, key := range c.sortedKeys() {
+		c.lock.Lock()
+		value := c.values[key]
+		c.lock.Unlock()
+		fmt.Fprintf(w, "%s%s %s\n", name, c.labelString(key, ""), formatFloat(value))
+	}
+}
+
+// Gauge - A value per label set that can go up and down
+type Gauge struct {
+	vec
+	values map[string]float64
+}
+
+// NewGauge - Creates a gauge in the default registry
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: vec{name: name, help: help, kind: "gauge", labels: labels}, values: make(map[string]float64)}
+	Default.register(g)
+	return g
+}
+
+// Set - Sets the gauge for 'labelValues'
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	key := g.key(labelValues)
+	g.lock.Lock()
+	if _, ok := g.values[key]; !ok {
+		g.keys = append(g.keys, key)
+	}
+	g.values[key] = value
+	g.lock.Unlock()
+}
+
+// SetBool - Sets the gauge to 1 or 0
+func (g *Gauge) SetBool(value bool, labelValues ...string) {
+	if value {
+		g.Set(1, labelValues...)
+	} else {
+		g.Set(0, labelValues...)
+	}
+}
+
+func (g *Gauge) write(w io.Writer, namespace string) {
+	name := g.fullName(namespace)
+	g.header(w, name)
+	for _, key := range g.sortedKeys() {
+		g.lock.Lock()
+		value := g.values[key]
+		g.lock.Unlock()
+		fmt.Fprintf(w, "%s%s %s\n", name, g.labelString(key, ""), formatFloat(value))
+	}
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram - Counts observations into cumulative buckets per label set
+type Histogram struct {
+	vec
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+// NewHistogram - Creates a histogram in the default registry
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{vec: vec{name: name, help: help, kind: "histogram", labels: labels}, buckets: buckets, values: make(map[string]*histogramValue)}
+	Default.register(h)
+	return h
+}
+
+// Observe - Records 'value' for 'labelValues'
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+		h.keys = append(h.keys, key)
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += value
+}
+
+func (h *Histogram) write(w io.Writer, namespace string) {
+	name := h.fullName(namespace)
+	h.header(w, name)
+	for _, key := range h.sortedKeys() {
+		h.lock.Lock()
+		hv := *h.values[key]
+		counts := make([]uint64, len(hv.counts))
+		copy(counts, hv.counts)
+		h.lock.Unlock()
+
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, h.labelString(key, "le=\""+formatFloat(bound)+"\""), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, h.labelString(key, "le=\"+Inf\""), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, h.labelString(key, ""), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, h.labelString(key, ""), hv.count)
+	}
+}
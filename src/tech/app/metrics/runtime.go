@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// runtimeCollector - Samples Go runtime statistics at render time
+type runtimeCollector struct {
+	start time.Time
+}
+
+func newRuntimeCollector() *runtimeCollector {
+	return &runtimeCollector{start: time.Now()}
+}
+
+func (rc *runtimeCollector) write(w io.Writer, namespace string) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	prefix := "go_"
+	if namespace != "" {
+		prefix = namespace + "_go_"
+	}
+
+	gauge := func(name string, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s%s %s\n", prefix, name, help)
+		fmt.Fprintf(w, "# TYPE %s%s gauge\n", prefix, name)
+		fmt.Fprintf(w, "%s%s %s\n", prefix, name, formatFloat(value))
+	}
+	counter := func(name string, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s%s %s\n", prefix, name, help)
+		fmt.Fprintf(w, "# TYPE %s%s counter\n", prefix, name)
+		fmt.Fprintf(w, "%s%s %s\n", prefix, name, formatFloat(value))
+	}
+
+	gauge("goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	gauge("memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(mem.Alloc))
+	gauge("memstats_sys_bytes", "Number of bytes obtained from the system.", float64(mem.Sys))
+	gauge("memstats_heap_objects", "Number of allocated objects.", float64(mem.HeapObjects))
+	counter("memstats_mallocs_total", "Total number of mallocs.", float64(mem.Mallocs))
+	counter("gc_runs_total", "Number of completed GC cycles.", float64(mem.NumGC))
+	counter("gc_pause_seconds_total", "Total GC pause time.", float64(mem.PauseTotalNs)/1e9)
+	gauge("uptime_seconds", "Seconds since the process registered its metrics.", time.Since(rc.start).Seconds())
+}
@@ -0,0 +1,353 @@
+package nfc
+
+import (
+	"fmt"
+	"sync"
+	"tech/app/spi"
+	"time"
+)
+
+// MFRC522 registers, commands and bits from the NXP datasheet
+const (
+	rcCommand     = 0x01
+	rcComIEn      = 0x02
+	rcComIrq      = 0x04
+	rcError       = 0x06
+	rcStatus2     = 0x08
+	rcFIFOData    = 0x09
+	rcFIFOLevel   = 0x0A
+	rcControl     = 0x0C
+	rcBitFraming  = 0x0D
+	rcMode        = 0x11
+	rcTxControl   = 0x14
+	rcTxASK       = 0x15
+	rcTMode       = 0x2A
+	rcTPrescaler  = 0x2B
+	rcTReloadHigh = 0x2C
+	rcTReloadLow  = 0x2D
+	rcVersion     = 0x37
+
+	cmdIdle       = 0x00
+	cmdTransceive = 0x0C
+	cmdAuthent    = 0x0E
+	cmdSoftReset  = 0x0F
+
+	irqTimer      = 0x01
+	irqIdle       = 0x10
+	irqRx         = 0x20
+	startSend     = 0x80
+	flushFIFO     = 0x80
+	crypto1On     = 0x08
+	antennaOn     = 0x03
+	protocolError = 0x1B
+
+	// rcSpeed - Well under the chip's 10 MHz, the header wiring is rarely short
+	rcSpeed = 1000000
+
+	// rcWait - Longer than the 25 ms the timer gives the card to answer
+	rcWait = 50 * time.Millisecond
+)
+
+// ISO 14443A and MIFARE commands
+const (
+	piccWUPA     = 0x52
+	piccAnticoll = 0x20
+	piccSelect   = 0x70
+	piccHalt     = 0x50
+	piccRead     = 0x30
+	cascadeTag   = 0x88
+	sakMoreUID   = 0x04
+)
+
+var cascadeLevels = []byte{0x93, 0x95, 0x97}
+
+// MFRC522 - An NXP MFRC522 reader on SPI
+type MFRC522 struct {
+	conn spi.Conn
+	lock sync.Mutex
+}
+
+// OpenMFRC522 - Opens the reader on the spidev device at 'path'
+func OpenMFRC522(path string) (*MFRC522, error) {
+	conn, err := spi.Open(path, 0, rcSpeed)
+	if err != nil {
+		return nil, err
+	}
+	return NewMFRC522(conn)
+}
+
+// NewMFRC522 - Resets the reader on 'conn', e.g. an spi.Fake, and turns its antenna on
+func NewMFRC522(conn spi.Conn) (*MFRC522, error) {
+
+	reader := &MFRC522{conn: conn}
+	err := reader.write(rcCommand, cmdSoftReset)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	version, err := reader.read(rcVersion)
+	if err == nil && (version == 0x00 || version == 0xFF) {
+		err = fmt.Errorf("no MFRC522 answering")
+	}
+
+	// Timer at 2 kHz reloading from 50, so a silent card times out after 25 ms
+	for _, setting := range [][2]byte{
+		{rcTMode, 0x8D},
+		{rcTPrescaler, 0x3E},
+		{rcTReloadLow, 50},
+		{rcTReloadHigh, 0},
+		{rcTxASK, 0x40},
+		{rcMode, 0x3D},
+	} {
+		if err != nil {
+			break
+		}
+		err = reader.write(setting[0], setting[1])
+	}
+	if err == nil {
+		err = reader.setBits(rcTxControl, antennaOn)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// Name -
+func (reader *MFRC522) Name() string {
+	return ReaderMFRC522
+}
+
+// Detect - Wakes and selects a card, then halts it again
+func (reader *MFRC522) Detect() (Card, error) {
+
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+
+	card, err := reader.activate()
+	if err == nil {
+		reader.halt()
+	}
+	return card, err
+}
+
+// ReadBlock -
+func (reader *MFRC522) ReadBlock(card Card, block byte, keyType byte, key Key) ([]byte, error) {
+
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+
+	present, err := reader.activate()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.halt()
+	if present.UIDString() != card.UIDString() {
+		return nil, ErrNoCard
+	}
+
+	uid := card.UID[len(card.UID)-4:]
+	auth := append([]byte{keyType, block}, key[:]...)
+	_, _, err = reader.toCard(cmdAuthent, append(auth, uid...))
+	if err == nil {
+		var status byte
+		status, err = reader.read(rcStatus2)
+		if err == nil && status&crypto1On == 0 {
+			err = ErrAuth
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.clearBits(rcStatus2, crypto1On)
+
+	data, _, err := reader.toCard(cmdTransceive, crcA([]byte{piccRead, block}))
+	if err != nil {
+		return nil, err
+	}
+	err = checkFrame(data, BlockSize+2)
+	if err != nil {
+		return nil, fmt.Errorf("reading block %d: %v", block, err)
+	}
+	return data[:BlockSize], nil
+}
+
+// Close - Turns the antenna off
+func (reader *MFRC522) Close() error {
+	reader.clearBits(rcTxControl, antennaOn)
+	return reader.conn.Close()
+}
+
+// activate - Wakes a card, idle or halted, and runs the anticollision and select cascade
+func (reader *MFRC522) activate() (Card, error) {
+
+	err := reader.write(rcBitFraming, 0x07)
+	if err != nil {
+		return Card{}, err
+	}
+	atqa, bits, err := reader.toCard(cmdTransceive, []byte{piccWUPA})
+	if err != nil {
+		return Card{}, err
+	}
+	if bits != 16 {
+		return Card{}, ErrNoCard
+	}
+
+	card := Card{ATQA: uint16(atqa[0]) | uint16(atqa[1])<<8}
+	for _, level := range cascadeLevels {
+		err = reader.write(rcBitFraming, 0x00)
+		if err != nil {
+			return Card{}, err
+		}
+		uid, _, err := reader.toCard(cmdTransceive, []byte{level, piccAnticoll})
+		if err != nil {
+			return Card{}, err
+		}
+		if len(uid) != 5 || uid[0]^uid[1]^uid[2]^uid[3] != uid[4] {
+			return Card{}, fmt.Errorf("bad anticollision answer")
+		}
+
+		sak, _, err := reader.toCard(cmdTransceive, crcA(append([]byte{level, piccSelect}, uid...)))
+		if err != nil {
+			return Card{}, err
+		}
+		err = checkFrame(sak, 3)
+		if err != nil {
+			return Card{}, fmt.Errorf("bad select answer: %v", err)
+		}
+
+		card.SAK = sak[0]
+		if sak[0]&sakMoreUID == 0 {
+			card.UID = append(card.UID, uid[:4]...)
+			return card, nil
+		}
+		if uid[0] != cascadeTag {
+			return Card{}, fmt.Errorf("bad cascade tag")
+		}
+		card.UID = append(card.UID, uid[1:4]...)
+	}
+	return Card{}, fmt.Errorf("UID longer than three cascade levels")
+}
+
+// halt - Sends the card to sleep, it does not answer so any error is expected
+func (reader *MFRC522) halt() {
+	reader.toCard(cmdTransceive, crcA([]byte{piccHalt, 0x00}))
+}
+
+// toCard - Runs 'command' with 'data' in the FIFO, returning the answer and its length in
+// bits. A card that stays silent until the timer runs out gives ErrNoCard
+func (reader *MFRC522) toCard(command byte, data []byte) ([]byte, int, error) {
+
+	irqWait := byte(irqIdle)
+	if command == cmdTransceive {
+		irqWait = irqRx | irqIdle
+	}
+
+	err := reader.write(rcCommand, cmdIdle)
+	if err == nil {
+		err = reader.write(rcComIrq, 0x7F)
+	}
+	if err == nil {
+		err = reader.write(rcFIFOLevel, flushFIFO)
+	}
+	if err == nil {
+		err = reader.write(rcFIFOData, data...)
+	}
+	if err == nil {
+		err = reader.write(rcCommand, command)
+	}
+	if err == nil && command == cmdTransceive {
+		err = reader.setBits(rcBitFraming, startSend)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var irq byte
+	deadline := time.Now().Add(rcWait)
+	for {
+		irq, err = reader.read(rcComIrq)
+		if err != nil {
+			return nil, 0, err
+		}
+		if irq&irqWait != 0 {
+			break
+		}
+		if irq&irqTimer != 0 {
+			return nil, 0, ErrNoCard
+		}
+		if time.Now().After(deadline) {
+			return nil, 0, fmt.Errorf("the MFRC522 stopped responding")
+		}
+	}
+	reader.clearBits(rcBitFraming, startSend)
+
+	cardErr, err := reader.read(rcError)
+	if err != nil {
+		return nil, 0, err
+	}
+	if cardErr&protocolError != 0 {
+		return nil, 0, fmt.Errorf("card error 0x%02x", cardErr)
+	}
+	if command != cmdTransceive {
+		return nil, 0, nil
+	}
+
+	length, err := reader.read(rcFIFOLevel)
+	if err != nil || length == 0 {
+		return nil, 0, err
+	}
+	lastBits, err := reader.read(rcControl)
+	if err != nil {
+		return nil, 0, err
+	}
+	bits := int(length) * 8
+	if lastBits&0x07 != 0 {
+		bits = (int(length)-1)*8 + int(lastBits&0x07)
+	}
+
+	answer, err := reader.readFIFO(int(length))
+	return answer, bits, err
+}
+
+// Registers are addressed in bits 1 to 6 of the first byte, bit 7 set for reads
+func (reader *MFRC522) write(reg byte, data ...byte) error {
+	return reader.conn.Tx(append([]byte{(reg << 1) & 0x7E}, data...), nil)
+}
+
+func (reader *MFRC522) read(reg byte) (byte, error) {
+	buf := []byte{((reg << 1) & 0x7E) | 0x80, 0}
+	err := reader.conn.Tx(buf, buf)
+	return buf[1], err
+}
+
+// readFIFO - Reads 'length' bytes by repeating the FIFO address, the chip answers each
+// address byte with the next byte
+func (reader *MFRC522) readFIFO(length int) ([]byte, error) {
+	write := make([]byte, length+1)
+	for i := 0; i < length; i++ {
+		write[i] = ((rcFIFOData << 1) & 0x7E) | 0x80
+	}
+	read := make([]byte, length+1)
+	err := reader.conn.Tx(write, read)
+	return read[1:], err
+}
+
+func (reader *MFRC522) setBits(reg byte, mask byte) error {
+	value, err := reader.read(reg)
+	if err != nil {
+		return err
+	}
+	return reader.write(reg, value|mask)
+}
+
+func (reader *MFRC522) clearBits(reg byte, mask byte) error {
+	value, err := reader.read(reg)
+	if err != nil {
+		return err
+	}
+	return reader.write(reg, value&^mask)
+}
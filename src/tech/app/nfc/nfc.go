@@ -0,0 +1,161 @@
+package nfc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlockSize - Bytes in a MIFARE Classic block
+const BlockSize = 16
+
+// MIFARE Classic authentication key types
+const (
+	KeyA byte = 0x60
+	KeyB byte = 0x61
+)
+
+// DefaultKey - Transport key of a blank MIFARE Classic card
+var DefaultKey = Key{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+var (
+	// ErrNoCard - No card answered
+	ErrNoCard = errors.New("no card")
+
+	// ErrTimeout - Poll gave up waiting for a card
+	ErrTimeout = errors.New("timed out waiting for a card")
+
+	// ErrAuth - The card refused the key
+	ErrAuth = errors.New("authentication failed")
+)
+
+// Key - A MIFARE Classic sector key
+type Key [6]byte
+
+// Card - An ISO 14443A card in the field. SAK tells the card type, 0x08 being MIFARE
+// Classic 1K
+type Card struct {
+	UID  []byte `json:"uid"`
+	ATQA uint16 `json:"atqa"`
+	SAK  byte   `json:"sak"`
+}
+
+// UIDString - The UID as upper case hex, as userAuth enrols badges
+func (card Card) UIDString() string {
+	return strings.ToUpper(hex.EncodeToString(card.UID))
+}
+
+// Reader - A card reader. Detect checks the field once, returning ErrNoCard when it is
+// empty. ReadBlock authenticates to the block's sector with 'key' and reads it
+type Reader interface {
+	Name() string
+	Detect() (Card, error)
+	ReadBlock(card Card, block byte, keyType byte, key Key) ([]byte, error)
+	Close() error
+}
+
+// Poll - Calls Detect every 'interval' until a card arrives, an error other than ErrNoCard
+// or 'timeout'
+func Poll(reader Reader, timeout time.Duration, interval time.Duration) (Card, error) {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		card, err := reader.Detect()
+		if err != ErrNoCard {
+			return card, err
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return Card{}, ErrTimeout
+		}
+		time.Sleep(interval)
+	}
+}
+
+// crcA - ISO 14443A frame CRC, appended low byte first
+func crcA(data []byte) []byte {
+	crc := uint16(0x6363)
+	for _, b := range data {
+		b ^= byte(crc)
+		b ^= b << 4
+		crc = (crc >> 8) ^ uint16(b)<<8 ^ uint16(b)<<3 ^ uint16(b)>>4
+	}
+	return append(data, byte(crc), byte(crc>>8))
+}
+
+// Fake - In memory reader for running code off-device. Present puts a card in the field
+type Fake struct {
+	lock   sync.Mutex
+	card   *Card
+	blocks map[byte][]byte
+	key    Key
+}
+
+// NewFake - An empty field. Blocks of presented cards open with DefaultKey
+func NewFake() *Fake {
+	return &Fake{key: DefaultKey}
+}
+
+// Present - Puts a card with 'uid' in the field, holding 'blocks'
+func (fake *Fake) Present(uid []byte, blocks map[byte][]byte) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.card = &Card{UID: uid, ATQA: 0x0004, SAK: 0x08}
+	fake.blocks = blocks
+}
+
+// Remove - Takes the card out of the field
+func (fake *Fake) Remove() {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.card = nil
+}
+
+// Name -
+func (fake *Fake) Name() string {
+	return "fake"
+}
+
+// Detect -
+func (fake *Fake) Detect() (Card, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if fake.card == nil {
+		return Card{}, ErrNoCard
+	}
+	return *fake.card, nil
+}
+
+// ReadBlock -
+func (fake *Fake) ReadBlock(card Card, block byte, keyType byte, key Key) ([]byte, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if fake.card == nil || fake.card.UIDString() != card.UIDString() {
+		return nil, ErrNoCard
+	}
+	if key != fake.key {
+		return nil, ErrAuth
+	}
+	data := make([]byte, BlockSize)
+	copy(data, fake.blocks[block])
+	return data, nil
+}
+
+// Close -
+func (fake *Fake) Close() error {
+	return nil
+}
+
+// checkFrame - Fails unless 'data' is a frame of 'length' bytes with a valid CRC
+func checkFrame(data []byte, length int) error {
+	if len(data) != length {
+		return fmt.Errorf("expected %d bytes, got %d", length, len(data))
+	}
+	expected := crcA(append([]byte(nil), data[:length-2]...))
+	if expected[length-2] != data[length-2] || expected[length-1] != data[length-1] {
+		return fmt.Errorf("bad CRC")
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package nfc
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCrcA(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		// ISO/IEC 14443-3 annex B examples
+		{name: "zeros", data: []byte{0x00, 0x00}, want: []byte{0xA0, 0x1E}},
+		{name: "1234", data: []byte{0x12, 0x34}, want: []byte{0x26, 0xCF}},
+		{name: "HLTA", data: []byte{0x50, 0x00}, want: []byte{0x57, 0xCD}},
+		{name: "READ block 8", data: []byte{0x30, 0x08}, want: []byte{0x4A, 0x24}},
+		{name: "empty", data: []byte{}, want: []byte{0x63, 0x63}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame := crcA(append([]byte(nil), test.data...))
+			want := append(append([]byte(nil), test.data...), test.want...)
+			if !bytes.Equal(frame, want) {
+				t.Errorf("crcA(% X) = % X, want % X", test.data, frame, want)
+			}
+		})
+	}
+}
+
+func TestCheckFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		length int
+		ok     bool
+	}{
+		{name: "valid", data: []byte{0x50, 0x00, 0x57, 0xCD}, length: 4, ok: true},
+		{name: "bad low byte", data: []byte{0x50, 0x00, 0x56, 0xCD}, length: 4},
+		{name: "bad high byte", data: []byte{0x50, 0x00, 0x57, 0xCC}, length: 4},
+		{name: "corrupt data", data: []byte{0x50, 0x01, 0x57, 0xCD}, length: 4},
+		{name: "short", data: []byte{0x50, 0x00, 0x57}, length: 4},
+		{name: "long", data: []byte{0x50, 0x00, 0x57, 0xCD, 0x00}, length: 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkFrame(test.data, test.length)
+			if test.ok && err != nil {
+				t.Errorf("checkFrame(% X) = %v, want no error", test.data, err)
+			} else if !test.ok && err == nil {
+				t.Errorf("checkFrame(% X) succeeded, want an error", test.data)
+			}
+		})
+	}
+}
+
+// failingReader - A reader whose Detect always fails with 'err'
+type failingReader struct {
+	Fake
+	err error
+}
+
+func (reader *failingReader) Detect() (Card, error) {
+	return Card{}, reader.err
+}
+
+func TestPoll(t *testing.T) {
+
+	uid := []byte{0x04, 0xA1, 0xB2, 0xC3}
+	broken := errors.New("reader unplugged")
+
+	tests := []struct {
+		name    string
+		reader  func() Reader
+		timeout time.Duration
+		uid     string
+		err     error
+	}{
+		{
+			name: "card present",
+			reader: func() Reader {
+				fake := NewFake()
+				fake.Present(uid, nil)
+				return fake
+			},
+			timeout: time.Second,
+			uid:     "04A1B2C3",
+		},
+		{
+			name: "card arrives",
+			reader: func() Reader {
+				fake := NewFake()
+				time.AfterFunc(30*time.Millisecond, func() { fake.Present(uid, nil) })
+				return fake
+			},
+			timeout: time.Second,
+			uid:     "04A1B2C3",
+		},
+		{
+			name:    "timeout",
+			reader:  func() Reader { return NewFake() },
+			timeout: 50 * time.Millisecond,
+			err:     ErrTimeout,
+		},
+		{
+			name:    "reader error",
+			reader:  func() Reader { return &failingReader{err: broken} },
+			timeout: time.Second,
+			err:     broken,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Now()
+			card, err := Poll(test.reader(), test.timeout, 10*time.Millisecond)
+			if err != test.err {
+				t.Fatalf("Poll returned %v, want %v", err, test.err)
+			}
+			if card.UIDString() != test.uid {
+				t.Errorf("Poll read UID %q, want %q", card.UIDString(), test.uid)
+			}
+			if elapsed := time.Since(start); elapsed > test.timeout+100*time.Millisecond {
+				t.Errorf("Poll took %v, past its %v timeout", elapsed, test.timeout)
+			}
+		})
+	}
+}
+
+func TestFakeReadBlock(t *testing.T) {
+
+	fake := NewFake()
+	uid := []byte{0x11, 0x22, 0x33, 0x44}
+	fake.Present(uid, map[byte][]byte{8: {0xDE, 0xAD, 0xBE, 0xEF}})
+	card, err := fake.Detect()
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+
+	block, err := fake.ReadBlock(card, 8, KeyA, DefaultKey)
+	if err != nil {
+		t.Fatalf("ReadBlock: %v", err)
+	}
+	want := make([]byte, BlockSize)
+	copy(want, []byte{0xDE, 0xAD, 0xBE, 0xEF})
+	if !bytes.Equal(block, want) {
+		t.Errorf("ReadBlock = % X, want % X", block, want)
+	}
+
+	if _, err = fake.ReadBlock(card, 8, KeyA, Key{}); err != ErrAuth {
+		t.Errorf("ReadBlock with the wrong key returned %v, want ErrAuth", err)
+	}
+	fake.Remove()
+	if _, err = fake.ReadBlock(card, 8, KeyA, DefaultKey); err != ErrNoCard {
+		t.Errorf("ReadBlock after Remove returned %v, want ErrNoCard", err)
+	}
+}
@@ -0,0 +1,33 @@
+package nfc
+
+import (
+	"fmt"
+	"tech/app/i2c"
+	"tech/app/spi"
+)
+
+// Readers selectable with Open
+const (
+	ReaderMFRC522 = "mfrc522"
+	ReaderPN532   = "pn532"
+)
+
+// Open - Opens the 'kind' reader where the kiosk wires it, the MFRC522 on SPI0 and the
+// PN532 on the header's I2C bus
+func Open(kind string) (Reader, error) {
+	// Returned through 'reader' so a failed open gives a nil interface, not a nil pointer
+	var reader Reader
+	var err error
+	switch kind {
+	case ReaderMFRC522:
+		reader, err = OpenMFRC522(spi.DefaultDevice)
+	case ReaderPN532:
+		reader, err = OpenPN532(i2c.DefaultBus)
+	default:
+		err = fmt.Errorf("unknown card reader '%s', use %s or %s", kind, ReaderMFRC522, ReaderPN532)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
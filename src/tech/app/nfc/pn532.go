@@ -0,0 +1,238 @@
+package nfc
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"tech/app/i2c"
+	"time"
+)
+
+// DefaultPN532Address - The PN532's fixed I2C address
+const DefaultPN532Address = 0x24
+
+// PN532 commands and frame bytes from the NXP user manual
+const (
+	pnGetFirmwareVersion   = 0x02
+	pnSAMConfiguration     = 0x14
+	pnRFConfiguration      = 0x32
+	pnInDataExchange       = 0x40
+	pnInListPassiveTarget  = 0x4A
+	pnInRelease            = 0x52
+	pnHostToPN532          = 0xD4
+	pnPN532ToHost          = 0xD5
+	pnApplicationError     = 0x7F
+	pnReady                = 0x01
+	pnMaxRetries           = 0x05
+	pnBaudRate106A         = 0x00
+	pnMifareAuthError      = 0x14
+	pnMaxFrame             = 64
+	pnReadyPoll            = 5 * time.Millisecond
+	pnCommandWait          = time.Second
+	pnPassiveActivationTry = 0x02
+)
+
+var pnAck = []byte{0x00, 0x00, 0xFF, 0x00, 0xFF, 0x00}
+
+// PN532 - An NXP PN532 reader on I2C
+type PN532 struct {
+	dev  i2c.Device
+	lock sync.Mutex
+}
+
+// OpenPN532 - Opens the reader on the i2c-dev bus at 'path'
+func OpenPN532(path string) (*PN532, error) {
+	bus, err := i2c.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewPN532(bus, DefaultPN532Address)
+}
+
+// NewPN532 - Wakes the reader at 'addr' on 'bus', e.g. an i2c.Fake, and limits how long
+// each detection searches the field
+func NewPN532(bus i2c.Bus, addr uint16) (*PN532, error) {
+
+	reader := &PN532{dev: i2c.Device{Bus: bus, Addr: addr}}
+	version, err := reader.command(pnGetFirmwareVersion)
+	if err == nil && (len(version) < 4 || version[0] != 0x32) {
+		err = fmt.Errorf("no PN532 answering")
+	}
+	if err == nil {
+		// Normal mode, no virtual card timeout, IRQ pin in use
+		_, err = reader.command(pnSAMConfiguration, 0x01, 0x00, 0x01)
+	}
+	if err == nil {
+		// Give up on an empty field after a couple of tries rather than waiting forever
+		_, err = reader.command(pnRFConfiguration, pnMaxRetries, 0xFF, 0x01, pnPassiveActivationTry)
+	}
+	if err != nil {
+		bus.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// Name -
+func (reader *PN532) Name() string {
+	return ReaderPN532
+}
+
+// Detect - Lists one ISO 14443A target and releases it again
+func (reader *PN532) Detect() (Card, error) {
+
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+
+	card, _, err := reader.activate()
+	if err == nil {
+		reader.command(pnInRelease, 0x00)
+	}
+	return card, err
+}
+
+// ReadBlock -
+func (reader *PN532) ReadBlock(card Card, block byte, keyType byte, key Key) ([]byte, error) {
+
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+
+	present, target, err := reader.activate()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.command(pnInRelease, 0x00)
+	if present.UIDString() != card.UIDString() {
+		return nil, ErrNoCard
+	}
+
+	auth := append([]byte{target, keyType, block}, key[:]...)
+	status, err := reader.command(pnInDataExchange, append(auth, card.UID[len(card.UID)-4:]...)...)
+	if err != nil {
+		return nil, err
+	}
+	if len(status) == 0 || status[0] == pnMifareAuthError {
+		return nil, ErrAuth
+	}
+	if status[0] != 0 {
+		return nil, fmt.Errorf("authentication error 0x%02x", status[0])
+	}
+
+	data, err := reader.command(pnInDataExchange, target, piccRead, block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != BlockSize+1 || data[0] != 0 {
+		return nil, fmt.Errorf("reading block %d failed", block)
+	}
+	return data[1:], nil
+}
+
+// Close -
+func (reader *PN532) Close() error {
+	return reader.dev.Bus.Close()
+}
+
+// activate - Lists a target, returning the card and the target number commands address
+func (reader *PN532) activate() (Card, byte, error) {
+
+	resp, err := reader.command(pnInListPassiveTarget, 0x01, pnBaudRate106A)
+	if err != nil {
+		return Card{}, 0, err
+	}
+	if len(resp) == 0 || resp[0] == 0 {
+		return Card{}, 0, ErrNoCard
+	}
+
+	// Tg, SENS_RES, SEL_RES, NFCID length, NFCID
+	if len(resp) < 6 || len(resp) < 6+int(resp[5]) {
+		return Card{}, 0, fmt.Errorf("short target list")
+	}
+	card := Card{
+		ATQA: uint16(resp[2])<<8 | uint16(resp[3]),
+		SAK:  resp[4],
+		UID:  append([]byte(nil), resp[6:6+int(resp[5])]...)}
+	if len(card.UID) < 4 {
+		return Card{}, 0, fmt.Errorf("short UID")
+	}
+	return card, resp[1], nil
+}
+
+// command - Sends 'cmd' with 'params', waits for the acknowledgement and returns the
+// answer's data, after the command byte
+func (reader *PN532) command(cmd byte, params ...byte) ([]byte, error) {
+
+	body := append([]byte{pnHostToPN532, cmd}, params...)
+	if len(body) > 0xFF {
+		return nil, fmt.Errorf("command too long")
+	}
+	frame := []byte{0x00, 0x00, 0xFF, byte(len(body)), byte(-len(body))}
+	frame = append(frame, body...)
+	frame = append(frame, checksum(body), 0x00)
+
+	err := reader.dev.Bus.Tx(reader.dev.Addr, frame, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ack, err := reader.readFrame(len(pnAck))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(ack, pnAck) {
+		return nil, fmt.Errorf("PN532 did not acknowledge command 0x%02x", cmd)
+	}
+
+	resp, err := reader.readFrame(pnMaxFrame)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 6 || !bytes.Equal(resp[:3], pnAck[:3]) {
+		return nil, fmt.Errorf("bad PN532 frame")
+	}
+	length := int(resp[3])
+	if byte(length)+resp[4] != 0 || len(resp) < 6+length {
+		return nil, fmt.Errorf("bad PN532 frame length")
+	}
+	body = resp[5 : 5+length]
+	if checksum(body) != resp[5+length] {
+		return nil, fmt.Errorf("bad PN532 frame checksum")
+	}
+	if length == 1 && body[0] == pnApplicationError {
+		return nil, fmt.Errorf("PN532 rejected command 0x%02x", cmd)
+	}
+	if length < 2 || body[0] != pnPN532ToHost || body[1] != cmd+1 {
+		return nil, fmt.Errorf("unexpected PN532 answer to command 0x%02x", cmd)
+	}
+	return body[2:], nil
+}
+
+// readFrame - Waits for the ready bit and reads 'length' bytes. Every I2C read starts
+// with the status byte, so the frame follows it
+func (reader *PN532) readFrame(length int) ([]byte, error) {
+
+	buf := make([]byte, length+1)
+	deadline := time.Now().Add(pnCommandWait)
+	for {
+		err := reader.dev.Bus.Tx(reader.dev.Addr, nil, buf)
+		if err != nil {
+			return nil, err
+		}
+		if buf[0]&pnReady != 0 {
+			return buf[1:], nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the PN532 stopped responding")
+		}
+		time.Sleep(pnReadyPoll)
+	}
+}
+
+// checksum - Byte that brings the sum of 'data' to zero
+func checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
@@ -0,0 +1,153 @@
+package payments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mockTokenPrefix = "tok_mock_"
+	mockAuthPrefix  = "auth_mock_"
+
+	// MockDeclineCard - Tokenizes fine, every authorization against it is declined
+	MockDeclineCard = "4000000000000002"
+
+	mockDeclineMarker = "decline_"
+)
+
+// MockProvider - Development provider that never contacts a processor. Tokens describe the
+// card themselves, so they keep working after a restart, authorizations are only kept in
+// memory
+type MockProvider struct {
+	lock           sync.Mutex
+	authorizations map[string]*Authorization
+}
+
+// NewMockProvider -
+func NewMockProvider() *MockProvider {
+	return &MockProvider{authorizations: make(map[string]*Authorization)}
+}
+
+func randomID(prefix string) (string, error) {
+	raw := make([]byte, 12)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(raw), nil
+}
+
+// Name -
+func (mock *MockProvider) Name() string {
+	return ProviderMock
+}
+
+// Tokenize - Validates the card and returns a token, nothing about the card is kept
+func (mock *MockProvider) Tokenize(card Card) (CardToken, error) {
+
+	err := Validate(card, time.Now())
+	if err != nil {
+		return CardToken{}, err
+	}
+
+	prefix := mockTokenPrefix
+	if Normalize(card.Number) == MockDeclineCard {
+		prefix += mockDeclineMarker
+	}
+	token, err := randomID(prefix)
+	if err != nil {
+		return CardToken{}, err
+	}
+
+	year := card.ExpiryYear
+	if year < 100 {
+		year += 2000
+	}
+	return CardToken{
+		Token:       token,
+		Last4:       Last4(card.Number),
+		Brand:       Brand(card.Number),
+		ExpiryMonth: card.ExpiryMonth,
+		ExpiryYear:  year}, nil
+}
+
+// Authorize - Holds 'amount' against a mock token
+func (mock *MockProvider) Authorize(token string, amount int64, currency string) (Authorization, error) {
+
+	if !strings.HasPrefix(token, mockTokenPrefix) {
+		return Authorization{}, fmt.Errorf("unknown payment token")
+	}
+	if amount <= 0 {
+		return Authorization{}, fmt.Errorf("amount must be positive")
+	}
+	if strings.HasPrefix(token, mockTokenPrefix+mockDeclineMarker) {
+		return Authorization{Token: token, Amount: amount, Currency: currency, Status: StatusDeclined}, ErrDeclined
+	}
+
+	id, err := randomID(mockAuthPrefix)
+	if err != nil {
+		return Authorization{}, err
+	}
+	auth := &Authorization{ID: id, Token: token, Amount: amount, Currency: strings.ToUpper(currency), Status: StatusAuthorized}
+
+	mock.lock.Lock()
+	mock.authorizations[id] = auth
+	mock.lock.Unlock()
+
+	return *auth, nil
+}
+
+// Capture - Takes up to the authorized amount, once
+func (mock *MockProvider) Capture(authorizationID string, amount int64) (Authorization, error) {
+
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+
+	auth, ok := mock.authorizations[authorizationID]
+	if !ok {
+		return Authorization{}, fmt.Errorf("unknown authorization '%s'", authorizationID)
+	}
+	if auth.Status != StatusAuthorized {
+		return *auth, fmt.Errorf("authorization is %s", auth.Status)
+	}
+	if amount <= 0 || amount > auth.Amount {
+		return *auth, fmt.Errorf("capture must be between 1 and %d", auth.Amount)
+	}
+
+	auth.Captured = amount
+	auth.Status = StatusCaptured
+	return *auth, nil
+}
+
+// Refund - Returns captured money, or releases an authorization that was never captured
+func (mock *MockProvider) Refund(authorizationID string, amount int64) (Authorization, error) {
+
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+
+	auth, ok := mock.authorizations[authorizationID]
+	if !ok {
+		return Authorization{}, fmt.Errorf("unknown authorization '%s'", authorizationID)
+	}
+
+	switch auth.Status {
+	case StatusAuthorized:
+		auth.Status = StatusRefunded
+	case StatusCaptured:
+		if amount <= 0 || amount > auth.Captured-auth.Refunded {
+			return *auth, fmt.Errorf("refund must be between 1 and %d", auth.Captured-auth.Refunded)
+		}
+		auth.Refunded += amount
+		if auth.Refunded == auth.Captured {
+			auth.Status = StatusRefunded
+		}
+	default:
+		return *auth, fmt.Errorf("authorization is %s", auth.Status)
+	}
+
+	return *auth, nil
+}
@@ -0,0 +1,164 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Providers selectable with New
+const (
+	ProviderMock = "mock"
+)
+
+// Card brands reported by Brand
+const (
+	BrandVisa       = "visa"
+	BrandMastercard = "mastercard"
+	BrandAmex       = "amex"
+	BrandDiscover   = "discover"
+	BrandUnknown    = "unknown"
+)
+
+// Authorization states
+const (
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusRefunded   = "refunded"
+	StatusDeclined   = "declined"
+)
+
+// ErrDeclined - The provider refused the payment
+var ErrDeclined = errors.New("payment declined")
+
+// Card - Card details as entered by the user. They are only ever handed to a provider,
+// never stored
+type Card struct {
+	Number      string
+	ExpiryMonth int
+	ExpiryYear  int
+	CVV         string
+	Name        string
+}
+
+// CardToken - What the device keeps of a card once the provider has it
+type CardToken struct {
+	Token       string `json:"token"`
+	Last4       string `json:"last4"`
+	Brand       string `json:"brand"`
+	ExpiryMonth int    `json:"expiryMonth"`
+	ExpiryYear  int    `json:"expiryYear"`
+}
+
+// Authorization - A hold on funds, captured once the drink has been poured or released by
+// a refund. Amounts are in the currency's minor unit, e.g. cents
+type Authorization struct {
+	ID       string `json:"id"`
+	Token    string `json:"token"`
+	Amount   int64  `json:"amount"`
+	Captured int64  `json:"captured"`
+	Refunded int64  `json:"refunded"`
+	Currency string `json:"currency"`
+	Status   string `json:"status"`
+}
+
+// PaymentProvider - A card processor. Only Tokenize ever sees the card number and CVV
+type PaymentProvider interface {
+	Name() string
+	Tokenize(card Card) (CardToken, error)
+	Authorize(token string, amount int64, currency string) (Authorization, error)
+	Capture(authorizationID string, amount int64) (Authorization, error)
+	Refund(authorizationID string, amount int64) (Authorization, error)
+}
+
+// New - Creates the 'name' provider
+func New(name string) (PaymentProvider, error) {
+	switch name {
+	case ProviderMock:
+		return NewMockProvider(), nil
+	}
+	return nil, fmt.Errorf("unknown payment provider '%s', use %s", name, ProviderMock)
+}
+
+// Normalize - Strips the spaces and dashes people type between groups of digits
+func Normalize(number string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(number))
+}
+
+// Brand - Identifies the card scheme from the number's prefix
+func Brand(number string) string {
+	number = Normalize(number)
+	switch {
+	case strings.HasPrefix(number, "4"):
+		return BrandVisa
+	case strings.HasPrefix(number, "34"), strings.HasPrefix(number, "37"):
+		return BrandAmex
+	case strings.HasPrefix(number, "6011"), strings.HasPrefix(number, "65"):
+		return BrandDiscover
+	case len(number) >= 2 && number[:2] >= "51" && number[:2] <= "55":
+		return BrandMastercard
+	case len(number) >= 4 && number[:4] >= "2221" && number[:4] <= "2720":
+		return BrandMastercard
+	}
+	return BrandUnknown
+}
+
+// Last4 - The only digits of a card number that may be shown or kept
+func Last4(number string) string {
+	number = Normalize(number)
+	if len(number) < 4 {
+		return ""
+	}
+	return number[len(number)-4:]
+}
+
+// luhn - Checks the number's check digit
+func luhn(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// Validate - Checks the card number, expiry and CVV look right before they are sent to a
+// provider. Two digit years are taken as 20xx. The CVV may be left out, as when a card is
+// saved without the customer present
+func Validate(card Card, now time.Time) error {
+
+	number := Normalize(card.Number)
+	if len(number) < 12 || len(number) > 19 || strings.Trim(number, "0123456789") != "" || !luhn(number) {
+		return fmt.Errorf("invalid card number")
+	}
+
+	year := card.ExpiryYear
+	if year < 100 {
+		year += 2000
+	}
+	if card.ExpiryMonth < 1 || card.ExpiryMonth > 12 {
+		return fmt.Errorf("invalid expiry month")
+	}
+	if year < now.Year() || (year == now.Year() && card.ExpiryMonth < int(now.Month())) {
+		return fmt.Errorf("card has expired")
+	}
+
+	cvvLength := 3
+	if Brand(number) == BrandAmex {
+		cvvLength = 4
+	}
+	if card.CVV != "" && (len(card.CVV) != cvvLength || strings.Trim(card.CVV, "0123456789") != "") {
+		return fmt.Errorf("CVV must be %d digits", cvvLength)
+	}
+
+	return nil
+}
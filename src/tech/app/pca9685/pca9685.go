@@ -0,0 +1,172 @@
+package pca9685
+
+import (
+	"fmt"
+	"math"
+	"tech/app/i2c"
+	"time"
+)
+
+// DefaultAddress - Address of a board with no address jumpers bridged
+const DefaultAddress = 0x40
+
+// Channels - PWM outputs on the chip
+const Channels = 16
+
+// Registers and bits from the PCA9685 datasheet
+const (
+	regMode1     = 0x00
+	regMode2     = 0x01
+	regLed0OnL   = 0x06
+	regAllLedOnL = 0xFA
+	regPrescale  = 0xFE
+
+	mode1Restart = 0x80
+	mode1AutoInc = 0x20
+	mode1Sleep   = 0x10
+	mode1AllCall = 0x01
+	mode2OutDrv  = 0x04
+
+	// fullBit - Set in the high byte of ON or OFF to hold the output fully on or off
+	fullBit = 0x10
+
+	// oscillator - Internal clock, Hz
+	oscillator = 25000000.0
+
+	// Steps - Resolution of each PWM period
+	Steps = 4096
+
+	minPrescale = 3
+	maxPrescale = 255
+
+	// oscillatorStart - Time the oscillator needs after leaving sleep
+	oscillatorStart = 500 * time.Microsecond
+)
+
+// MinFrequency and MaxFrequency - PWM frequencies the prescaler can reach
+var (
+	MinFrequency = oscillator / (Steps * (maxPrescale + 1))
+	MaxFrequency = oscillator / (Steps * (minPrescale + 1))
+)
+
+// Device - A PCA9685 16 channel, 12 bit PWM controller
+type Device struct {
+	dev       i2c.Device
+	frequency float64
+}
+
+// New - Resets the chip at 'addr' on 'bus' to totem pole outputs, all off, at 'frequency' Hz
+func New(bus i2c.Bus, addr uint16, frequency float64) (*Device, error) {
+
+	pwm := &Device{dev: i2c.Device{Bus: bus, Addr: addr}}
+
+	// Auto increment first, so the four byte all-off write lands in four registers
+	err := pwm.dev.WriteReg(regMode1, mode1AutoInc|mode1AllCall)
+	if err == nil {
+		err = pwm.dev.WriteReg(regMode2, mode2OutDrv)
+	}
+	if err == nil {
+		err = pwm.AllOff()
+	}
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(oscillatorStart)
+
+	err = pwm.SetFrequency(frequency)
+	if err != nil {
+		return nil, err
+	}
+	return pwm, nil
+}
+
+// prescale - Prescaler value for 'frequency', see datasheet section 7.3.5
+func prescale(frequency float64) (byte, error) {
+	if frequency < MinFrequency || frequency > MaxFrequency {
+		return 0, fmt.Errorf("frequency must be %.0f to %.0f Hz", MinFrequency, MaxFrequency)
+	}
+	value := math.Round(oscillator/(Steps*frequency)) - 1
+	return byte(math.Max(minPrescale, math.Min(maxPrescale, value))), nil
+}
+
+// SetFrequency - Sets the PWM frequency of every channel. The prescaler can only be written
+// while the oscillator sleeps, so outputs pause briefly
+func (pwm *Device) SetFrequency(frequency float64) error {
+
+	value, err := prescale(frequency)
+	if err != nil {
+		return err
+	}
+	mode1, err := pwm.dev.ReadRegByte(regMode1)
+	if err != nil {
+		return err
+	}
+	mode1 &^= mode1Restart
+
+	err = pwm.dev.WriteReg(regMode1, mode1|mode1Sleep)
+	if err == nil {
+		err = pwm.dev.WriteReg(regPrescale, value)
+	}
+	if err == nil {
+		err = pwm.dev.WriteReg(regMode1, mode1&^mode1Sleep)
+	}
+	if err != nil {
+		return err
+	}
+	time.Sleep(oscillatorStart)
+
+	err = pwm.dev.WriteReg(regMode1, (mode1&^mode1Sleep)|mode1Restart|mode1AutoInc)
+	if err != nil {
+		return err
+	}
+	pwm.frequency = oscillator / (Steps * (float64(value) + 1))
+	return nil
+}
+
+// Frequency - The PWM frequency set, as the prescaler rounds it
+func (pwm *Device) Frequency() float64 {
+	return pwm.frequency
+}
+
+// SetPWM - Turns 'channel' on at step 'on' and off at step 'off' of each period, 0 to 4095
+func (pwm *Device) SetPWM(channel int, on uint16, off uint16) error {
+	if channel < 0 || channel >= Channels {
+		return fmt.Errorf("channel must be 0 to %d", Channels-1)
+	}
+	if on >= Steps || off >= Steps {
+		return fmt.Errorf("on and off must be 0 to %d", Steps-1)
+	}
+	return pwm.dev.WriteReg(regLed0OnL+byte(4*channel), byte(on), byte(on>>8), byte(off), byte(off>>8))
+}
+
+// SetPulse - Holds 'channel' high for 'width' at the start of each period, the way servos
+// and ESCs are driven. A zero width turns the channel fully off
+func (pwm *Device) SetPulse(channel int, width time.Duration) error {
+
+	if pwm.frequency == 0 {
+		return fmt.Errorf("frequency is not set")
+	}
+	if width == 0 {
+		return pwm.Off(channel)
+	}
+	period := time.Duration(float64(time.Second) / pwm.frequency)
+	if width < 0 || width >= period {
+		return fmt.Errorf("pulse must be shorter than the %v period", period)
+	}
+
+	steps := math.Round(float64(width) / float64(period) * Steps)
+	return pwm.SetPWM(channel, 0, uint16(math.Min(steps, Steps-1)))
+}
+
+// Off - Holds 'channel' low
+func (pwm *Device) Off(channel int) error {
+	if channel < 0 || channel >= Channels {
+		return fmt.Errorf("channel must be 0 to %d", Channels-1)
+	}
+	return pwm.dev.WriteReg(regLed0OnL+byte(4*channel), 0, 0, 0, fullBit)
+}
+
+// AllOff - Holds every channel low with a single write, for stopping everything at once
+func (pwm *Device) AllOff() error {
+	return pwm.dev.WriteReg(regAllLedOnL, 0, 0, 0, fullBit)
+}
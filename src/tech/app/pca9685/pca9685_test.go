@@ -0,0 +1,215 @@
+package pca9685
+
+import (
+	"bytes"
+	"fmt"
+	"tech/app/i2c"
+	"testing"
+	"time"
+)
+
+// newFake - A device at the default address on a fake bus, with the writes New made
+// cleared
+func newFake(t *testing.T, frequency float64) (*Device, *i2c.Fake) {
+	t.Helper()
+
+	bus := i2c.NewFake(DefaultAddress)
+	pwm, err := New(bus, DefaultAddress, frequency)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	bus.Writes = nil
+	return pwm, bus
+}
+
+func checkWrites(t *testing.T, bus *i2c.Fake, want [][]byte) {
+	t.Helper()
+
+	if len(bus.Writes) != len(want) {
+		t.Fatalf("got %d writes %v, want %d %v", len(bus.Writes), bus.Writes, len(want), want)
+	}
+	for i, write := range bus.Writes {
+		if write.Addr != DefaultAddress {
+			t.Errorf("write %d went to 0x%02x, want 0x%02x", i, write.Addr, DefaultAddress)
+		}
+		if !bytes.Equal(write.Data, want[i]) {
+			t.Errorf("write %d is % x, want % x", i, write.Data, want[i])
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+
+	bus := i2c.NewFake(DefaultAddress)
+	pwm, err := New(bus, DefaultAddress, 60)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	checkWrites(t, bus, [][]byte{
+		{regMode1, mode1AutoInc | mode1AllCall},
+		{regMode2, mode2OutDrv},
+		{regAllLedOnL, 0, 0, 0, fullBit},
+		// SetFrequency reads MODE1 back first
+		{regMode1},
+		{regMode1, mode1AutoInc | mode1AllCall | mode1Sleep},
+		{regPrescale, 101},
+		{regMode1, mode1AutoInc | mode1AllCall},
+		{regMode1, mode1Restart | mode1AutoInc | mode1AllCall},
+	})
+	if got := bus.Register(DefaultAddress, regPrescale); got != 101 {
+		t.Errorf("prescale register is %d, want 101", got)
+	}
+	if frequency := pwm.Frequency(); frequency < 59.8 || frequency > 59.9 {
+		t.Errorf("frequency is %.3f Hz, want 59.838", frequency)
+	}
+}
+
+func TestNewMissingDevice(t *testing.T) {
+	bus := i2c.NewFake()
+	if _, err := New(bus, DefaultAddress, 60); err == nil {
+		t.Fatal("New succeeded without a device on the bus")
+	}
+}
+
+func TestPrescale(t *testing.T) {
+	tests := []struct {
+		frequency float64
+		want      byte
+		fails     bool
+	}{
+		{frequency: 50, want: 121},
+		{frequency: 60, want: 101},
+		{frequency: 1000, want: 5},
+		{frequency: MinFrequency, want: maxPrescale},
+		{frequency: MaxFrequency, want: minPrescale},
+		{frequency: MinFrequency - 1, fails: true},
+		{frequency: MaxFrequency + 1, fails: true},
+	}
+	for _, test := range tests {
+		got, err := prescale(test.frequency)
+		if test.fails {
+			if err == nil {
+				t.Errorf("prescale(%.1f) = %d, want an error", test.frequency, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("prescale(%.1f) = %d, %v, want %d", test.frequency, got, err, test.want)
+		}
+	}
+}
+
+// TestPour - The press, release and off writes the native actuator makes for one cycle of
+// a pump on channel 2
+func TestPour(t *testing.T) {
+
+	pwm, bus := newFake(t, 60)
+	led2 := byte(regLed0OnL + 4*2)
+
+	steps := []struct {
+		width time.Duration
+		off   uint16
+	}{
+		{width: 610 * time.Microsecond, off: 150},
+		{width: 2440 * time.Microsecond, off: 598},
+	}
+	for _, step := range steps {
+		err := pwm.SetPulse(2, step.width)
+		if err != nil {
+			t.Fatalf("SetPulse(2, %v): %v", step.width, err)
+		}
+	}
+	err := pwm.Off(2)
+	if err != nil {
+		t.Fatalf("Off(2): %v", err)
+	}
+
+	checkWrites(t, bus, [][]byte{
+		{led2, 0, 0, byte(steps[0].off), byte(steps[0].off >> 8)},
+		{led2, 0, 0, byte(steps[1].off), byte(steps[1].off >> 8)},
+		{led2, 0, 0, 0, fullBit},
+	})
+	if got := bus.Register(DefaultAddress, led2+3); got != fullBit {
+		t.Errorf("LED2_OFF_H is 0x%02x, want the full off bit", got)
+	}
+}
+
+func TestAllOff(t *testing.T) {
+
+	pwm, bus := newFake(t, 60)
+	for channel := 0; channel < 3; channel++ {
+		err := pwm.SetPulse(channel, time.Millisecond)
+		if err != nil {
+			t.Fatalf("SetPulse(%d): %v", channel, err)
+		}
+	}
+	bus.Writes = nil
+
+	err := pwm.AllOff()
+	if err != nil {
+		t.Fatalf("AllOff: %v", err)
+	}
+	checkWrites(t, bus, [][]byte{{regAllLedOnL, 0, 0, 0, fullBit}})
+}
+
+func TestSetPWM(t *testing.T) {
+
+	pwm, bus := newFake(t, 60)
+	tests := []struct {
+		channel int
+		on, off uint16
+		want    []byte
+	}{
+		{channel: 0, on: 0, off: 2048, want: []byte{regLed0OnL, 0x00, 0x00, 0x00, 0x08}},
+		{channel: 15, on: 100, off: 4095, want: []byte{regLed0OnL + 60, 0x64, 0x00, 0xFF, 0x0F}},
+		{channel: -1, off: 1},
+		{channel: Channels, off: 1},
+		{channel: 0, on: Steps},
+		{channel: 0, off: Steps},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d/%d/%d", test.channel, test.on, test.off), func(t *testing.T) {
+			bus.Writes = nil
+			err := pwm.SetPWM(test.channel, test.on, test.off)
+			if test.want == nil {
+				if err == nil {
+					t.Fatal("SetPWM succeeded, want an error")
+				}
+				checkWrites(t, bus, nil)
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetPWM: %v", err)
+			}
+			checkWrites(t, bus, [][]byte{test.want})
+		})
+	}
+}
+
+func TestSetPulseLimits(t *testing.T) {
+
+	pwm, bus := newFake(t, 60)
+	for _, width := range []time.Duration{-time.Millisecond, 17 * time.Millisecond} {
+		if err := pwm.SetPulse(0, width); err == nil {
+			t.Errorf("SetPulse(0, %v) succeeded, want an error", width)
+		}
+	}
+	checkWrites(t, bus, nil)
+
+	// A zero width is fully off rather than a zero step pulse
+	err := pwm.SetPulse(0, 0)
+	if err != nil {
+		t.Fatalf("SetPulse(0, 0): %v", err)
+	}
+	checkWrites(t, bus, [][]byte{{regLed0OnL, 0, 0, 0, fullBit}})
+}
+
+func TestBusFailure(t *testing.T) {
+
+	pwm, bus := newFake(t, 60)
+	bus.Fail = fmt.Errorf("unpowered")
+	if err := pwm.AllOff(); err == nil {
+		t.Fatal("AllOff succeeded on a failing bus")
+	}
+}
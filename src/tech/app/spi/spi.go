@@ -0,0 +1,46 @@
+package spi
+
+import (
+	"sync"
+)
+
+// DefaultDevice - SPI0 with chip select 0 on the Raspberry Pi header
+const DefaultDevice = "/dev/spidev0.0"
+
+// Conn - A full duplex SPI connection to one device. Tx clocks out 'write' while filling
+// 'read', which must be as long as 'write' or nil
+type Conn interface {
+	Tx(write []byte, read []byte) error
+	Close() error
+}
+
+// Fake - In memory connection for running drivers off-device. Respond is called with each
+// transfer and returns what the device clocks back
+type Fake struct {
+	lock sync.Mutex
+
+	// Respond - Answers a transfer, nil reads back zeros
+	Respond func(write []byte) []byte
+
+	// Writes - Every transfer made, in order
+	Writes [][]byte
+}
+
+// Tx -
+func (fake *Fake) Tx(write []byte, read []byte) error {
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	fake.Writes = append(fake.Writes, append([]byte(nil), write...))
+	if fake.Respond == nil || read == nil {
+		return nil
+	}
+	copy(read, fake.Respond(write))
+	return nil
+}
+
+// Close -
+func (fake *Fake) Close() error {
+	return nil
+}
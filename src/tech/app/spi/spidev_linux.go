@@ -0,0 +1,103 @@
+package spi
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// From linux/spi/spidev.h
+const (
+	ioctlMessage1   = 0x40206B00
+	ioctlWrMode     = 0x40016B01
+	ioctlWrBits     = 0x40016B03
+	ioctlWrMaxSpeed = 0x40046B04
+
+	// maxTransfer - spidev's default buffer size
+	maxTransfer = 4096
+)
+
+// transfer - struct spi_ioc_transfer
+type transfer struct {
+	txBuf       uint64
+	rxBuf       uint64
+	length      uint32
+	speedHz     uint32
+	delayUsecs  uint16
+	bitsPerWord uint8
+	csChange    uint8
+	txNbits     uint8
+	rxNbits     uint8
+	wordDelay   uint8
+	pad         uint8
+}
+
+// DevConn - A device opened through the kernel's spidev driver, e.g. /dev/spidev0.0
+type DevConn struct {
+	file    *os.File
+	speedHz uint32
+}
+
+// Open - Opens the spidev device at 'path' in SPI 'mode' 0 to 3, clocked at 'speedHz'
+func Open(path string, mode uint8, speedHz uint32) (*DevConn, error) {
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	bits := uint8(8)
+	for _, setting := range []struct {
+		request uintptr
+		value   unsafe.Pointer
+	}{
+		{ioctlWrMode, unsafe.Pointer(&mode)},
+		{ioctlWrBits, unsafe.Pointer(&bits)},
+		{ioctlWrMaxSpeed, unsafe.Pointer(&speedHz)},
+	} {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), setting.request, uintptr(setting.value))
+		if errno != 0 {
+			file.Close()
+			return nil, fmt.Errorf("configuring %s: %v", path, errno)
+		}
+	}
+
+	return &DevConn{file: file, speedHz: speedHz}, nil
+}
+
+// Tx -
+func (conn *DevConn) Tx(write []byte, read []byte) error {
+
+	if len(write) == 0 {
+		return nil
+	}
+	if len(write) > maxTransfer {
+		return fmt.Errorf("transfer longer than %d bytes", maxTransfer)
+	}
+	if read != nil && len(read) != len(write) {
+		return fmt.Errorf("read and write must be the same length")
+	}
+
+	xfer := transfer{
+		txBuf:       uint64(uintptr(unsafe.Pointer(&write[0]))),
+		length:      uint32(len(write)),
+		speedHz:     conn.speedHz,
+		bitsPerWord: 8}
+	if read != nil {
+		xfer.rxBuf = uint64(uintptr(unsafe.Pointer(&read[0])))
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, conn.file.Fd(), ioctlMessage1, uintptr(unsafe.Pointer(&xfer)))
+	runtime.KeepAlive(write)
+	runtime.KeepAlive(read)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Close -
+func (conn *DevConn) Close() error {
+	return conn.file.Close()
+}
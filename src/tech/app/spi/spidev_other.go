@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package spi
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// DevConn - spidev only exists on Linux
+type DevConn struct{}
+
+// Open - Always fails off Linux, use a Fake instead
+func Open(path string, mode uint8, speedHz uint32) (*DevConn, error) {
+	return nil, fmt.Errorf("spidev is not available on %s", runtime.GOOS)
+}
+
+// Tx -
+func (conn *DevConn) Tx(write []byte, read []byte) error {
+	return fmt.Errorf("spidev is not available on %s", runtime.GOOS)
+}
+
+// Close -
+func (conn *DevConn) Close() error {
+	return nil
+}
@@ -0,0 +1,315 @@
+package update
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	manifestName  = "manifest.json"
+	signatureName = "manifest.sig"
+	filesPrefix   = "files/"
+
+	// maxManifestSize - Upper bound on the manifest and signature entries
+	maxManifestSize = 1048576
+)
+
+// Manifest - Describes the contents of an update bundle
+type Manifest struct {
+	Version string         `json:"version"`
+	Files   []ManifestFile `json:"files"`
+}
+
+// ManifestFile - A single file carried by an update bundle, path is relative to the slot root
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+	Mode   uint32 `json:"mode"`
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// LoadPublicKey - Reads a PEM encoded ECDSA public key used to verify bundles
+func LoadPublicKey(path string) (*ecdsa.PublicKey, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key in %s is not an ECDSA key", path)
+	}
+
+	return ecKey, nil
+}
+
+// VerifyManifest - Checks an ASN.1 encoded ECDSA signature over the raw manifest bytes
+func VerifyManifest(key *ecdsa.PublicKey, manifest []byte, signature []byte) error {
+
+	var sig ecdsaSignature
+	rest, err := asn1.Unmarshal(signature, &sig)
+	if err != nil {
+		return fmt.Errorf("malformed bundle signature, %v", err)
+	}
+	if len(rest) != 0 || sig.R == nil || sig.S == nil {
+		return fmt.Errorf("malformed bundle signature")
+	}
+
+	digest := sha256.Sum256(manifest)
+	if !ecdsa.Verify(key, digest[:], sig.R, sig.S) {
+		return fmt.Errorf("bundle signature does not match manifest")
+	}
+
+	return nil
+}
+
+// ExtractBundle - Unpacks the gzipped tar bundle at 'bundlePath' into 'dest'. The manifest
+// signature is verified before anything is written, and the hash of every file as it is
+// extracted. 'dest' is removed if verification fails.
+func ExtractBundle(bundlePath string, dest string, key *ecdsa.PublicKey) (*Manifest, error) {
+
+	manifest, err := extractBundle(bundlePath, dest, key)
+	if err != nil {
+		os.RemoveAll(dest)
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// bundleReader - Walks the entries of a gzipped tar bundle
+type bundleReader struct {
+	file *os.File
+	gz   *gzip.Reader
+	*tar.Reader
+}
+
+func openBundle(bundlePath string) (*bundleReader, error) {
+
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("bundle is not gzip compressed, %v", err)
+	}
+
+	return &bundleReader{file: file, gz: gz, Reader: tar.NewReader(gz)}, nil
+}
+
+func (bundle *bundleReader) Close() {
+	bundle.gz.Close()
+	bundle.file.Close()
+}
+
+// readManifest - First pass over the bundle, returning the manifest once its signature is
+// verified. File contents are skipped
+func readManifest(bundlePath string, key *ecdsa.PublicKey) (*Manifest, []byte, error) {
+
+	bundle, err := openBundle(bundlePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer bundle.Close()
+
+	var manifestData []byte
+	var signature []byte
+	for {
+		hdr, err := bundle.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("corrupt bundle, %v", err)
+		}
+
+		switch strings.TrimPrefix(hdr.Name, "./") {
+		case manifestName:
+			manifestData, err = ioutil.ReadAll(io.LimitReader(bundle, maxManifestSize))
+
+		case signatureName:
+			signature, err = ioutil.ReadAll(io.LimitReader(bundle, maxManifestSize))
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if manifestData == nil || signature == nil {
+		return nil, nil, fmt.Errorf("bundle is missing %s or %s", manifestName, signatureName)
+	}
+
+	err = VerifyManifest(key, manifestData, signature)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(manifestData, &manifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed manifest, %v", err)
+	}
+
+	return &manifest, manifestData, nil
+}
+
+func extractBundle(bundlePath string, dest string, key *ecdsa.PublicKey) (*Manifest, error) {
+
+	manifest, manifestData, err := readManifest(bundlePath, key)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make(map[string]ManifestFile)
+	for _, entry := range manifest.Files {
+		relPath, err := cleanRelPath(entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		listed[relPath] = entry
+	}
+
+	bundle, err := openBundle(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer bundle.Close()
+
+	err = os.RemoveAll(dest)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dest, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	extracted := make(map[string]bool)
+	for {
+		hdr, err := bundle.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("corrupt bundle, %v", err)
+		}
+
+		name := strings.TrimPrefix(hdr.Name, "./")
+		switch {
+		case hdr.Typeflag == tar.TypeDir, name == manifestName, name == signatureName:
+			continue
+
+		case strings.HasPrefix(name, filesPrefix) && hdr.Typeflag == tar.TypeReg:
+			relPath, err := cleanRelPath(strings.TrimPrefix(name, filesPrefix))
+			if err != nil {
+				return nil, err
+			}
+			entry, ok := listed[relPath]
+			if !ok || extracted[relPath] {
+				return nil, fmt.Errorf("bundle file '%s' not listed in manifest", relPath)
+			}
+			hash, err := writeFile(filepath.Join(dest, relPath), bundle)
+			if err != nil {
+				return nil, err
+			}
+			if !strings.EqualFold(hash, entry.Sha256) {
+				return nil, fmt.Errorf("hash mismatch on '%s'", entry.Path)
+			}
+			extracted[relPath] = true
+
+			mode := os.FileMode(entry.Mode).Perm()
+			if mode == 0 {
+				mode = 0644
+			}
+			err = os.Chmod(filepath.Join(dest, relPath), mode)
+			if err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected bundle entry '%s'", hdr.Name)
+		}
+	}
+
+	for relPath, entry := range listed {
+		if !extracted[relPath] {
+			return nil, fmt.Errorf("manifest file '%s' missing from bundle", entry.Path)
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dest, manifestName), manifestData, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// cleanRelPath - Rejects absolute paths and paths escaping the slot root
+func cleanRelPath(path string) (string, error) {
+
+	clean := filepath.Clean(filepath.FromSlash(path))
+	if clean == "." || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid bundle path '%s'", path)
+	}
+
+	return clean, nil
+}
+
+func writeFile(path string, src io.Reader) (string, error) {
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), src)
+	if err != nil {
+		return "", err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,239 @@
+package update
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bundleEntry - A tar entry written into a test bundle as given
+type bundleEntry struct {
+	name string
+	data string
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, data []byte) []byte {
+	t.Helper()
+
+	digest := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	signature, err := asn1.Marshal(ecdsaSignature{R: r, S: s})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return signature
+}
+
+func sha(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeBundle - Writes a bundle to 'path' holding 'manifest' signed by 'key' and 'entries'
+func writeBundle(t *testing.T, path string, key *ecdsa.PrivateKey, manifest Manifest, entries []bundleEntry) {
+	t.Helper()
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries = append([]bundleEntry{
+		{name: manifestName, data: string(manifestData)},
+		{name: signatureName, data: string(sign(t, key, manifestData))},
+	}, entries...)
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data)), Typeflag: tar.TypeReg}
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write([]byte(entry.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractBundle(t *testing.T) {
+
+	key := newKey(t)
+	host := "host binary"
+	files := []ManifestFile{
+		{Path: "Host", Sha256: sha(host), Mode: 0755},
+		{Path: "www/index.html", Sha256: sha("<html>"), Mode: 0},
+	}
+	contents := []bundleEntry{
+		{name: "files/Host", data: host},
+		{name: "./files/www/index.html", data: "<html>"},
+	}
+
+	tests := []struct {
+		name     string
+		key      *ecdsa.PrivateKey
+		files    []ManifestFile
+		contents []bundleEntry
+		ok       bool
+	}{
+		{name: "valid", key: key, files: files, contents: contents, ok: true},
+		{name: "bad signature", key: newKey(t), files: files, contents: contents},
+		{
+			name:     "not in manifest",
+			key:      key,
+			files:    files,
+			contents: append(append([]bundleEntry{}, contents...), bundleEntry{name: "files/extra", data: "x"}),
+		},
+		{name: "missing from bundle", key: key, files: files, contents: contents[:1]},
+		{
+			name:     "hash mismatch",
+			key:      key,
+			files:    files,
+			contents: []bundleEntry{{name: "files/Host", data: "tampered"}, contents[1]},
+		},
+		{
+			name:     "listed twice",
+			key:      key,
+			files:    files,
+			contents: append(append([]bundleEntry{}, contents...), contents[0]),
+		},
+		{
+			name:     "manifest escapes the slot",
+			key:      key,
+			files:    []ManifestFile{{Path: "../escaped", Sha256: sha("x")}},
+			contents: []bundleEntry{{name: "files/../escaped", data: "x"}},
+		},
+		{
+			name:     "absolute manifest path",
+			key:      key,
+			files:    []ManifestFile{{Path: "/escaped", Sha256: sha("x")}},
+			contents: []bundleEntry{{name: "files//escaped", data: "x"}},
+		},
+		{
+			name:     "entry escapes the slot",
+			key:      key,
+			files:    []ManifestFile{{Path: "escaped", Sha256: sha("x")}},
+			contents: []bundleEntry{{name: "files/../../escaped", data: "x"}},
+		},
+		{
+			name:     "entry outside files",
+			key:      key,
+			files:    files,
+			contents: append(append([]bundleEntry{}, contents...), bundleEntry{name: "escaped", data: "x"}),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "bundle")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			bundlePath := filepath.Join(root, "bundle.tar.gz")
+			dest := filepath.Join(root, "slots", "b")
+			writeBundle(t, bundlePath, test.key, Manifest{Version: "2.0", Files: test.files}, test.contents)
+
+			manifest, err := ExtractBundle(bundlePath, dest, &key.PublicKey)
+			if _, statErr := os.Stat(filepath.Join(root, "escaped")); statErr == nil {
+				t.Error("a file was written outside the slot")
+			}
+			if !test.ok {
+				if err == nil {
+					t.Fatal("ExtractBundle succeeded, want an error")
+				}
+				if _, statErr := os.Stat(dest); !os.IsNotExist(statErr) {
+					t.Errorf("dest was left behind after the error %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ExtractBundle: %v", err)
+			}
+			if manifest.Version != "2.0" {
+				t.Errorf("manifest version is %q, want 2.0", manifest.Version)
+			}
+			data, err := ioutil.ReadFile(filepath.Join(dest, "Host"))
+			if err != nil || string(data) != host {
+				t.Errorf("Host is %q, %v, want %q", data, err, host)
+			}
+			info, err := os.Stat(filepath.Join(dest, "Host"))
+			if err != nil || info.Mode().Perm() != 0755 {
+				t.Errorf("Host mode is %v, %v, want 0755", info.Mode().Perm(), err)
+			}
+			info, err = os.Stat(filepath.Join(dest, "www", "index.html"))
+			if err != nil || info.Mode().Perm() != 0644 {
+				t.Errorf("index.html mode is %v, %v, want the 0644 default", info.Mode().Perm(), err)
+			}
+			if version := slotVersion(dest); version != "2.0" {
+				t.Errorf("slot version is %q, want the manifest to be kept", version)
+			}
+		})
+	}
+}
+
+func TestCleanRelPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{path: "Host", want: "Host", ok: true},
+		{path: "www/js/app.js", want: filepath.Join("www", "js", "app.js"), ok: true},
+		{path: "www/../Host", want: "Host", ok: true},
+		{path: "./Host", want: "Host", ok: true},
+		{path: "..foo", want: "..foo", ok: true},
+		{path: ""},
+		{path: "."},
+		{path: ".."},
+		{path: "../Host"},
+		{path: "www/../../Host"},
+		{path: "/etc/passwd"},
+		{path: "//Host"},
+	}
+	for _, test := range tests {
+		got, err := cleanRelPath(test.path)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("cleanRelPath(%q) = %q, want an error", test.path, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("cleanRelPath(%q) = %q, %v, want %q", test.path, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package update
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// DefaultSlotRoot - Directory holding the A/B install slots. The init script launches
+	// the Host and Server from DefaultSlotRoot/current
+	DefaultSlotRoot = "/data/slots"
+
+	// MaxBootAttempts - Number of times a pending slot may be started before it is rolled back
+	MaxBootAttempts = 3
+
+	slotA       = "a"
+	slotB       = "b"
+	currentLink = "current"
+	stateFile   = "state.json"
+)
+
+// State - Persisted description of the installed slots
+type State struct {
+	Active        string `json:"active"`
+	Previous      string `json:"previous"`
+	Version       string `json:"version"`
+	Pending       bool   `json:"pending"`
+	Attempts      int    `json:"attempts"`
+	FailedVersion string `json:"failedVersion"`
+}
+
+// Slots - Manages the A/B install slots under a root directory
+type Slots struct {
+	Root string
+
+	lock sync.Mutex
+}
+
+// NewSlots - Returns a slot manager rooted at 'root'
+func NewSlots(root string) *Slots {
+	return &Slots{Root: root}
+}
+
+// State - Returns the current slot state
+func (slots *Slots) State() (State, error) {
+
+	slots.lock.Lock()
+	defer slots.lock.Unlock()
+
+	return slots.loadState()
+}
+
+// Stage - Verifies the bundle at 'bundlePath' and unpacks it into the inactive slot
+func (slots *Slots) Stage(bundlePath string, key *ecdsa.PublicKey) (*Manifest, error) {
+
+	slots.lock.Lock()
+	defer slots.lock.Unlock()
+
+	state, err := slots.loadState()
+	if err != nil {
+		return nil, err
+	}
+	if state.Pending {
+		return nil, fmt.Errorf("slot '%s' has not been confirmed healthy yet", state.Active)
+	}
+
+	return ExtractBundle(bundlePath, slots.slotPath(otherSlot(state.Active)), key)
+}
+
+// Switch - Atomically makes the inactive slot current. The new slot stays pending until
+// MarkHealthy is called after a restart
+func (slots *Slots) Switch(manifest *Manifest) error {
+
+	slots.lock.Lock()
+	defer slots.lock.Unlock()
+
+	state, err := slots.loadState()
+	if err != nil {
+		return err
+	}
+
+	target := otherSlot(state.Active)
+	_, err = os.Stat(filepath.Join(slots.slotPath(target), manifestName))
+	if err != nil {
+		return fmt.Errorf("slot '%s' is not staged, %v", target, err)
+	}
+
+	state.Previous = state.Active
+	state.Active = target
+	state.Version = manifest.Version
+	state.Pending = true
+	state.Attempts = 0
+
+	err = slots.saveState(state)
+	if err != nil {
+		return err
+	}
+
+	return slots.setCurrent(target)
+}
+
+// Boot - Must be called once at Host startup. Counts start attempts of a pending slot and
+// rolls back once MaxBootAttempts is exceeded. Returns true if a rollback was performed
+func (slots *Slots) Boot() (bool, error) {
+
+	slots.lock.Lock()
+	defer slots.lock.Unlock()
+
+	state, err := slots.loadState()
+	if err != nil || !state.Pending {
+		return false, err
+	}
+
+	state.Attempts++
+	if state.Attempts > MaxBootAttempts {
+		return true, slots.rollback(state)
+	}
+
+	return false, slots.saveState(state)
+}
+
+// MarkHealthy - Confirms the active slot, it will no longer be rolled back
+func (slots *Slots) MarkHealthy() error {
+
+	slots.lock.Lock()
+	defer slots.lock.Unlock()
+
+	state, err := slots.loadState()
+	if err != nil || !state.Pending {
+		return err
+	}
+
+	state.Pending = false
+	state.Attempts = 0
+
+	return slots.saveState(state)
+}
+
+// Rollback - Returns to the previously active slot
+func (slots *Slots) Rollback() error {
+
+	slots.lock.Lock()
+	defer slots.lock.Unlock()
+
+	state, err := slots.loadState()
+	if err != nil {
+		return err
+	}
+
+	return slots.rollback(state)
+}
+
+func (slots *Slots) rollback(state State) error {
+
+	if state.Previous == "" {
+		return fmt.Errorf("no previous slot to roll back to")
+	}
+
+	state.FailedVersion = state.Version
+	state.Version = slotVersion(slots.slotPath(state.Previous))
+	state.Active, state.Previous = state.Previous, state.Active
+	state.Pending = false
+	state.Attempts = 0
+
+	err := slots.saveState(state)
+	if err != nil {
+		return err
+	}
+
+	return slots.setCurrent(state.Active)
+}
+
+func (slots *Slots) loadState() (State, error) {
+
+	var state State
+
+	data, err := ioutil.ReadFile(filepath.Join(slots.Root, stateFile))
+	if os.IsNotExist(err) {
+		// First run, trust whatever the current link points at
+		state.Active = slotA
+		target, err := os.Readlink(filepath.Join(slots.Root, currentLink))
+		if err == nil && filepath.Base(target) == slotB {
+			state.Active = slotB
+		}
+		state.Version = slotVersion(slots.slotPath(state.Active))
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return state, fmt.Errorf("corrupt slot state, %v", err)
+	}
+	if state.Active != slotA && state.Active != slotB {
+		return state, fmt.Errorf("corrupt slot state, unknown active slot '%s'", state.Active)
+	}
+
+	return state, nil
+}
+
+func (slots *Slots) saveState(state State) error {
+
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(slots.Root, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Join(slots.Root, stateFile+".tmp")
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filepath.Join(slots.Root, stateFile))
+}
+
+// setCurrent - Repoints the current link using rename so it is never missing
+func (slots *Slots) setCurrent(slot string) error {
+
+	tmpLink := filepath.Join(slots.Root, currentLink+".tmp")
+	os.Remove(tmpLink)
+
+	err := os.Symlink(slot, tmpLink)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpLink, filepath.Join(slots.Root, currentLink))
+}
+
+func (slots *Slots) slotPath(slot string) string {
+	return filepath.Join(slots.Root, slot)
+}
+
+func otherSlot(slot string) string {
+	if slot == slotA {
+		return slotB
+	}
+	return slotA
+}
+
+func slotVersion(slotPath string) string {
+
+	data, err := ioutil.ReadFile(filepath.Join(slotPath, manifestName))
+	if err != nil {
+		return ""
+	}
+
+	var manifest Manifest
+	if json.Unmarshal(data, &manifest) != nil {
+		return ""
+	}
+
+	return manifest.Version
+}
@@ -0,0 +1,143 @@
+package update
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestSlots - Slots in a temporary root with version 1.0 installed in slot a and a
+// signed 2.0 bundle staged into slot b and switched to
+func newTestSlots(t *testing.T) (*Slots, string) {
+	t.Helper()
+
+	root, err := ioutil.TempDir("", "slots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slots := NewSlots(filepath.Join(root, "slots"))
+	err = os.MkdirAll(slots.slotPath(slotA), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(slots.slotPath(slotA), manifestName), []byte(`{"version": "1.0"}`), 0644)
+	}
+	if err == nil {
+		err = slots.setCurrent(slotA)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := newKey(t)
+	bundlePath := filepath.Join(root, "bundle.tar.gz")
+	writeBundle(t, bundlePath, key, Manifest{Version: "2.0", Files: []ManifestFile{{Path: "Host", Sha256: sha("2.0")}}},
+		[]bundleEntry{{name: "files/Host", data: "2.0"}})
+
+	manifest, err := slots.Stage(bundlePath, &key.PublicKey)
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	err = slots.Switch(manifest)
+	if err != nil {
+		t.Fatalf("Switch: %v", err)
+	}
+	checkSlot(t, slots, State{Active: slotB, Previous: slotA, Version: "2.0", Pending: true})
+
+	// A second update cannot be staged over the slot to fall back to
+	if _, err = slots.Stage(bundlePath, &key.PublicKey); err == nil {
+		t.Error("Stage succeeded while the new slot was pending")
+	}
+
+	return slots, root
+}
+
+// checkSlot - Compares the saved state with 'want' and checks the current link follows it
+func checkSlot(t *testing.T, slots *Slots, want State) {
+	t.Helper()
+
+	state, err := slots.State()
+	if err != nil {
+		t.Fatalf("State: %v", err)
+	}
+	if state != want {
+		t.Errorf("state is %+v, want %+v", state, want)
+	}
+	target, err := os.Readlink(filepath.Join(slots.Root, currentLink))
+	if err != nil || target != want.Active {
+		t.Errorf("current links to %q, %v, want %q", target, err, want.Active)
+	}
+}
+
+func TestBootRollsBack(t *testing.T) {
+
+	slots, root := newTestSlots(t)
+	defer os.RemoveAll(root)
+
+	for attempt := 1; attempt <= MaxBootAttempts; attempt++ {
+		rolledBack, err := slots.Boot()
+		if err != nil || rolledBack {
+			t.Fatalf("boot %d returned %v, %v, want no rollback", attempt, rolledBack, err)
+		}
+		checkSlot(t, slots, State{Active: slotB, Previous: slotA, Version: "2.0", Pending: true, Attempts: attempt})
+	}
+
+	rolledBack, err := slots.Boot()
+	if err != nil || !rolledBack {
+		t.Fatalf("boot %d returned %v, %v, want a rollback", MaxBootAttempts+1, rolledBack, err)
+	}
+	checkSlot(t, slots, State{Active: slotA, Previous: slotB, Version: "1.0", FailedVersion: "2.0"})
+
+	// Nothing is pending after the rollback, later boots leave it alone
+	rolledBack, err = slots.Boot()
+	if err != nil || rolledBack {
+		t.Errorf("boot after the rollback returned %v, %v, want nothing done", rolledBack, err)
+	}
+}
+
+func TestMarkHealthyKeepsSlot(t *testing.T) {
+
+	slots, root := newTestSlots(t)
+	defer os.RemoveAll(root)
+
+	if _, err := slots.Boot(); err != nil {
+		t.Fatalf("Boot: %v", err)
+	}
+	if err := slots.MarkHealthy(); err != nil {
+		t.Fatalf("MarkHealthy: %v", err)
+	}
+	for attempt := 0; attempt <= MaxBootAttempts; attempt++ {
+		rolledBack, err := slots.Boot()
+		if err != nil || rolledBack {
+			t.Fatalf("boot %d of a healthy slot returned %v, %v", attempt, rolledBack, err)
+		}
+	}
+	checkSlot(t, slots, State{Active: slotB, Previous: slotA, Version: "2.0"})
+
+	// Admins can still go back by hand
+	if err := slots.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	checkSlot(t, slots, State{Active: slotA, Previous: slotB, Version: "1.0", FailedVersion: "2.0"})
+}
+
+func TestFirstRunState(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "slots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	slots := NewSlots(root)
+
+	// Without a state file the current link decides, and there is nothing to roll back to
+	if err = slots.setCurrent(slotB); err != nil {
+		t.Fatal(err)
+	}
+	checkSlot(t, slots, State{Active: slotB})
+	if rolledBack, err := slots.Boot(); err != nil || rolledBack {
+		t.Errorf("first Boot returned %v, %v, want nothing done", rolledBack, err)
+	}
+	if err = slots.Rollback(); err == nil {
+		t.Error("Rollback succeeded without a previous slot")
+	}
+}
@@ -15,6 +15,7 @@ require (
 	github.com/stratoberry/go-gpsd v0.0.0-20161204231141-54ddcfa61f47
 	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
 	go.bug.st/serial v1.1.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/sys v0.0.0-20191128015809-6d18c012aee9
 	gonum.org/v1/gonum v0.7.0
 )
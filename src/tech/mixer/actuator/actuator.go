@@ -0,0 +1,174 @@
+package actuator
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Backends selectable with New
+const (
+	BackendScript    = "script"
+	BackendNative    = "native"
+	BackendSimulator = "sim"
+)
+
+// Actuator states
+const (
+	StateIdle    = "idle"
+	StatePouring = "pouring"
+	StateMixing  = "mixing"
+)
+
+// MixChannel - Output driving the mixing motor, after the six ingredient channels
+const MixChannel = 6
+
+// ErrStopped - Returned by a pour or mix cut short by Stop, or refused until Reset
+var ErrStopped = errors.New("stopped")
+
+// Actuator - Drives the pumps and mixer. A cycle is one press and release of a channel's
+// pump, the unit pours are calibrated in. Stop latches, nothing runs again until Reset
+type Actuator interface {
+	Name() string
+	Pour(channel int, cycles int) error
+	Mix() error
+	Stop() error
+	Reset() error
+	Status() Status
+}
+
+// Status - What an actuator is doing. Channel is -1 unless pouring, Fault is the last error
+// and clears on the next successful run
+type Status struct {
+	Backend string `json:"backend"`
+	State   string `json:"state"`
+	Channel int    `json:"channel"`
+	Cycle   int    `json:"cycle"`
+	Cycles  int    `json:"cycles"`
+	Fault   string `json:"fault"`
+}
+
+// New - Creates the actuator for 'backend'
+func New(backend string) (Actuator, error) {
+	switch backend {
+	case BackendScript, "":
+		return NewScript(DefaultScript), nil
+	case BackendNative:
+		return NewNative(DefaultBus)
+	case BackendSimulator:
+		return NewSimulator(), nil
+	}
+	return nil, fmt.Errorf("unknown actuator '%s', use %s, %s or %s", backend, BackendScript, BackendNative, BackendSimulator)
+}
+
+func checkChannel(channel int) error {
+	if channel < 0 || channel >= MixChannel {
+		return fmt.Errorf("no channel %d", channel)
+	}
+	return nil
+}
+
+// motion - Status and stop signal shared by the backends. Stopped latches with the first
+// halt and is only cleared by Reset
+type motion struct {
+	lock    sync.Mutex
+	status  Status
+	stop    chan struct{}
+	stopped bool
+}
+
+func newMotion(backend string) motion {
+	return motion{status: Status{Backend: backend, State: StateIdle, Channel: -1}}
+}
+
+// begin - Marks the start of a pour or mix, refusing one while another runs or a stop is
+// latched. The returned channel closes when Stop is called
+func (m *motion) begin(state string, channel int, cycles int) (<-chan struct{}, error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.stopped {
+		return nil, ErrStopped
+	}
+	if m.status.State != StateIdle {
+		return nil, fmt.Errorf("actuator is busy %s", m.status.State)
+	}
+	m.status.State = state
+	m.status.Channel = channel
+	m.status.Cycle = 0
+	m.status.Cycles = cycles
+	m.stop = make(chan struct{})
+	return m.stop, nil
+}
+
+// progress - Records the cycle under way
+func (m *motion) progress(cycle int) {
+	m.lock.Lock()
+	m.status.Cycle = cycle
+	m.lock.Unlock()
+}
+
+// end - Returns to idle, recording 'err' as the fault unless the run was stopped
+func (m *motion) end(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.status.State = StateIdle
+	m.status.Channel = -1
+	m.status.Cycle = 0
+	m.status.Cycles = 0
+	m.stop = nil
+	if err == nil {
+		m.status.Fault = ""
+	} else if err != ErrStopped {
+		m.status.Fault = err.Error()
+	}
+}
+
+// halt - Signals the running pour or mix to stop and latches the stop
+func (m *motion) halt() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.stopped = true
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+}
+
+// latched - Whether a stop is latched
+func (m *motion) latched() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stopped
+}
+
+// Reset - Clears a latched stop so pours and mixes can run again
+func (m *motion) Reset() error {
+	m.lock.Lock()
+	m.stopped = false
+	m.lock.Unlock()
+	return nil
+}
+
+func (m *motion) snapshot() Status {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.status
+}
+
+// wait - Sleeps for 'duration', or returns ErrStopped as soon as 'stop' closes
+func wait(duration time.Duration, stop <-chan struct{}) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-stop:
+		return ErrStopped
+	}
+}
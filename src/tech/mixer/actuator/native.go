@@ -0,0 +1,110 @@
+package actuator
+
+import (
+	"tech/app/i2c"
+	"tech/app/pca9685"
+	"time"
+)
+
+// DefaultBus - I2C bus the PWM board is wired to
+const DefaultBus = i2c.DefaultBus
+
+// Servo timing, matching the motor script
+const (
+	servoFrequency = 60
+	pressPulse     = 610 * time.Microsecond
+	releasePulse   = 2440 * time.Microsecond
+	pressTime      = 1 * time.Second
+	releaseTime    = 2 * time.Second
+)
+
+// Native - Drives the pump servos on a PCA9685 directly
+type Native struct {
+	motion
+
+	pwm *pca9685.Device
+}
+
+// NewNative - Opens the PWM board on the i2c-dev bus at 'bus'
+func NewNative(bus string) (*Native, error) {
+	dev, err := i2c.Open(bus)
+	if err != nil {
+		return nil, err
+	}
+	return NewNativeOn(dev, pca9685.DefaultAddress)
+}
+
+// NewNativeOn - Drives the PWM board at 'addr' on an already open bus, e.g. an i2c.Fake
+func NewNativeOn(bus i2c.Bus, addr uint16) (*Native, error) {
+	pwm, err := pca9685.New(bus, addr, servoFrequency)
+	if err != nil {
+		bus.Close()
+		return nil, err
+	}
+	return &Native{motion: newMotion(BackendNative), pwm: pwm}, nil
+}
+
+// Name -
+func (act *Native) Name() string {
+	return BackendNative
+}
+
+// Pour - Presses and releases the pump on 'channel' 'cycles' times
+func (act *Native) Pour(channel int, cycles int) error {
+	err := checkChannel(channel)
+	if err != nil {
+		return err
+	}
+	stop, err := act.begin(StatePouring, channel, cycles)
+	if err != nil {
+		return err
+	}
+	err = act.cycle(stop, channel, cycles)
+	act.end(err)
+	return err
+}
+
+// Mix - Runs the mixing motor once
+func (act *Native) Mix() error {
+	stop, err := act.begin(StateMixing, MixChannel, 1)
+	if err != nil {
+		return err
+	}
+	err = act.cycle(stop, MixChannel, 1)
+	act.end(err)
+	return err
+}
+
+func (act *Native) cycle(stop <-chan struct{}, channel int, cycles int) error {
+
+	defer act.pwm.Off(channel)
+
+	for cycle := 1; cycle <= cycles; cycle++ {
+		act.progress(cycle)
+		err := act.pwm.SetPulse(channel, pressPulse)
+		if err == nil {
+			err = wait(pressTime, stop)
+		}
+		if err == nil {
+			err = act.pwm.SetPulse(channel, releasePulse)
+		}
+		if err == nil {
+			err = wait(releaseTime, stop)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop - Cuts every output at once and ends the running pour
+func (act *Native) Stop() error {
+	act.halt()
+	return act.pwm.AllOff()
+}
+
+// Status -
+func (act *Native) Status() Status {
+	return act.snapshot()
+}
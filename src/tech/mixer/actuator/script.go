@@ -0,0 +1,169 @@
+package actuator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"tech/app/logger"
+	"time"
+)
+
+const (
+	// stopGrace - Time the script gets to turn its outputs off after SIGTERM before it is killed
+	stopGrace = 500 * time.Millisecond
+
+	// allOffTimeout - Time the all-off command gets to load the PWM library and run
+	allOffTimeout = 10 * time.Second
+)
+
+// DefaultScript - The motor script shipped next to the Host binary
+const DefaultScript = "./scripts/motor_control.py"
+
+// Script - Runs the python motor script once per pour or mix
+type Script struct {
+	motion
+
+	path    string
+	cmdLock sync.Mutex
+	cmd     *exec.Cmd
+}
+
+// NewScript - Bridge to the motor script at 'path'
+func NewScript(path string) *Script {
+	return &Script{motion: newMotion(BackendScript), path: path}
+}
+
+// Name -
+func (act *Script) Name() string {
+	return BackendScript
+}
+
+// Pour - Runs the script for 'cycles' cycles of 'channel'
+func (act *Script) Pour(channel int, cycles int) error {
+	err := checkChannel(channel)
+	if err != nil {
+		return err
+	}
+	stop, err := act.begin(StatePouring, channel, cycles)
+	if err != nil {
+		return err
+	}
+	err = act.run(stop, strconv.Itoa(channel), strconv.Itoa(cycles))
+	act.end(err)
+	return err
+}
+
+// Mix - Runs the script's mix sequence
+func (act *Script) Mix() error {
+	stop, err := act.begin(StateMixing, MixChannel, 1)
+	if err != nil {
+		return err
+	}
+	err = act.run(stop, "mix", "0")
+	act.end(err)
+	return err
+}
+
+func (act *Script) run(stop <-chan struct{}, target string, amount string) error {
+
+	var out bytes.Buffer
+	cmd := exec.Command("python3", act.path, target, amount)
+	cmd.Stdout = &out
+
+	// Started under the lock Stop takes, so a stop either finds the process to signal or
+	// has latched before it could start
+	act.cmdLock.Lock()
+	if act.latched() {
+		act.cmdLock.Unlock()
+		return ErrStopped
+	}
+	err := cmd.Start()
+	if err == nil {
+		act.cmd = cmd
+	}
+	act.cmdLock.Unlock()
+	if err != nil {
+		return fmt.Errorf("motor script: %v", err)
+	}
+
+	err = cmd.Wait()
+
+	act.cmdLock.Lock()
+	act.cmd = nil
+	act.cmdLock.Unlock()
+
+	logger.LogDebug("%s", out.Bytes())
+	select {
+	case <-stop:
+		return ErrStopped
+	default:
+	}
+	if err != nil {
+		return fmt.Errorf("motor script: %v", err)
+	}
+	return nil
+}
+
+// Stop - Asks a running script to turn every output off and exit. A script that has not
+// within stopGrace is killed, and the script is then run again to turn the outputs off, as
+// it is when nothing was running
+func (act *Script) Stop() error {
+	act.halt()
+
+	act.cmdLock.Lock()
+	cmd := act.cmd
+	act.cmdLock.Unlock()
+	if cmd == nil {
+		return act.allOff()
+	}
+
+	err := cmd.Process.Signal(syscall.SIGTERM)
+	if err != nil {
+		cmd.Process.Kill()
+		return act.allOff()
+	}
+	time.AfterFunc(stopGrace, func() {
+		act.cmdLock.Lock()
+		killed := act.cmd == cmd
+		if killed {
+			logger.Log("Motor script ignored SIGTERM, killing it")
+			cmd.Process.Kill()
+		}
+		act.cmdLock.Unlock()
+		if killed {
+			err := act.allOff()
+			if err != nil {
+				logger.Log("Failed to turn the outputs off, %v", err)
+			}
+		}
+	})
+	return nil
+}
+
+// allOff - Runs the script's all-off command, within allOffTimeout
+func (act *Script) allOff() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), allOffTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "python3", act.path, "off", "0").CombinedOutput()
+	logger.LogDebug("%s", out)
+	if err != nil {
+		return fmt.Errorf("motor script all-off: %v", err)
+	}
+	return nil
+}
+
+// Status - Reports a fault while the script is missing
+func (act *Script) Status() Status {
+	status := act.snapshot()
+	if _, err := os.Stat(act.path); err != nil && status.Fault == "" {
+		status.Fault = err.Error()
+	}
+	return status
+}
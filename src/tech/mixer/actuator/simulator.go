@@ -0,0 +1,82 @@
+package actuator
+
+import (
+	"tech/app/logger"
+	"time"
+)
+
+// Simulator - Stands in for the hardware on a laptop. Pours take as long as the real pumps
+// and are logged instead of driving anything
+type Simulator struct {
+	motion
+
+	// CycleTime - Time one pump cycle takes
+	CycleTime time.Duration
+
+	// OnCycle - Called after each completed pump cycle, e.g. to feed a simulated sensor
+	OnCycle func(channel int)
+}
+
+// NewSimulator -
+func NewSimulator() *Simulator {
+	return &Simulator{motion: newMotion(BackendSimulator), CycleTime: pressTime + releaseTime}
+}
+
+// Name -
+func (act *Simulator) Name() string {
+	return BackendSimulator
+}
+
+// Pour - Waits out 'cycles' cycles on 'channel'
+func (act *Simulator) Pour(channel int, cycles int) error {
+	err := checkChannel(channel)
+	if err != nil {
+		return err
+	}
+	stop, err := act.begin(StatePouring, channel, cycles)
+	if err != nil {
+		return err
+	}
+	logger.Log("Simulating %d cycles on channel %d", cycles, channel)
+	err = act.run(stop, channel, cycles)
+	act.end(err)
+	return err
+}
+
+// Mix - Waits out one mixing cycle
+func (act *Simulator) Mix() error {
+	stop, err := act.begin(StateMixing, MixChannel, 1)
+	if err != nil {
+		return err
+	}
+	logger.Log("Simulating mix")
+	err = act.run(stop, MixChannel, 1)
+	act.end(err)
+	return err
+}
+
+func (act *Simulator) run(stop <-chan struct{}, channel int, cycles int) error {
+	for cycle := 1; cycle <= cycles; cycle++ {
+		act.progress(cycle)
+		err := wait(act.CycleTime, stop)
+		if err != nil {
+			logger.Log("Simulated run stopped at cycle %d of %d", cycle, cycles)
+			return err
+		}
+		if act.OnCycle != nil && channel != MixChannel {
+			act.OnCycle(channel)
+		}
+	}
+	return nil
+}
+
+// Stop -
+func (act *Simulator) Stop() error {
+	act.halt()
+	return nil
+}
+
+// Status -
+func (act *Simulator) Status() Status {
+	return act.snapshot()
+}
@@ -40,6 +40,10 @@ func NewCfgService(dbPath string) (*CfgService, error) {
 
 	database, err := sql.Open("sqlite3_with_hooks", dbPath)
 	cfg.database = database
+	if err == nil {
+		// Components writing from their own goroutines would otherwise get SQLITE_BUSY
+		database.SetMaxOpenConns(1)
+	}
 
 	return &cfg, err
 }
@@ -88,6 +92,25 @@ func (cfg *CfgService) Initialize() {
 	}
 }
 
+// Health - Confirms the database can be opened and its schema read
+func (cfg *CfgService) Health() error {
+
+	if cfg.database == nil {
+		return fmt.Errorf("database not open")
+	}
+
+	var tables int
+	err := cfg.database.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table'").Scan(&tables)
+	if err != nil {
+		return err
+	}
+	if tables == 0 {
+		return fmt.Errorf("database has no tables")
+	}
+
+	return nil
+}
+
 // CreateTable - Generate the 'target' table with 'schema' columns
 func (cfg *CfgService) CreateTable(tableName string, schema []string) error {
 
@@ -158,14 +181,61 @@ func (cfg *CfgService) GetUser(tableName string, username string) (map[string]in
 	return data, err
 }
 
+// Exec - Runs a statement with bound arguments, returning the id of the last inserted row
+func (cfg *CfgService) Exec(query string, args ...interface{}) (int64, error) {
+
+	result, err := cfg.database.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// AddColumn - Adds 'column', e.g. "role TEXT", to an existing table unless it is already there
+func (cfg *CfgService) AddColumn(tableName string, column string) error {
+
+	exists, err := cfg.HasColumn(tableName, strings.Fields(column)[0])
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = cfg.database.Exec("ALTER TABLE " + tableName + " ADD COLUMN " + column)
+	return err
+}
+
+// HasColumn - Reports whether 'tableName' has a column called 'name'
+func (cfg *CfgService) HasColumn(tableName string, name string) (bool, error) {
+
+	columns, err := queryRows(cfg.database, "PRAGMA table_info("+tableName+")")
+	if err != nil {
+		return false, err
+	}
+	for _, existing := range columns {
+		if existing["name"] == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Query - Runs a query with bound arguments, returning each row as a map of column to value
+func (cfg *CfgService) Query(query string, args ...interface{}) ([]map[string]interface{}, error) {
+
+	return queryRows(cfg.database, query, args...)
+}
+
 func set(database *DB, target string, data map[string]interface{}) (map[string]interface{}, error) {
 
 	// Generate query to initialize Table 'target'
 	var colValString []string
+	var args []interface{}
 	for col, value := range data {
 		switch value.(type) {
 		case (string):
-			colValString = append(colValString, col+"='"+value.(string)+"'")
+			colValString = append(colValString, col+"=?")
+			args = append(args, value)
 
 		case (bool):
 			if value.(bool) {
@@ -192,7 +262,7 @@ func set(database *DB, target string, data map[string]interface{}) (map[string]i
 		return nil, err
 	}
 
-	_, err = statement.Exec()
+	_, err = statement.Exec(args...)
 	if err != nil {
 		return nil, err
 	}
@@ -209,10 +279,12 @@ func setUser(database *DB, target string, username string, data map[string]inter
 
 	// Generate query to initialize Table 'target'
 	var colValString []string
+	var args []interface{}
 	for col, value := range data {
 		switch value.(type) {
 		case (string):
-			colValString = append(colValString, col+"='"+value.(string)+"'")
+			colValString = append(colValString, col+"=?")
+			args = append(args, value)
 
 		case (bool):
 			if value.(bool) {
@@ -233,13 +305,13 @@ func setUser(database *DB, target string, username string, data map[string]inter
 		}
 	}
 
-	query := "UPDATE " + target + " SET " + strings.Join(colValString, ",") + " WHERE username='" + username + "'"
+	query := "UPDATE " + target + " SET " + strings.Join(colValString, ",") + " WHERE username=?"
 	statement, err := database.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = statement.Exec()
+	_, err = statement.Exec(append(args, username)...)
 	if err != nil {
 		return nil, err
 	}
@@ -293,9 +365,9 @@ func get(database *DB, target string) (map[string]interface{}, error) {
 func getUser(database *DB, target string, username string) (map[string]interface{}, error) {
 
 	// Query database, store in 'row'
-	query := "SELECT * FROM " + target + " WHERE username='" + username + "'"
+	query := "SELECT * FROM " + target + " WHERE username=?"
 
-	row, err := database.Query(query)
+	row, err := database.Query(query, username)
 	defer row.Close()
 	if err != nil {
 		return nil, err
@@ -328,6 +400,41 @@ func getUser(database *DB, target string, username string) (map[string]interface
 	return data, err
 }
 
+func queryRows(database *DB, query string, args ...interface{}) ([]map[string]interface{}, error) {
+
+	row, err := database.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	dataColumns, err := row.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []map[string]interface{}
+	for row.Next() {
+		dataValues := make([]interface{}, len(dataColumns))
+		for i := range dataValues {
+			dataValues[i] = new(interface{})
+		}
+
+		err = row.Scan(dataValues...)
+		if err != nil {
+			return nil, err
+		}
+
+		data := make(map[string]interface{}, len(dataColumns))
+		for i, column := range dataColumns {
+			data[column] = *(dataValues[i].(*interface{}))
+		}
+		rows = append(rows, data)
+	}
+
+	return rows, row.Err()
+}
+
 func createTable(database *DB, tableName string, schema []string) error {
 
 	// Assembles a query string to create a table 'target' with columns 'schema'
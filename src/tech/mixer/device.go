@@ -3,9 +3,13 @@ package mixer
 import (
 	"fmt"
 	"os/exec"
+	"strings"
 	"tech/app/comms"
 	"tech/app/components"
+	"tech/app/events"
 	"tech/app/logger"
+	"tech/app/metrics"
+	"tech/app/update"
 	"tech/mixer/config"
 )
 
@@ -14,6 +18,11 @@ const (
 	DatabaseName = "/data/config.db"
 )
 
+var (
+	actionCount  = metrics.NewCounter("component_actions_total", "Actions handled per component.", "component", "action")
+	actionErrors = metrics.NewCounter("component_action_errors_total", "Actions that returned an error per component.", "component", "action")
+)
+
 // Mixer - Time Code Processor struct
 type Mixer struct {
 
@@ -23,6 +32,9 @@ type Mixer struct {
 	UserAuth      *comms.UserAuth
 	MixerControl  *components.MixerControl
 	Factory       *Factory
+	Updater       *Updater
+	Audit         *components.Audit
+	Setup         *Setup
 }
 
 // NewMixer - Instantiates the device's Mixer object
@@ -46,10 +58,38 @@ func NewMixer() *Mixer {
 	mixer.ComponentList[mixerControl.Name] = mixerControl
 	mixer.MixerControl = mixerControl
 
+	recipes := components.NewRecipes(mixer.cfgService, mixerControl)
+	mixer.ComponentList[recipes.Name] = recipes
+
+	orders := components.NewOrders(mixer.cfgService, mixerControl, recipes)
+	mixer.ComponentList[orders.Name] = orders
+
 	factory := NewFactory(mixer.cfgService)
 	mixer.ComponentList[factory.Name] = factory
 	mixer.Factory = factory
 
+	setup := NewSetup(mixer.cfgService, userAuth, factory)
+	mixer.ComponentList[setup.Name] = setup
+	mixer.Setup = setup
+
+	audit := components.NewAudit(mixer.cfgService)
+	mixer.ComponentList[audit.Name] = audit
+	mixer.Audit = audit
+	events.Subscribe(comms.LockoutEvent, audit.RecordEvent)
+	events.Subscribe(components.LowStockEvent, audit.RecordEvent)
+	events.Subscribe(components.EmptyEvent, audit.RecordEvent)
+	events.Subscribe(components.EmergencyStopEvent, audit.RecordEvent)
+
+	eventsComponent := components.NewEvents(mixer.cfgService)
+	mixer.ComponentList[eventsComponent.Name] = eventsComponent
+
+	metricsComponent := components.NewMetrics(mixer.cfgService)
+	mixer.ComponentList[metricsComponent.Name] = metricsComponent
+
+	updater := NewUpdater(mixer.cfgService, update.NewSlots(update.DefaultSlotRoot), mixer.Reset)
+	mixer.ComponentList[updater.Name] = updater
+	mixer.Updater = updater
+
 	// Create and Initialize database tables if needed
 	mixer.cfgService.Initialize()
 
@@ -91,8 +131,82 @@ func (mixer *Mixer) PowerOff() {
 
 }
 
+// Request - Executes an IPC request on behalf of the caller described by 'header', recording
+// every state changing action in the audit trail
+func (mixer *Mixer) Request(header comms.Header, data []byte) (response []byte, err error) {
+
+	caller := mixer.Caller(header)
+
+	if !mixer.Setup.Provisioned() && !mixer.Setup.Allowed(header.Target, header.Action) {
+		err = components.PermissionError{Action: header.Action, Reason: "is unavailable until device setup is complete"}
+		if isStateChanging(header.Action) {
+			mixer.Audit.Record(caller, header.Target, header.Action, data, err)
+		}
+		return nil, err
+	}
+
+	if !comms.ScopeAllows(caller.Scope, header.Target, header.Action) {
+		return nil, components.PermissionError{Action: header.Action, Reason: "is not available to this session"}
+	}
+
+	if isStateChanging(header.Action) {
+		// Reboot and PowerOff never return, record them up front
+		if header.Action == "Reboot" || header.Action == "PowerOff" {
+			err = caller.RequireOperator(header.Action)
+			mixer.Audit.Record(caller, header.Target, header.Action, data, err)
+			if err != nil {
+				return nil, err
+			}
+			return mixer.ActionAs(caller, header.Target, header.Action, data)
+		}
+		defer func() {
+			// A panicking action still failed, record it before unwinding further
+			if r := recover(); r != nil {
+				mixer.Audit.Record(caller, header.Target, header.Action, data, fmt.Errorf("panic: %v", r))
+				panic(r)
+			}
+			mixer.Audit.Record(caller, header.Target, header.Action, data, err)
+		}()
+	}
+
+	return mixer.ActionAs(caller, header.Target, header.Action, data)
+}
+
+// Caller - Resolves the session in 'header' to the user it belongs to
+func (mixer *Mixer) Caller(header comms.Header) components.Caller {
+
+	caller := components.Caller{Source: header.Source, Peer: header.Peer}
+
+	session, ok := mixer.UserAuth.Session(header.Session)
+	if ok {
+		caller.Session = session.Token
+		caller.Username = session.Username
+		caller.Role = session.Role
+		caller.Scope = session.Scope
+		caller.IsAdmin = session.IsAdmin
+	}
+
+	return caller
+}
+
+// isStateChanging - Actions that only read state are not audited
+func isStateChanging(action string) bool {
+	for _, prefix := range []string{"Get", "List", "Query", "Export"} {
+		if strings.HasPrefix(action, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
 // Action - Iterates through the clock's available objects and executes an 'action'
 func (mixer *Mixer) Action(target string, action string, data []byte) (response []byte, err error) {
+	return mixer.ActionAs(components.Caller{}, target, action, data)
+}
+
+// ActionAs - Executes an 'action' for 'caller', components implementing CallerActionIf are told
+// who the caller is
+func (mixer *Mixer) ActionAs(caller components.Caller, target string, action string, data []byte) (response []byte, err error) {
 
 	if action == "Reboot" {
 
@@ -113,12 +227,58 @@ func (mixer *Mixer) Action(target string, action string, data []byte) (response
 	for key, val := range mixer.ComponentList {
 
 		if target == key {
-			response, err := val.Action(action, data)
+			var response []byte
+			var err error
+			if callerAware, ok := val.(components.CallerActionIf); ok {
+				response, err = callerAware.CallerAction(caller, action, data)
+			} else {
+				response, err = val.Action(action, data)
+			}
+			actionCount.Inc(target, action)
+			if err != nil {
+				actionErrors.Inc(target, action)
+			}
 			return response, err
 		}
 	}
 
+	actionErrors.Inc("unknown", "unknown")
 	response = nil
 	err = fmt.Errorf("Failed to find target: %s", target)
 	return
 }
+
+// HealthReport - Result of checking the database and every component that supports it
+type HealthReport struct {
+	Healthy    bool              `json:"healthy"`
+	Database   string            `json:"database"`
+	Components map[string]string `json:"components"`
+}
+
+// Health - Checks the database and each component implementing HealthCheckerIf
+func (mixer *Mixer) Health() HealthReport {
+
+	report := HealthReport{Healthy: true, Database: "ok", Components: make(map[string]string)}
+
+	err := mixer.cfgService.Health()
+	if err != nil {
+		report.Healthy = false
+		report.Database = err.Error()
+	}
+
+	for name, component := range mixer.ComponentList {
+		checker, ok := component.(components.HealthCheckerIf)
+		if !ok {
+			continue
+		}
+		err = checker.Health()
+		if err != nil {
+			report.Healthy = false
+			report.Components[name] = err.Error()
+		} else {
+			report.Components[name] = "ok"
+		}
+	}
+
+	return report
+}
@@ -51,6 +51,11 @@ func (fact *Factory) Stop() error {
 
 // Action -
 func (fact *Factory) Action(action string, data []byte) (response []byte, err error) {
+	return fact.CallerAction(components.Caller{}, action, data)
+}
+
+// CallerAction - Anyone may read the network settings, only admins change them
+func (fact *Factory) CallerAction(caller components.Caller, action string, data []byte) (response []byte, err error) {
 	var mapData map[string]interface{}
 	mapData, err = config.JsonToMap(data)
 	if err != nil {
@@ -62,6 +67,10 @@ func (fact *Factory) Action(action string, data []byte) (response []byte, err er
 		response, err = fact.ConfigService.Get(fact.Name)
 
 	case "SetNetwork":
+		err = caller.RequireAdmin(action)
+		if err != nil {
+			return
+		}
 		response, err = fact.setNetworkInfo(mapData, "ui")
 
 	default:
@@ -0,0 +1,155 @@
+package sensor
+
+import (
+	"fmt"
+	"sync"
+	"tech/app/gpio"
+	"time"
+)
+
+const (
+	// DefaultMlPerPulse - Typical of the small hall effect meters sold for drinks dispensers
+	DefaultMlPerPulse = 0.17
+
+	// flowPoll - How often the counter checks it has been told to stop
+	flowPoll = 100 * time.Millisecond
+)
+
+// FlowMeter - Pulse counting flow meters, one per channel, on GPIO inputs. Each rising
+// edge is 'mlPerPulse' ml through the meter
+type FlowMeter struct {
+	pins       []gpio.Input
+	mlPerPulse float64
+
+	lock   sync.Mutex
+	pulses int
+	err    error
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// OpenFlowMeter - Opens the meter of each channel on GPIO 'pins', as numbered by the kernel.
+// -1 leaves a channel without a meter
+func OpenFlowMeter(pins []int, mlPerPulse float64) (*FlowMeter, error) {
+
+	inputs := make([]gpio.Input, len(pins))
+	for channel, pin := range pins {
+		if pin < 0 {
+			continue
+		}
+		input, err := gpio.Open(pin)
+		if err != nil {
+			closeInputs(inputs)
+			return nil, fmt.Errorf("channel %d flow meter: %v", channel, err)
+		}
+		inputs[channel] = input
+	}
+	meter, err := NewFlowMeter(inputs, mlPerPulse)
+	if err != nil {
+		closeInputs(inputs)
+		return nil, err
+	}
+	return meter, nil
+}
+
+// NewFlowMeter - Counts pulses on 'inputs', indexed by channel, e.g. gpio.Fakes. A nil input
+// leaves that channel without a meter
+func NewFlowMeter(inputs []gpio.Input, mlPerPulse float64) (*FlowMeter, error) {
+
+	if mlPerPulse <= 0 {
+		return nil, fmt.Errorf("ml per pulse must be positive")
+	}
+	for _, input := range inputs {
+		if input == nil {
+			continue
+		}
+		err := input.SetEdge(gpio.EdgeRising)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &FlowMeter{pins: inputs, mlPerPulse: mlPerPulse}, nil
+}
+
+func closeInputs(inputs []gpio.Input) {
+	for _, input := range inputs {
+		if input != nil {
+			input.Close()
+		}
+	}
+}
+
+// Name -
+func (meter *FlowMeter) Name() string {
+	return KindFlow
+}
+
+// Begin - Starts counting the pulses of 'channel”s meter from zero
+func (meter *FlowMeter) Begin(channel int) error {
+
+	if channel < 0 || channel >= len(meter.pins) || meter.pins[channel] == nil {
+		return ErrNotFitted
+	}
+
+	meter.lock.Lock()
+	defer meter.lock.Unlock()
+	if meter.stop != nil {
+		return fmt.Errorf("already measuring")
+	}
+	meter.pulses = 0
+	meter.err = nil
+	meter.stop = make(chan struct{})
+	meter.done = make(chan struct{})
+	go meter.count(meter.pins[channel], meter.stop, meter.done)
+	return nil
+}
+
+func (meter *FlowMeter) count(input gpio.Input, stop <-chan struct{}, done chan<- struct{}) {
+
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		edge, err := input.WaitEdge(flowPoll)
+		meter.lock.Lock()
+		if edge {
+			meter.pulses++
+		}
+		meter.err = err
+		meter.lock.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Volume -
+func (meter *FlowMeter) Volume() (float64, error) {
+	meter.lock.Lock()
+	defer meter.lock.Unlock()
+	return float64(meter.pulses) * meter.mlPerPulse, meter.err
+}
+
+// End - Stops counting
+func (meter *FlowMeter) End() {
+	meter.lock.Lock()
+	stop, done := meter.stop, meter.done
+	meter.stop = nil
+	meter.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Close -
+func (meter *FlowMeter) Close() error {
+	meter.End()
+	closeInputs(meter.pins)
+	return nil
+}
@@ -0,0 +1,99 @@
+package sensor
+
+import (
+	"fmt"
+	"tech/app/gpio"
+	"time"
+)
+
+const (
+	// hx711Bits - Bits in each conversion, two's complement
+	hx711Bits = 24
+
+	// hx711GainPulses - Extra clock pulses after a conversion, one selects channel A at
+	// gain 128 for the next
+	hx711GainPulses = 1
+
+	// hx711Ready - Longer than a conversion at the slow 10 Hz rate
+	hx711Ready = 200 * time.Millisecond
+)
+
+// HX711 - Load cell amplifier, bit banged over a data input and a clock output. Holding the
+// clock high for 60 µs powers the chip down, so reads must not be held up
+type HX711 struct {
+	data  gpio.Input
+	clock gpio.Output
+}
+
+// OpenHX711 - Opens the amplifier with its DOUT on GPIO 'data' and PD_SCK on GPIO 'clock'
+func OpenHX711(data int, clock int) (*HX711, error) {
+	input, err := gpio.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	output, err := gpio.OpenOutput(clock)
+	if err != nil {
+		input.Close()
+		return nil, err
+	}
+	return NewHX711(input, output)
+}
+
+// NewHX711 - Drives an amplifier on 'data' and 'clock', e.g. gpio fakes
+func NewHX711(data gpio.Input, clock gpio.Output) (*HX711, error) {
+	err := clock.Write(false)
+	if err != nil {
+		return nil, err
+	}
+	return &HX711{data: data, clock: clock}, nil
+}
+
+// Read - Waits for a conversion and clocks it out
+func (adc *HX711) Read() (int32, error) {
+
+	// DOUT goes low once a conversion is ready
+	deadline := time.Now().Add(hx711Ready)
+	for {
+		busy, err := adc.data.Read()
+		if err != nil {
+			return 0, err
+		}
+		if !busy {
+			break
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("the HX711 is not converting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var value uint32
+	for bit := 0; bit < hx711Bits+hx711GainPulses; bit++ {
+		err := adc.clock.Write(true)
+		if err != nil {
+			return 0, err
+		}
+		level, err := adc.data.Read()
+		if err == nil {
+			err = adc.clock.Write(false)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if bit < hx711Bits {
+			value <<= 1
+			if level {
+				value |= 1
+			}
+		}
+	}
+
+	// Sign extend from 24 bits
+	return int32(value<<8) >> 8, nil
+}
+
+// Close -
+func (adc *HX711) Close() error {
+	adc.clock.Close()
+	return adc.data.Close()
+}
@@ -0,0 +1,137 @@
+package sensor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shantanubhadoria/go-kalmanfilter/kalmanfilter"
+)
+
+const (
+	// tareSamples - Readings averaged to zero the scale at the start of a pour
+	tareSamples = 8
+
+	// waterDensity - g/ml, close enough for spirits and mixers
+	waterDensity = 1.0
+
+	// Kalman filter noise. The weight may change by a few g²/s while pouring, while
+	// readings scatter by around 2 g
+	weightNoise      = 25.0
+	measurementNoise = 4.0
+)
+
+// Scale - Raw readings from a load cell amplifier, such as the HX711
+type Scale interface {
+	Read() (int32, error)
+	Close() error
+}
+
+// LoadCell - A load cell under the cup. Readings are converted to grams with
+// 'countsPerGram' and smoothed with a Kalman filter, then to ml assuming the density of water
+type LoadCell struct {
+	scale         Scale
+	countsPerGram float64
+
+	lock   sync.Mutex
+	filter kalmanfilter.FilterData
+	tare   float64
+	err    error
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewLoadCell - Weighs with 'scale', which reads 'countsPerGram' counts more for each gram
+func NewLoadCell(scale Scale, countsPerGram float64) (*LoadCell, error) {
+	if countsPerGram == 0 {
+		return nil, fmt.Errorf("counts per gram must not be zero")
+	}
+	return &LoadCell{scale: scale, countsPerGram: countsPerGram}, nil
+}
+
+// Name -
+func (cell *LoadCell) Name() string {
+	return KindLoadCell
+}
+
+// Begin - Tares the scale with the cup as it stands, then keeps weighing until End. The
+// one scale measures every channel
+func (cell *LoadCell) Begin(channel int) error {
+
+	cell.lock.Lock()
+	defer cell.lock.Unlock()
+	if cell.stop != nil {
+		return fmt.Errorf("already measuring")
+	}
+
+	total := 0.0
+	for sample := 0; sample < tareSamples; sample++ {
+		raw, err := cell.scale.Read()
+		if err != nil {
+			return err
+		}
+		total += float64(raw)
+	}
+	cell.tare = total / tareSamples
+
+	// The bias term models a drifting rate sensor, there is none so it stays at zero
+	cell.filter = kalmanfilter.FilterData{QAngle: weightNoise, RMeasure: measurementNoise}
+	cell.err = nil
+	cell.stop = make(chan struct{})
+	cell.done = make(chan struct{})
+	go cell.weigh(cell.stop, cell.done)
+	return nil
+}
+
+func (cell *LoadCell) weigh(stop <-chan struct{}, done chan<- struct{}) {
+
+	defer close(done)
+	last := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		raw, err := cell.scale.Read()
+		now := time.Now()
+		cell.lock.Lock()
+		cell.err = err
+		if err == nil {
+			grams := (float64(raw) - cell.tare) / cell.countsPerGram
+			cell.filter.Update(grams, 0, now.Sub(last).Seconds())
+		}
+		cell.lock.Unlock()
+		last = now
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Volume - The smoothed weight gained since Begin, in ml
+func (cell *LoadCell) Volume() (float64, error) {
+	cell.lock.Lock()
+	defer cell.lock.Unlock()
+	return cell.filter.State / waterDensity, cell.err
+}
+
+// End - Stops weighing
+func (cell *LoadCell) End() {
+	cell.lock.Lock()
+	stop, done := cell.stop, cell.done
+	cell.stop = nil
+	cell.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Close -
+func (cell *LoadCell) Close() error {
+	cell.End()
+	return cell.scale.Close()
+}
@@ -0,0 +1,85 @@
+package sensor
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Sensors selectable with the Host's -sensor flag
+const (
+	KindNone      = "none"
+	KindFlow      = "flow"
+	KindLoadCell  = "loadcell"
+	KindSimulated = "sim"
+)
+
+// ErrNotFitted - The sensor cannot measure the channel asked for, the pour runs open loop
+var ErrNotFitted = errors.New("no sensor on this channel")
+
+// Sensor - Measures what a pour delivers. Begin zeroes the measurement for a pour on
+// 'channel', Volume reports the ml delivered since and End stops measuring
+type Sensor interface {
+	Name() string
+	Begin(channel int) error
+	Volume() (float64, error)
+	End()
+	Close() error
+}
+
+// Fake - In memory sensor for running pours off-device. Add reports liquid delivered
+type Fake struct {
+	lock      sync.Mutex
+	measuring bool
+	ml        float64
+}
+
+// NewFake -
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+// Add - Reports 'ml' delivered, ignored unless measuring
+func (fake *Fake) Add(ml float64) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if fake.measuring {
+		fake.ml += ml
+	}
+}
+
+// Name -
+func (fake *Fake) Name() string {
+	return KindSimulated
+}
+
+// Begin -
+func (fake *Fake) Begin(channel int) error {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if fake.measuring {
+		return fmt.Errorf("already measuring")
+	}
+	fake.measuring = true
+	fake.ml = 0
+	return nil
+}
+
+// Volume -
+func (fake *Fake) Volume() (float64, error) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return fake.ml, nil
+}
+
+// End -
+func (fake *Fake) End() {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.measuring = false
+}
+
+// Close -
+func (fake *Fake) Close() error {
+	return nil
+}
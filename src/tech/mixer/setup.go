@@ -0,0 +1,357 @@
+package mixer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"tech/app/comms"
+	"tech/app/components"
+	"tech/app/logger"
+	"tech/mixer/config"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	setupName = "setup"
+
+	defaultTimezone = "UTC"
+	zoneInfoDir     = "/usr/share/zoneinfo"
+	localtimePath   = "/etc/localtime"
+
+	maxDeviceNameLength = 64
+
+	// The account shipped next to admin, disabled at the end of setup unless its password changed
+	defaultUser         = "user"
+	defaultUserPassword = "user"
+)
+
+// Setup steps, each must be done before Finish
+const (
+	stepPassword   = "password"
+	stepDeviceName = "deviceName"
+	stepNetwork    = "network"
+	stepTimezone   = "timezone"
+)
+
+// SetupStatus - Progress of first boot provisioning
+type SetupStatus struct {
+	Provisioned bool            `json:"provisioned"`
+	DeviceName  string          `json:"deviceName"`
+	Timezone    string          `json:"timezone"`
+	Steps       map[string]bool `json:"steps"`
+}
+
+// Setup - First boot provisioning. Until an admin has replaced the default password, named
+// the device and confirmed its network and timezone, the Mixer only accepts setup actions.
+// The provisioned flag lives in the database, so a factory reset starts setup again
+type Setup struct {
+	components.MixerComponent
+
+	userAuth *comms.UserAuth
+	factory  *Factory
+
+	lock        sync.Mutex
+	provisioned bool
+}
+
+// NewSetup -
+func NewSetup(cfg *config.CfgService, userAuth *comms.UserAuth, factory *Factory) *Setup {
+
+	setup := &Setup{userAuth: userAuth, factory: factory}
+	setup.Name = setupName
+	setup.ConfigService = cfg
+
+	cfg.Register(setup.Name, setup.createTable)
+
+	return setup
+}
+
+// Action -
+func (setup *Setup) Action(action string, data []byte) (response []byte, err error) {
+	return setup.CallerAction(components.Caller{}, action, data)
+}
+
+// CallerAction - Anyone may read the status, the steps need an admin session and are only
+// accepted until setup is finished
+func (setup *Setup) CallerAction(caller components.Caller, action string, data []byte) (response []byte, err error) {
+
+	if action == "GetStatus" {
+		return setup.statusJSON()
+	}
+
+	err = caller.RequireAdmin(action)
+	if err != nil {
+		return nil, err
+	}
+	if setup.Provisioned() {
+		return nil, fmt.Errorf("device is already provisioned")
+	}
+
+	mapData := make(map[string]interface{})
+	if len(data) > 0 {
+		mapData, err = config.JsonToMap(data)
+		if err != nil {
+			logger.Log("Failed to unmarshall data on '%s'", setup.Name)
+			return
+		}
+	}
+
+	switch action {
+	case "SetPassword":
+		password, _ := mapData["newPassword"].(string)
+		err = setup.setPassword(caller, password)
+
+	case "SetDeviceName":
+		name, _ := mapData["deviceName"].(string)
+		err = setup.setDeviceName(name)
+
+	case "ConfirmNetwork":
+		err = setup.confirmNetwork(mapData)
+
+	case "SetTimezone":
+		timezone, _ := mapData["timezone"].(string)
+		err = setup.setTimezone(timezone)
+
+	case "Finish":
+		err = setup.finish(caller)
+
+	default:
+		logger.Log("Unrecognized action received in '%s': '%s'", setup.Name, action)
+		return
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return setup.statusJSON()
+}
+
+// Start - Loads the provisioned flag
+func (setup *Setup) Start() error {
+	status, err := setup.Status()
+	if err != nil {
+		return err
+	}
+
+	setup.lock.Lock()
+	setup.provisioned = status.Provisioned
+	setup.lock.Unlock()
+
+	if !status.Provisioned {
+		logger.Log("Device is not provisioned, only setup actions are accepted")
+	}
+	return nil
+}
+
+// Stop -
+func (setup *Setup) Stop() error {
+	return nil
+}
+
+func (setup *Setup) createTable(cfg *config.CfgService) (err error) {
+	setupSchema := []string{
+		"provisioned INTEGER",
+		"provisionedAt TEXT",
+		"deviceName TEXT",
+		"timezone TEXT",
+		"passwordChanged INTEGER",
+		"networkConfirmed INTEGER",
+		"timezoneConfirmed INTEGER"}
+
+	setupDefaults := map[string]string{
+		"provisioned":       "0",
+		"provisionedAt":     "''",
+		"deviceName":        "''",
+		"timezone":          "'" + defaultTimezone + "'",
+		"passwordChanged":   "0",
+		"networkConfirmed":  "0",
+		"timezoneConfirmed": "0"}
+
+	err = cfg.CreateTable(setup.Name, setupSchema)
+	if err != nil {
+		return
+	}
+
+	return cfg.InitTable(setup.Name, setupDefaults)
+}
+
+// Provisioned - Reports whether setup has been finished
+func (setup *Setup) Provisioned() bool {
+	setup.lock.Lock()
+	defer setup.lock.Unlock()
+	return setup.provisioned
+}
+
+// Allowed - Actions accepted before the device is provisioned: the setup steps themselves,
+// logging in to perform them and reading metrics
+func (setup *Setup) Allowed(target string, action string) bool {
+	switch target {
+	case setupName, "metrics":
+		return true
+	case setup.userAuth.Name:
+		return action == "Login" || action == "Logout"
+	}
+	return false
+}
+
+// Status - Reads the provisioning state from the database
+func (setup *Setup) Status() (SetupStatus, error) {
+
+	var status SetupStatus
+	data, err := setup.ConfigService.Get(setup.Name)
+	if err != nil {
+		return status, err
+	}
+	row, err := config.JsonToMap(data)
+	if err != nil {
+		return status, err
+	}
+
+	status.Provisioned, _ = config.JSONbool(row["provisioned"])
+	status.DeviceName, _ = config.JSONstring(row["deviceName"])
+	status.Timezone, _ = config.JSONstring(row["timezone"])
+
+	status.Steps = make(map[string]bool)
+	status.Steps[stepPassword], _ = config.JSONbool(row["passwordChanged"])
+	status.Steps[stepDeviceName] = status.DeviceName != ""
+	status.Steps[stepNetwork], _ = config.JSONbool(row["networkConfirmed"])
+	status.Steps[stepTimezone], _ = config.JSONbool(row["timezoneConfirmed"])
+
+	return status, nil
+}
+
+func (setup *Setup) statusJSON() ([]byte, error) {
+	status, err := setup.Status()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(status, "", "\t")
+}
+
+// setPassword - Replaces the calling admin's shipped password
+func (setup *Setup) setPassword(caller components.Caller, password string) error {
+
+	if password == caller.Username || setup.userAuth.HasPassword(caller.Username, password) {
+		return fmt.Errorf("choose a password different from the current one")
+	}
+
+	err := setup.userAuth.SetPassword(caller.Username, password)
+	if err != nil {
+		return err
+	}
+
+	return setup.ConfigService.SetValue(setup.Name, "passwordChanged", true)
+}
+
+func (setup *Setup) setDeviceName(name string) error {
+
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxDeviceNameLength {
+		return fmt.Errorf("device name must be 1 to %d characters", maxDeviceNameLength)
+	}
+
+	return setup.ConfigService.SetValue(setup.Name, "deviceName", name)
+}
+
+// confirmNetwork - Applies new network settings if they are given, otherwise accepts the
+// current ones
+func (setup *Setup) confirmNetwork(data map[string]interface{}) error {
+
+	if _, ok := data["ipAddress"]; ok {
+		_, err := setup.factory.SetNetworkInfo(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return setup.ConfigService.SetValue(setup.Name, "networkConfirmed", true)
+}
+
+// setTimezone - Accepts IANA names such as "Europe/London" and points /etc/localtime at them
+func (setup *Setup) setTimezone(timezone string) error {
+
+	if timezone == "" || strings.Contains(timezone, "..") {
+		return fmt.Errorf("invalid timezone '%s'", timezone)
+	}
+	_, err := time.LoadLocation(timezone)
+	if err != nil {
+		return fmt.Errorf("unknown timezone '%s'", timezone)
+	}
+
+	err = applyTimezone(timezone)
+	if err != nil {
+		// Development machines may not allow this, the setting is still kept
+		logger.Log("Unable to set system timezone to '%s', %v", timezone, err)
+	}
+
+	err = setup.ConfigService.SetValue(setup.Name, "timezone", timezone)
+	if err != nil {
+		return err
+	}
+	return setup.ConfigService.SetValue(setup.Name, "timezoneConfirmed", true)
+}
+
+// applyTimezone - Swaps the /etc/localtime link atomically
+func applyTimezone(timezone string) error {
+
+	zoneFile := filepath.Join(zoneInfoDir, timezone)
+	if _, err := os.Stat(zoneFile); err != nil {
+		return err
+	}
+
+	tmpLink := localtimePath + ".tmp"
+	os.Remove(tmpLink)
+	err := os.Symlink(zoneFile, tmpLink)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpLink, localtimePath)
+}
+
+// finish - Marks the device provisioned once every step is done. The shipped 'user' account
+// is disabled if it still has its shipped password
+func (setup *Setup) finish(caller components.Caller) error {
+
+	status, err := setup.Status()
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, step := range []string{stepPassword, stepDeviceName, stepNetwork, stepTimezone} {
+		if !status.Steps[step] {
+			missing = append(missing, step)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("setup incomplete, missing %s", strings.Join(missing, ", "))
+	}
+
+	if setup.userAuth.HasPassword(defaultUser, defaultUserPassword) {
+		err = setup.userAuth.Disable(defaultUser)
+		if err != nil {
+			return err
+		}
+		logger.Log("Disabled '%s', it still had its factory password", defaultUser)
+	}
+
+	err = setup.ConfigService.SetValue(setup.Name, "provisionedAt", time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	err = setup.ConfigService.SetValue(setup.Name, "provisioned", true)
+	if err != nil {
+		return err
+	}
+
+	setup.lock.Lock()
+	setup.provisioned = true
+	setup.lock.Unlock()
+	logger.Log("Device '%s' provisioned by '%s'", status.DeviceName, caller.Username)
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package mixer
+
+import (
+	"encoding/json"
+	"fmt"
+	"tech/app/components"
+	"tech/app/logger"
+	"tech/app/update"
+	"tech/mixer/config"
+	"time"
+)
+
+const (
+	updaterName = "update"
+
+	// UpdatePublicKey - On-device public key used to verify update bundles
+	UpdatePublicKey = "/data/update/update_pub.pem"
+
+	// healthTimeout - Time a freshly installed slot has to prove itself healthy
+	healthTimeout = 2 * time.Minute
+	healthPoll    = 5 * time.Second
+	restartDelay  = 2 * time.Second
+)
+
+// Updater - Installs signed update bundles into the inactive slot and rolls back failed installs
+type Updater struct {
+	components.MixerComponent
+
+	Slots   *update.Slots
+	restart func()
+}
+
+// NewUpdater -
+func NewUpdater(cfg *config.CfgService, slots *update.Slots, restart func()) *Updater {
+	updater := &Updater{}
+	updater.Name = updaterName
+	updater.ConfigService = cfg
+	updater.Slots = slots
+	updater.restart = restart
+
+	return updater
+}
+
+// Start -
+func (upd *Updater) Start() error {
+	return nil
+}
+
+// Stop -
+func (upd *Updater) Stop() error {
+	return nil
+}
+
+// Action -
+func (upd *Updater) Action(action string, data []byte) (response []byte, err error) {
+	return upd.CallerAction(components.Caller{}, action, data)
+}
+
+// CallerAction - Uploading, installing and rolling back are for admins only
+func (upd *Updater) CallerAction(caller components.Caller, action string, data []byte) (response []byte, err error) {
+	var mapData map[string]interface{}
+	mapData, err = config.JsonToMap(data)
+	if err != nil {
+		logger.Log("Failed to unmarshall data on '%s'", upd.Name)
+		return
+	}
+
+	switch action {
+	case "GetStatus":
+		response, err = upd.getStatus()
+
+	case "GetUploadPermission":
+		// Asked by the Server before accepting an upload, bundles are only taken from admins
+		err = caller.RequireAdmin(action)
+		if err == nil {
+			response = []byte("{}")
+		}
+
+	case "Install":
+		err = caller.RequireAdmin(action)
+		if err != nil {
+			return
+		}
+		path, _ := config.JSONstring(mapData["path"])
+		response, err = upd.install(path)
+
+	case "Rollback":
+		err = caller.RequireAdmin(action)
+		if err != nil {
+			return
+		}
+		response, err = upd.rollback()
+
+	default:
+		logger.Log("Unrecognized action received in '%s': '%s'", upd.Name, action)
+	}
+
+	return
+}
+
+// Boot - Counts a start attempt of a pending slot, restarting into the previous slot if it
+// has failed too many times
+func (upd *Updater) Boot() {
+
+	rolledBack, err := upd.Slots.Boot()
+	if err != nil {
+		logger.Log("Failed to check update slot state, %v", err)
+		return
+	}
+	if rolledBack {
+		logger.Log("Pending update failed to start %d times, rolled back", update.MaxBootAttempts)
+		upd.restart()
+	}
+}
+
+// ConfirmHealthy - Waits for 'healthy' to report true and confirms the active slot. A pending
+// slot which does not become healthy within healthTimeout is rolled back
+func (upd *Updater) ConfirmHealthy(healthy func() bool) {
+
+	state, err := upd.Slots.State()
+	if err != nil || !state.Pending {
+		return
+	}
+
+	deadline := time.Now().Add(healthTimeout)
+	for time.Now().Before(deadline) {
+		if healthy() {
+			err = upd.Slots.MarkHealthy()
+			if err != nil {
+				logger.Log("Failed to confirm update '%s', %v", state.Version, err)
+				return
+			}
+			logger.Log("Update '%s' confirmed healthy", state.Version)
+			return
+		}
+		time.Sleep(healthPoll)
+	}
+
+	logger.Log("Update '%s' failed its health check, rolling back", state.Version)
+	err = upd.Slots.Rollback()
+	if err != nil {
+		logger.Log("Rollback failed, %v", err)
+		return
+	}
+	upd.restart()
+}
+
+func (upd *Updater) getStatus() ([]byte, error) {
+
+	state, err := upd.Slots.State()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(state, "", "\t")
+}
+
+func (upd *Updater) install(path string) ([]byte, error) {
+
+	if path == "" {
+		return nil, fmt.Errorf("no bundle path given")
+	}
+
+	key, err := update.LoadPublicKey(UpdatePublicKey)
+	if err != nil {
+		logger.Log("Unable to load update public key, %v", err)
+		return nil, err
+	}
+
+	manifest, err := upd.Slots.Stage(path, key)
+	if err != nil {
+		logger.Log("Rejected update bundle '%s', %v", path, err)
+		return nil, err
+	}
+
+	err = upd.Slots.Switch(manifest)
+	if err != nil {
+		logger.Log("Failed to switch to update '%s', %v", manifest.Version, err)
+		return nil, err
+	}
+
+	logger.Log("Installed update '%s', restarting", manifest.Version)
+	upd.scheduleRestart()
+
+	return upd.getStatus()
+}
+
+func (upd *Updater) rollback() ([]byte, error) {
+
+	err := upd.Slots.Rollback()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log("Rolled back update, restarting")
+	upd.scheduleRestart()
+
+	return upd.getStatus()
+}
+
+// scheduleRestart - Gives the response time to reach the client before restarting
+func (upd *Updater) scheduleRestart() {
+	time.AfterFunc(restartDelay, upd.restart)
+}
+
+// Health - Reports unhealthy if the slot state cannot be read
+func (upd *Updater) Health() error {
+	_, err := upd.Slots.State()
+	return err
+}